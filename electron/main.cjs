@@ -697,7 +697,47 @@ function setupIpcHandlers() {
     });
 
     // Remove background from image
-    ipcMain.handle('remove-background', async (event, { imageSrc }) => {
+    ipcMain.handle('remove-background', async (event, { imageSrc, tolerance, softness }) => {
+        // Prefer the Go processor: flood-fills only edge-connected background
+        const scraperPath = path.join(__dirname, '..', 'scraper', 'repic-scraper.exe');
+        if (fs.existsSync(scraperPath)) {
+            const result = await new Promise((resolve) => {
+                const args = [
+                    '--remove-bg',
+                    '--input', imageSrc.startsWith('data:') ? '-' : imageSrc,
+                    '--tolerance', String(tolerance ?? 40),
+                    '--softness', String(softness ?? 1)
+                ];
+
+                const proc = spawn(scraperPath, args);
+                let stdout = '';
+
+                proc.stdout.on('data', (data) => { stdout += data.toString(); });
+                proc.on('close', (code) => {
+                    if (code === 0 && stdout) {
+                        try {
+                            resolve(JSON.parse(stdout.trim()));
+                        } catch (e) {
+                            resolve({ success: false, error: 'Parse error' });
+                        }
+                    } else {
+                        resolve({ success: false, error: `Exit code ${code}` });
+                    }
+                });
+                proc.on('error', (err) => resolve({ success: false, error: err.message }));
+
+                // Data URLs can exceed the command line limit; send them on stdin
+                if (imageSrc.startsWith('data:')) {
+                    proc.stdin.end(imageSrc);
+                } else {
+                    proc.stdin.end();
+                }
+            });
+            if (result.success || !sharp) {
+                return result;
+            }
+        }
+
         if (!sharp) {
             return { success: false, error: 'sharp module not available' };
         }
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// ============ ANNOTATE MODE ============
+
+// Drawing defaults, mirroring src/constants/drawing.js
+const (
+	annotateStrokeWidth     = 3
+	annotateStrokeColor     = "#0066FF"
+	annotateBlurAmount      = 10
+	annotateArrowHeadLength = 15
+	annotateMosaicSize      = 10 // drawMosaic's default pixelSize
+	annotateFontSize        = 24
+)
+
+// Annotation is one shape in image coordinates, in the same form the editor
+// stores (type, x, y, width, height; unit "%" is relative to the image size).
+// Paths use Points; text uses Text and FontSize.
+type Annotation struct {
+	Type        string       `json:"type"` // path, arrow, rect, circle/ellipse, text, blur, mosaic/pixelate
+	X           float64      `json:"x"`
+	Y           float64      `json:"y"`
+	Width       float64      `json:"width"`
+	Height      float64      `json:"height"`
+	Unit        string       `json:"unit,omitempty"`
+	Points      [][2]float64 `json:"points,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	FontSize    float64      `json:"fontSize,omitempty"`
+	Color       string       `json:"color,omitempty"`
+	StrokeWidth float64      `json:"strokeWidth,omitempty"`
+	Amount      float64      `json:"amount,omitempty"` // blur radius or mosaic block size
+}
+
+// AnnotateOptions controls annotation rendering
+type AnnotateOptions struct {
+	Annotations  []Annotation
+	Scale        float64 // multiplies stroke widths, arrow heads and font sizes
+	KeepMetadata bool
+}
+
+// jsonArgBytes returns an inline JSON array flag value as-is, otherwise reads
+// the file it names
+func jsonArgBytes(s string) ([]byte, error) {
+	if trimmed := strings.TrimSpace(s); strings.HasPrefix(trimmed, "[") {
+		return []byte(trimmed), nil
+	}
+	return os.ReadFile(s)
+}
+
+// parseAnnotations reads a JSON array of annotations inline or from a file
+func parseAnnotations(s string) ([]Annotation, error) {
+	data, err := jsonArgBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("annotations: %v", err)
+	}
+
+	var anns []Annotation
+	if err := json.Unmarshal(data, &anns); err != nil {
+		return nil, fmt.Errorf("annotations: %v", err)
+	}
+	for i, a := range anns {
+		switch a.Type {
+		case "path", "arrow", "rect", "circle", "ellipse", "text", "blur", "mosaic", "pixelate":
+		default:
+			return nil, fmt.Errorf("annotation %d: unknown type %q", i, a.Type)
+		}
+		if a.Type == "path" && len(a.Points) == 0 {
+			return nil, fmt.Errorf("annotation %d: path needs points", i)
+		}
+		if a.Type == "text" && a.Text == "" {
+			return nil, fmt.Errorf("annotation %d: text needs text", i)
+		}
+		if a.Color != "" {
+			if _, err := parseHexColor(a.Color); err != nil {
+				return nil, fmt.Errorf("annotation %d: %v", i, err)
+			}
+		}
+	}
+	return anns, nil
+}
+
+// toPixels resolves a percentage annotation against a w x h image
+func (a Annotation) toPixels(w, h int) Annotation {
+	if a.Unit != "%" {
+		return a
+	}
+	sx, sy := float64(w)/100, float64(h)/100
+	a.X, a.Y, a.Width, a.Height = a.X*sx, a.Y*sy, a.Width*sx, a.Height*sy
+	points := make([][2]float64, len(a.Points))
+	for i, p := range a.Points {
+		points[i] = [2]float64{p[0] * sx, p[1] * sy}
+	}
+	a.Points = points
+	a.Unit = "px"
+	return a
+}
+
+// normalizedRect returns the annotation box with a positive size, clipped to bounds
+func (a Annotation) normalizedRect(bounds image.Rectangle) image.Rectangle {
+	x0, y0 := a.X, a.Y
+	x1, y1 := a.X+a.Width, a.Y+a.Height
+	r := image.Rect(int(math.Floor(math.Min(x0, x1))), int(math.Floor(math.Min(y0, y1))),
+		int(math.Ceil(math.Max(x0, x1))), int(math.Ceil(math.Max(y0, y1))))
+	return r.Intersect(bounds)
+}
+
+// strokeMask accumulates anti-aliased stroke coverage for one shape
+type strokeMask struct {
+	mask      *image.Alpha
+	halfWidth float64
+	dirty     image.Rectangle // area touched so far
+}
+
+func newStrokeMask(bounds image.Rectangle, width float64) *strokeMask {
+	return &strokeMask{mask: image.NewAlpha(bounds), halfWidth: math.Max(width, 0.5) / 2}
+}
+
+// segment strokes a line with round caps: coverage falls off over one pixel
+// around the distance halfWidth from the segment
+func (s *strokeMask) segment(x0, y0, x1, y1 float64) {
+	pad := s.halfWidth + 1
+	r := image.Rect(int(math.Floor(math.Min(x0, x1)-pad)), int(math.Floor(math.Min(y0, y1)-pad)),
+		int(math.Ceil(math.Max(x0, x1)+pad)), int(math.Ceil(math.Max(y0, y1)+pad))).Intersect(s.mask.Rect)
+	s.dirty = s.dirty.Union(r)
+
+	dx, dy := x1-x0, y1-y0
+	lenSq := dx*dx + dy*dy
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			// Distance from the pixel centre to the segment
+			cx, cy := float64(px)+0.5-x0, float64(py)+0.5-y0
+			t := 0.0
+			if lenSq > 0 {
+				t = math.Max(0, math.Min(1, (cx*dx+cy*dy)/lenSq))
+			}
+			ex, ey := cx-t*dx, cy-t*dy
+			cov := s.halfWidth + 0.5 - math.Sqrt(ex*ex+ey*ey)
+			if cov <= 0 {
+				continue
+			}
+			a := uint8(math.Min(1, cov)*255 + 0.5)
+			i := s.mask.PixOffset(px, py)
+			if a > s.mask.Pix[i] {
+				s.mask.Pix[i] = a
+			}
+		}
+	}
+}
+
+// polyline strokes consecutive points, optionally closing the shape
+func (s *strokeMask) polyline(points [][2]float64, closed bool) {
+	if len(points) == 1 {
+		s.segment(points[0][0], points[0][1], points[0][0], points[0][1])
+		return
+	}
+	for i := 1; i < len(points); i++ {
+		s.segment(points[i-1][0], points[i-1][1], points[i][0], points[i][1])
+	}
+	if closed && len(points) > 2 {
+		last := points[len(points)-1]
+		s.segment(last[0], last[1], points[0][0], points[0][1])
+	}
+}
+
+// ellipsePoints flattens an ellipse into a closed polygon fine enough that
+// chords stay within a quarter pixel of the curve
+func ellipsePoints(cx, cy, rx, ry float64) [][2]float64 {
+	r := math.Max(rx, ry)
+	n := 16
+	if r > 0.25 {
+		n = max(n, int(math.Ceil(math.Pi/math.Acos(1-0.25/r))))
+	}
+	points := make([][2]float64, n)
+	for i := range points {
+		t := 2 * math.Pi * float64(i) / float64(n)
+		points[i] = [2]float64{cx + rx*math.Cos(t), cy + ry*math.Sin(t)}
+	}
+	return points
+}
+
+// drawStroke renders one stroked annotation onto dst. The coverage mask only
+// spans the shape's padded bounding box, not the whole image.
+func drawStroke(dst *image.RGBA, a Annotation, c color.Color, width, scale float64) {
+	var lines [][2]float64 // polyline points
+	closed := false
+	var heads [][2]float64 // arrow head line ends, drawn from the arrow tip
+
+	switch a.Type {
+	case "path":
+		lines = a.Points
+	case "rect":
+		lines = [][2]float64{
+			{a.X, a.Y}, {a.X + a.Width, a.Y}, {a.X + a.Width, a.Y + a.Height}, {a.X, a.Y + a.Height},
+		}
+		closed = true
+	case "circle", "ellipse":
+		lines = ellipsePoints(a.X+a.Width/2, a.Y+a.Height/2, math.Abs(a.Width/2), math.Abs(a.Height/2))
+		closed = true
+	case "arrow":
+		// Same geometry as drawArrow: a shaft with two 30-degree head lines
+		toX, toY := a.X+a.Width, a.Y+a.Height
+		angle := math.Atan2(a.Height, a.Width)
+		head := annotateArrowHeadLength * scale
+		lines = [][2]float64{{a.X, a.Y}, {toX, toY}}
+		for _, side := range []float64{-1, 1} {
+			heads = append(heads, [2]float64{toX - head*math.Cos(angle+side*math.Pi/6), toY - head*math.Sin(angle+side*math.Pi/6)})
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	// Bounding box of every point, padded by the stroke and its anti-aliased edge
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range append(append([][2]float64(nil), lines...), heads...) {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	pad := math.Max(width, 0.5)/2 + 1
+	box := image.Rect(int(math.Floor(minX-pad)), int(math.Floor(minY-pad)),
+		int(math.Ceil(maxX+pad)), int(math.Ceil(maxY+pad))).Intersect(dst.Bounds())
+	if box.Empty() {
+		return
+	}
+
+	s := newStrokeMask(box, width)
+	s.polyline(lines, closed)
+	tip := lines[len(lines)-1]
+	for _, end := range heads {
+		s.segment(tip[0], tip[1], end[0], end[1])
+	}
+
+	draw.DrawMask(dst, s.dirty, image.NewUniform(c), image.Point{}, s.mask, s.dirty.Min, draw.Over)
+}
+
+// annotateFont is the text face source, parsed on first use and shared by
+// every text annotation
+var annotateFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// drawText renders text with its top-left corner at (x, y)
+func drawText(dst *image.RGBA, a Annotation, c color.Color, size float64) error {
+	f, err := annotateFont()
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	ascent := face.Metrics().Ascent
+	lineHeight := face.Metrics().Height
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	for i, line := range strings.Split(a.Text, "\n") {
+		d.Dot = fixed.Point26_6{
+			X: fixed.Int26_6(a.X * 64),
+			Y: fixed.Int26_6(a.Y*64) + ascent + lineHeight*fixed.Int26_6(i),
+		}
+		d.DrawString(line)
+	}
+	return nil
+}
+
+// blurRegion applies a gaussian blur of standard deviation sigma inside r,
+// sampling pixels around r like the editor's clipped canvas blur
+func blurRegion(dst *image.RGBA, r image.Rectangle, sigma float64) {
+	if r.Empty() || sigma <= 0 {
+		return
+	}
+	pad := int(math.Ceil(sigma * 3))
+	src := r.Inset(-pad).Intersect(dst.Bounds())
+
+	// Work on a copy of the padded region, one channel plane at a time
+	w, h := src.Dx(), src.Dy()
+	plane := make([]float32, w*h)
+	for ch := 0; ch < 4; ch++ {
+		for y := 0; y < h; y++ {
+			row := dst.Pix[dst.PixOffset(src.Min.X, src.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				plane[y*w+x] = float32(row[x*4+ch])
+			}
+		}
+		// Three box passes approximate a gaussian
+		for _, radius := range gaussianBoxes(sigma, 3) {
+			boxBlurPass(plane, w, h, radius)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			row := dst.Pix[dst.PixOffset(r.Min.X, y):]
+			for x := 0; x < r.Dx(); x++ {
+				v := plane[(y-src.Min.Y)*w+x+r.Min.X-src.Min.X]
+				row[x*4+ch] = uint8(math.Max(0, math.Min(255, float64(v)+0.5)))
+			}
+		}
+	}
+}
+
+// gaussianBoxes returns n box radii whose repeated application approximates
+// a gaussian of standard deviation sigma
+func gaussianBoxes(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+	m := math.Round((12*sigma*sigma - float64(n*lower*lower) - float64(4*n*lower) - float64(3*n)) / float64(-4*lower-4))
+
+	radii := make([]int, n)
+	for i := range radii {
+		size := upper
+		if float64(i) < m {
+			size = lower
+		}
+		radii[i] = max(0, (size-1)/2)
+	}
+	return radii
+}
+
+// boxBlurPass runs one horizontal and one vertical box blur of the given
+// radius. Both passes walk rows in memory order, spread across CPUs.
+func boxBlurPass(plane []float32, w, h, radius int) {
+	if radius <= 0 {
+		return
+	}
+	tmp := make([]float32, len(plane))
+	scale := 1 / float32(2*radius+1)
+
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			src, dst := plane[y*w:(y+1)*w], tmp[y*w:(y+1)*w]
+			var sum float32
+			for i := -radius; i <= radius; i++ {
+				sum += src[max(0, min(w-1, i))]
+			}
+			for x := 0; x < w; x++ {
+				dst[x] = sum * scale
+				sum += src[max(0, min(w-1, x+radius+1))] - src[max(0, min(w-1, x-radius))]
+			}
+		}
+	})
+
+	// Vertical: running sums for a band of columns, advanced row by row
+	parallelRows(w, func(x0, x1 int) {
+		sums := make([]float32, x1-x0)
+		for i := -radius; i <= radius; i++ {
+			row := tmp[max(0, min(h-1, i))*w:]
+			for x := x0; x < x1; x++ {
+				sums[x-x0] += row[x]
+			}
+		}
+		for y := 0; y < h; y++ {
+			dst := plane[y*w:]
+			in := tmp[max(0, min(h-1, y+radius+1))*w:]
+			out := tmp[max(0, min(h-1, y-radius))*w:]
+			for x := x0; x < x1; x++ {
+				dst[x] = sums[x-x0] * scale
+				sums[x-x0] += in[x] - out[x]
+			}
+		}
+	})
+}
+
+// pixelateRegion replaces r with blocks of the given size, each filled with
+// its average colour
+func pixelateRegion(dst *image.RGBA, r image.Rectangle, block int) {
+	if r.Empty() || block <= 1 {
+		return
+	}
+	for by := r.Min.Y; by < r.Max.Y; by += block {
+		for bx := r.Min.X; bx < r.Max.X; bx += block {
+			cell := image.Rect(bx, by, bx+block, by+block).Intersect(r)
+			var sum [4]int
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				row := dst.Pix[dst.PixOffset(cell.Min.X, y):]
+				for x := 0; x < cell.Dx()*4; x++ {
+					sum[x%4] += int(row[x])
+				}
+			}
+			n := cell.Dx() * cell.Dy()
+			avg := [4]uint8{}
+			for ch := range avg {
+				avg[ch] = uint8((sum[ch] + n/2) / n)
+			}
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				row := dst.Pix[dst.PixOffset(cell.Min.X, y):]
+				for x := 0; x < cell.Dx(); x++ {
+					copy(row[x*4:x*4+4], avg[:])
+				}
+			}
+		}
+	}
+}
+
+// annotateImage renders annotations onto a copy of img in list order
+func annotateImage(img image.Image, anns []Annotation, scale float64) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	if scale <= 0 {
+		scale = 1
+	}
+
+	for i, a := range anns {
+		a = a.toPixels(dst.Bounds().Dx(), dst.Bounds().Dy())
+
+		hex := a.Color
+		if hex == "" {
+			hex = annotateStrokeColor
+		}
+		c, _ := parseHexColor(hex)
+
+		switch a.Type {
+		case "blur":
+			amount := a.Amount
+			if amount <= 0 {
+				amount = annotateBlurAmount
+			}
+			blurRegion(dst, a.normalizedRect(dst.Bounds()), amount)
+		case "mosaic", "pixelate":
+			block := int(math.Round(a.Amount))
+			if block <= 0 {
+				block = annotateMosaicSize
+			}
+			pixelateRegion(dst, a.normalizedRect(dst.Bounds()), block)
+		case "text":
+			size := a.FontSize
+			if size <= 0 {
+				size = annotateFontSize
+			}
+			if err := drawText(dst, a, c, size*scale); err != nil {
+				return nil, fmt.Errorf("annotation %d: %v", i, err)
+			}
+		default:
+			width := a.StrokeWidth
+			if width <= 0 {
+				width = annotateStrokeWidth
+			}
+			drawStroke(dst, a, c, width*scale, scale)
+		}
+	}
+	return dst, nil
+}
+
+// annotateImageFile renders annotations onto a local file, URL or .repic
+// input at full resolution. JPEGs are auto-oriented first so coordinates
+// match what the editor displays.
+func annotateImageFile(inputPath, outputPath string, opts AnnotateOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+
+	out, err := annotateImage(img, opts.Annotations, opts.Scale)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	if !opts.KeepMetadata {
+		data = nil
+	}
+	if err := writeImageFileMeta(outputPath, out, data, 1); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["format"] = format
+	result["annotations"] = len(opts.Annotations)
+	return result
+}
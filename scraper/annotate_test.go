@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDrawStroke(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	tests := []struct {
+		name  string
+		a     Annotation
+		drawn []image.Point // pixels on the stroke
+		clear []image.Point // pixels away from it
+	}{
+		{
+			name:  "rect",
+			a:     Annotation{Type: "rect", X: 10, Y: 10, Width: 20, Height: 10},
+			drawn: []image.Point{{20, 10}, {10, 15}, {30, 15}, {20, 20}},
+			clear: []image.Point{{20, 15}, {5, 5}, {35, 25}},
+		},
+		{
+			name:  "arrow head",
+			a:     Annotation{Type: "arrow", X: 5, Y: 30, Width: 40, Height: 0},
+			drawn: []image.Point{{25, 30}, {45, 30}, {35, 24}, {35, 35}},
+			clear: []image.Point{{25, 24}, {48, 30}},
+		},
+		{
+			name:  "partly outside the image",
+			a:     Annotation{Type: "path", Points: [][2]float64{{-30, 20}, {20, 20}, {20, 90}}},
+			drawn: []image.Point{{0, 20}, {20, 20}, {20, 49}},
+			clear: []image.Point{{0, 25}, {25, 40}},
+		},
+		{
+			name:  "entirely outside the image",
+			a:     Annotation{Type: "ellipse", X: 100, Y: 100, Width: 20, Height: 20},
+			clear: []image.Point{{49, 49}, {0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		dst := solidImage(50, 50, color.RGBA{255, 255, 255, 255})
+		drawStroke(dst, tt.a, red, 3, 1)
+		for _, p := range tt.drawn {
+			if c := dst.RGBAAt(p.X, p.Y); c != red {
+				t.Errorf("%s: (%d,%d) = %v, want stroke", tt.name, p.X, p.Y, c)
+			}
+		}
+		for _, p := range tt.clear {
+			if c := dst.RGBAAt(p.X, p.Y); c != (color.RGBA{255, 255, 255, 255}) {
+				t.Errorf("%s: (%d,%d) = %v, want untouched", tt.name, p.X, p.Y, c)
+			}
+		}
+	}
+}
+
+func TestDrawTextReusesFont(t *testing.T) {
+	dst := solidImage(120, 60, color.RGBA{255, 255, 255, 255})
+	for i, text := range []string{"Ab", "Cd\nEf"} {
+		a := Annotation{Type: "text", X: float64(10 + 50*i), Y: 5, Text: text}
+		if err := drawText(dst, a, color.Black, 20); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, _ := annotateFont()
+	second, _ := annotateFont()
+	if first == nil || first != second {
+		t.Error("font parsed more than once")
+	}
+
+	inked := func(r image.Rectangle) bool {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if dst.RGBAAt(x, y).R < 128 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !inked(image.Rect(10, 5, 40, 30)) || !inked(image.Rect(60, 30, 90, 55)) {
+		t.Error("text lines were not drawn")
+	}
+	if inked(image.Rect(10, 30, 40, 60)) {
+		t.Error("single-line text drew a second line")
+	}
+}
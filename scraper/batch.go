@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============ BATCH STREAMING ============
+
+// ProcessItem is one per-file result of a streamed batch operation
+type ProcessItem struct {
+	Source  string     `json:"source"`
+	Output  string     `json:"output,omitempty"`
+	Success bool       `json:"success"`
+	Error   string     `json:"error,omitempty"`
+	Width   int        `json:"width,omitempty"`
+	Height  int        `json:"height,omitempty"`
+	Index   int        `json:"index"`
+	Info    *ImageInfo `json:"info,omitempty"`
+
+	// Compress fields, mirroring compressImage's result
+	Size         int64  `json:"size,omitempty"`
+	Quality      int    `json:"quality,omitempty"`
+	Format       string `json:"format,omitempty"`
+	OutputFormat string `json:"output_format,omitempty"`
+	OriginalSize int64  `json:"original_size,omitempty"`
+}
+
+// processItemFromResult converts the result map of a single-file operation
+// into a batch item; keys the operation does not report stay empty
+func processItemFromResult(source, outputPath string, result map[string]interface{}) ProcessItem {
+	item := ProcessItem{Source: source}
+	if ok, _ := result["success"].(bool); !ok {
+		item.Error, _ = result["error"].(string)
+		return item
+	}
+	item.Output = outputPath
+	item.Width, _ = result["width"].(int)
+	item.Height, _ = result["height"].(int)
+	item.Size, _ = result["size"].(int64)
+	item.OriginalSize, _ = result["original_size"].(int64)
+	item.Quality, _ = result["quality"].(int)
+	item.Format, _ = result["format"].(string)
+	item.OutputFormat, _ = result["output_format"].(string)
+	item.Success = true
+	return item
+}
+
+// streamBatch runs fn over files with the semaphore worker pattern used by
+// batchThumbnails, streaming one NDJSON item per file and a summary line
+func streamBatch(files []string, concurrency int, fn func(source string) ProcessItem) {
+	startTime := time.Now()
+	encoder := json.NewEncoder(os.Stdout)
+
+	sources := cleanFileList(files)
+	sem := make(chan struct{}, concurrency)
+	results := make(chan ProcessItem, len(sources))
+	var wg sync.WaitGroup
+
+	for i, file := range sources {
+		wg.Add(1)
+		go func(idx int, filePath string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			item := fn(filePath)
+			item.Source = filePath
+			item.Index = idx
+			results <- item
+		}(i, file)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	completed := 0
+	failed := 0
+	var originalSize, size int64
+
+	// Stream each result immediately as it arrives
+	for item := range results {
+		encoder.Encode(item)
+		if item.Success {
+			completed++
+			originalSize += item.OriginalSize
+			size += item.Size
+		} else {
+			failed++
+		}
+	}
+
+	// Final summary line (type: "summary")
+	duration := time.Since(startTime).Milliseconds()
+	summary := map[string]interface{}{
+		"type":        "summary",
+		"total":       len(sources),
+		"completed":   completed,
+		"failed":      failed,
+		"duration_ms": duration,
+	}
+	// Before/after totals for operations that report both sizes
+	if originalSize > 0 {
+		summary["original_size"] = originalSize
+		summary["size"] = size
+	}
+	encoder.Encode(summary)
+}
+
+// streamBatchOutputs is streamBatch for operations that write one file per
+// source. Output paths are resolved up front; when two sources map to the same
+// path (a/x.jpg and b/x.jpg into one folder) the first in input order keeps it
+// and the others fail instead of overwriting it. Paths are compared without
+// case so case-insensitive filesystems are covered as well.
+func streamBatchOutputs(files []string, concurrency int, outputPath func(source string) string, fn func(source, outputPath string) ProcessItem) {
+	owners := make(map[string]string)
+	for _, source := range cleanFileList(files) {
+		key := strings.ToLower(filepath.Clean(outputPath(source)))
+		if _, taken := owners[key]; !taken {
+			owners[key] = source
+		}
+	}
+
+	streamBatch(files, concurrency, func(source string) ProcessItem {
+		out := outputPath(source)
+		if owner := owners[strings.ToLower(filepath.Clean(out))]; owner != source {
+			return ProcessItem{Error: fmt.Sprintf("output %s collides with %s", out, owner)}
+		}
+		return fn(source, out)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStreamBatchOutputs(t *testing.T) {
+	files := []string{"a/x.jpg", "b/x.jpg", "c/y.jpg", "d/X.JPG", "e/y.png"}
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join("out", strings.TrimSuffix(name, filepath.Ext(name))+".jpg")
+	}
+
+	var mu sync.Mutex
+	written := make(map[string]string)
+	lines := captureStdout(t, func() {
+		streamBatchOutputs(files, 3, outputPath, func(source, out string) ProcessItem {
+			mu.Lock()
+			written[source] = out
+			mu.Unlock()
+			return ProcessItem{Success: true, Output: out}
+		})
+	})
+
+	want := map[string]bool{"a/x.jpg": true, "b/x.jpg": false, "c/y.jpg": true, "d/X.JPG": false, "e/y.png": false}
+	for _, line := range lines {
+		var item ProcessItem
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.Source == "" {
+			continue // summary
+		}
+		if item.Success != want[item.Source] {
+			t.Errorf("%s: success %v, want %v (%s)", item.Source, item.Success, want[item.Source], item.Error)
+		}
+		if !item.Success && !strings.Contains(item.Error, "collides") {
+			t.Errorf("%s: error %q, want a collision", item.Source, item.Error)
+		}
+		if _, ran := written[item.Source]; ran != want[item.Source] {
+			t.Errorf("%s: fn ran %v, want %v", item.Source, ran, want[item.Source])
+		}
+	}
+	if len(lines) != len(files)+1 {
+		t.Errorf("got %d lines, want %d", len(lines), len(files)+1)
+	}
+}
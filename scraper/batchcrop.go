@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ============ BATCH CROP MODE ============
+
+// BatchCropOptions describes the shared crop applied to every file.
+// Exactly one of Box (pixels or percent) or Aspect (with Anchor) is used.
+type BatchCropOptions struct {
+	Box          cropBox
+	Aspect       float64    // width / height; 0 = use Box
+	Anchor       string     // center, top, bottom, left, right, smart
+	OutputMode   string     // suffix, replace, folder, custom
+	OutputDir    string     // custom mode target directory
+	Suffix       string     // suffix mode name suffix
+	KeepMetadata bool       // carry EXIF, ICC and XMP into the output
+	Watermark    *watermark // stamp applied after cropping
+}
+
+var validCropOutputModes = map[string]bool{"replace": true, "folder": true, "suffix": true, "custom": true}
+
+// parseAspect parses "16:9", "16/9" or "1.5" into a width/height ratio
+func parseAspect(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	for _, sep := range []string{":", "/"} {
+		if w, h, ok := strings.Cut(s, sep); ok {
+			fw, err1 := strconv.ParseFloat(strings.TrimSpace(w), 64)
+			fh, err2 := strconv.ParseFloat(strings.TrimSpace(h), 64)
+			if err1 != nil || err2 != nil || fw <= 0 || fh <= 0 {
+				return 0, fmt.Errorf("invalid aspect: %s", s)
+			}
+			return fw / fh, nil
+		}
+	}
+
+	ratio, err := strconv.ParseFloat(s, 64)
+	if err != nil || ratio <= 0 {
+		return 0, fmt.Errorf("invalid aspect: %s", s)
+	}
+	return ratio, nil
+}
+
+// batchCropOutputPath resolves where a cropped file is written, mirroring the
+// batch-crop-save IPC handler (replace, "cropped" subfolder, custom dir).
+// Sources in a format that cannot be encoded are written as PNG next to
+// the original rather than over it.
+func batchCropOutputPath(source string, opts BatchCropOptions) string {
+	ext := filepath.Ext(source)
+	base := strings.TrimSuffix(source, ext)
+	if out := encodableExt(source); !strings.EqualFold(out, ext) {
+		ext = out
+	}
+
+	switch opts.OutputMode {
+	case "folder":
+		return filepath.Join(filepath.Dir(source), "cropped", filepath.Base(base)+ext)
+	case "custom":
+		return filepath.Join(opts.OutputDir, filepath.Base(base)+ext)
+	case "suffix":
+		return base + opts.Suffix + ext
+	default: // replace
+		return base + ext
+	}
+}
+
+// batchCropFile crops one local file into outputPath according to the shared options
+func batchCropFile(source, outputPath string, opts BatchCropOptions) ProcessItem {
+	item := ProcessItem{Source: source}
+
+	if isRemoteSource(source) || isRepicSource(source) {
+		item.Error = "batch crop requires local image files"
+		return item
+	}
+
+	img, _, data, box, err := loadCropSource(source, opts.Box)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	var r image.Rectangle
+	if opts.Aspect > 0 {
+		r = aspectRect(img, opts.Aspect, opts.Anchor)
+	} else if r, err = box.rect(img.Bounds()); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	cropped, orientation := cropToRect(img, r), 0
+	if opts.Watermark != nil {
+		if cropped, orientation, err = opts.Watermark.applyOriented(cropped, data); err != nil {
+			item.Error = err.Error()
+			return item
+		}
+	}
+	if !opts.KeepMetadata {
+		data = nil
+	}
+	if err := writeImageFileMeta(outputPath, cropped, data, orientation); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+
+	item.Output = outputPath
+	item.Width = cropped.Bounds().Dx()
+	item.Height = cropped.Bounds().Dy()
+	item.Success = true
+	return item
+}
+
+// batchCropStreaming crops files concurrently, streaming one NDJSON item per file
+func batchCropStreaming(files []string, opts BatchCropOptions, concurrency int) {
+	outputPath := func(source string) string {
+		return batchCropOutputPath(source, opts)
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return batchCropFile(source, outputPath, opts)
+	})
+}
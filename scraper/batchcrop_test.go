@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchCropOutputPath(t *testing.T) {
+	dir := filepath.FromSlash("/photos")
+	out := filepath.FromSlash("/out")
+	tests := []struct {
+		source string
+		opts   BatchCropOptions
+		want   string
+	}{
+		{"a.jpg", BatchCropOptions{OutputMode: "suffix", Suffix: "_cropped"}, "a_cropped.jpg"},
+		{"a.JPG", BatchCropOptions{OutputMode: "suffix", Suffix: "_c"}, "a_c.JPG"},
+		{"a.webp", BatchCropOptions{OutputMode: "suffix", Suffix: "_c"}, "a_c.png"},
+		{"a.cur", BatchCropOptions{OutputMode: "suffix", Suffix: "_c"}, "a_c.png"},
+		{"a.jpg", BatchCropOptions{OutputMode: "replace"}, "a.jpg"},
+		{"a.webp", BatchCropOptions{OutputMode: "replace"}, "a.png"},
+		{"a.cur", BatchCropOptions{OutputMode: "replace"}, "a.png"},
+		{"a.png", BatchCropOptions{OutputMode: "folder"}, filepath.Join("cropped", "a.png")},
+		{"a.webp", BatchCropOptions{OutputMode: "custom", OutputDir: out}, filepath.Join(out, "a.png")},
+	}
+	for _, tt := range tests {
+		source := filepath.Join(dir, tt.source)
+		want := tt.want
+		if !filepath.IsAbs(want) {
+			want = filepath.Join(dir, want)
+		}
+		if got := batchCropOutputPath(source, tt.opts); got != want {
+			t.Errorf("batchCropOutputPath(%q, %s) = %q, want %q", source, tt.opts.OutputMode, got, want)
+		}
+	}
+}
+
+func TestParseAspect(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "16:9", want: 16.0 / 9},
+		{in: "4/3", want: 4.0 / 3},
+		{in: " 1.5 ", want: 1.5},
+		{in: "1:0", wantErr: true},
+		{in: "-2", wantErr: true},
+		{in: "wide", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAspect(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAspect(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ COMPRESS OPTIONS ============
+
+// CompressOptions controls compressImage
+type CompressOptions struct {
+	Quality    int         // JPEG quality; the upper bound when TargetSize is set
+	MinQuality int         // lowest quality tried before downscaling
+	TargetSize int64       // max output bytes, 0 = encode once at Quality
+	Format     string      // jpeg, png, gif, bmp, tiff, ico, webp; empty = from the output extension
+	Colors     int         // PNG palette size (2-256), 0 = lossless truecolour
+	Dither     bool        // Floyd-Steinberg dithering for palette output
+	Flatten    color.Color // background for alpha images written as JPEG; nil = refuse
+
+	Filters      []FilterStep // colour and tone adjustments applied first
+	KeepMetadata bool         // carry EXIF, ICC and XMP from the source
+	Watermark    *watermark   // stamp applied before encoding
+}
+
+// compressFormat resolves the output encoder from an explicit format or the
+// output extension. Unknown extensions keep the historical JPEG default.
+func compressFormat(outputPath, explicit string) (string, error) {
+	f := strings.ToLower(strings.TrimPrefix(explicit, "."))
+	if f == "" {
+		f = strings.ToLower(strings.TrimPrefix(filepath.Ext(outputPath), "."))
+	}
+
+	switch f {
+	case "png", "gif", "bmp", "ico", "webp":
+		return f, nil
+	case "tif", "tiff":
+		return "tiff", nil
+	case "jpg", "jpeg", "":
+		return "jpeg", nil
+	}
+	if explicit != "" {
+		return "", fmt.Errorf("unknown format: %s", explicit)
+	}
+	return "jpeg", nil
+}
+
+// formatExt is the extension a batch output gets for an explicit format, or
+// the source's own when format is empty
+func formatExt(source, format string) string {
+	switch f, _ := compressFormat("", format); {
+	case format == "":
+		return encodableExt(source)
+	case f == "jpeg":
+		return ".jpg"
+	default:
+		return "." + f
+	}
+}
+
+// flattenImage composites img over a solid background
+func flattenImage(img image.Image, bg color.Color) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// quantizeImage maps img onto a median-cut palette of up to colors entries
+func quantizeImage(img image.Image, colors int, dither bool) *image.Paletted {
+	palette := medianCut{}.Quantize(make(color.Palette, 0, colors), img)
+	return remapPaletted(img, palette, dither)
+}
+
+// encodeCompressed encodes img as png, gif, bmp, tiff, ico or lossless webp
+// according to opts
+func encodeCompressed(img image.Image, format string, opts CompressOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case "png":
+		if opts.Colors > 0 {
+			img = quantizeImage(img, opts.Colors, opts.Dither)
+		}
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, img)
+	case "gif":
+		colors := opts.Colors
+		if colors == 0 {
+			colors = 256
+		}
+		// GIF transparency is all or nothing
+		palette := medianCut{}.Quantize(make(color.Palette, 0, colors), img)
+		for i, c := range palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			if n.A < 128 {
+				palette[i] = color.NRGBA{}
+			} else {
+				n.A = 255
+				palette[i] = n
+			}
+		}
+		pm := remapPaletted(img, palette, opts.Dither)
+		err = gif.Encode(&buf, pm, &gif.Options{NumColors: len(pm.Palette)})
+	default:
+		if opts.Colors > 0 {
+			img = quantizeImage(img, opts.Colors, opts.Dither)
+		}
+		var ok bool
+		if ok, err = encodeExtraFormat(&buf, img, format); !ok {
+			return nil, fmt.Errorf("unsupported format: %s", format)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ============ TARGET SIZE COMPRESSION ============
+
+const (
+	targetMinSide   = 16 // give up rather than shrink below this
+	targetMaxRounds = 12 // downscale rounds before giving up
+)
+
+// targetEncoding is the result of a target-size search
+type targetEncoding struct {
+	Data     []byte
+	Quality  int
+	Width    int
+	Height   int
+	Attempts int
+}
+
+// parseByteSize parses sizes like "500000", "200KB", "1.5MB" (1 KB = 1024 bytes)
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := 1.0
+	for _, unit := range []struct {
+		suffix string
+		mult   float64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"K", 1 << 10}, {"M", 1 << 20}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			mult = unit.mult
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(v * mult), nil
+}
+
+func encodeJPEGBytes(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encode: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// compressToTarget finds the highest JPEG quality in [minQuality,
+// maxQuality] whose output fits in target bytes. When even the minimum
+// quality is too large the image is downscaled from the original and the
+// search repeats.
+func compressToTarget(img image.Image, minQuality, maxQuality int, target int64) (*targetEncoding, error) {
+	res := &targetEncoding{}
+	minQuality = max(1, min(minQuality, maxQuality))
+	bounds := img.Bounds()
+	cur := img
+
+	for round := 0; round < targetMaxRounds; round++ {
+		encode := func(q int) ([]byte, error) {
+			res.Attempts++
+			return encodeJPEGBytes(cur, q)
+		}
+
+		data, err := encode(maxQuality)
+		if err != nil {
+			return nil, err
+		}
+		best, bestQ := data, maxQuality
+
+		if int64(len(data)) > target {
+			data, err = encode(minQuality)
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(data)) <= target {
+				// lo fits, hi does not
+				best, bestQ = data, minQuality
+				lo, hi := minQuality, maxQuality
+				for hi-lo > 1 {
+					mid := (lo + hi) / 2
+					d, err := encode(mid)
+					if err != nil {
+						return nil, err
+					}
+					if int64(len(d)) <= target {
+						lo, best, bestQ = mid, d, mid
+					} else {
+						hi = mid
+					}
+				}
+			} else {
+				// Size scales roughly with pixel count; aim a little under
+				scale := math.Sqrt(float64(target)/float64(len(data))) * 0.95
+				scale = math.Max(0.5, math.Min(0.9, scale))
+				w := int(float64(cur.Bounds().Dx()) * scale)
+				h := int(float64(cur.Bounds().Dy()) * scale)
+				if w < targetMinSide || h < targetMinSide {
+					break
+				}
+				dst := image.NewRGBA(image.Rect(0, 0, w, h))
+				draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+				cur = dst
+				continue
+			}
+		}
+
+		res.Data = best
+		res.Quality = bestQ
+		res.Width = cur.Bounds().Dx()
+		res.Height = cur.Bounds().Dy()
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("cannot reach target size %d bytes after %d attempts", target, res.Attempts)
+}
+
+// batchCompressStreaming compresses files into outputDir, keeping their names
+// with the extension of the output format
+func batchCompressStreaming(files []string, outputDir string, opts CompressOptions, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+formatExt(source, opts.Format))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return processItemFromResult(source, outputPath, compressImage(source, outputPath, opts))
+	})
+}
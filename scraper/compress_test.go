@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "500000", want: 500000},
+		{in: "200KB", want: 200 << 10},
+		{in: "200kb", want: 200 << 10},
+		{in: "200K", want: 200 << 10},
+		{in: "1.5MB", want: 3 << 19},
+		{in: "2m", want: 2 << 20},
+		{in: " 64 KB ", want: 64 << 10},
+		{in: "300B", want: 300},
+		{in: "0", wantErr: true},
+		{in: "-5KB", wantErr: true},
+		{in: "KB", wantErr: true},
+		{in: "big", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCompressToTarget(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * y), uint8(x ^ y), uint8(x + y*3), 255})
+		}
+	}
+
+	tests := []struct {
+		target     int64
+		downscaled bool
+	}{
+		{target: 100 << 10},
+		{target: 4 << 10, downscaled: true},
+	}
+	for _, tt := range tests {
+		res, err := compressToTarget(img, 40, 90, tt.target)
+		if err != nil {
+			t.Fatalf("target %d: %v", tt.target, err)
+		}
+		if int64(len(res.Data)) > tt.target {
+			t.Errorf("target %d: got %d bytes", tt.target, len(res.Data))
+		}
+		if downscaled := res.Width < 640; downscaled != tt.downscaled {
+			t.Errorf("target %d: width %d, downscaled = %v, want %v", tt.target, res.Width, downscaled, tt.downscaled)
+		}
+	}
+}
+
+func TestCompressDecodedReportsEncoding(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		output       string
+		wantQuality  interface{} // reported for JPEG only
+		wantLossless bool
+	}{
+		{output: "a.jpg", wantQuality: 70},
+		{output: "a.webp", wantLossless: true},
+		{output: "a.png"},
+	}
+	for _, tt := range tests {
+		result := compressDecoded(testPattern(), "png", nil, 0, filepath.Join(dir, tt.output), CompressOptions{Quality: 70})
+		if result["success"] != true {
+			t.Fatalf("%s: %v", tt.output, result["error"])
+		}
+		if result["quality"] != tt.wantQuality {
+			t.Errorf("%s: quality %v, want %v", tt.output, result["quality"], tt.wantQuality)
+		}
+		if lossless := result["lossless"] == true; lossless != tt.wantLossless {
+			t.Errorf("%s: lossless = %v, want %v", tt.output, lossless, tt.wantLossless)
+		}
+	}
+}
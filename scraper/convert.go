@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============ CONVERT MODE ============
+
+// ConvertOptions controls format conversion
+type ConvertOptions struct {
+	Format       string      // target format; empty = from the output extension
+	Quality      int         // JPEG quality
+	Flatten      color.Color // composite transparency onto this colour for any target; nil = keep where possible
+	KeepMetadata bool        // carry EXIF, ICC and XMP from the source
+}
+
+// convertImageFile re-encodes a local file, URL or .repic input in another
+// format. Pixels are auto-oriented, so the output needs no orientation tag.
+// Transparency is kept when the target supports it; a JPEG target needs
+// Flatten, and GIF keeps only fully opaque or fully transparent pixels.
+// Animated GIFs stay animated as GIF unless flattened.
+func convertImageFile(inputPath, outputPath string, opts ConvertOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	outFormat, err := compressFormat(outputPath, opts.Format)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	// Animated GIFs stay animated only as GIF; other targets get the first frame
+	var frames int
+	if format == "gif" {
+		if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil {
+			frames = len(g.Image)
+			if frames > 1 && outFormat == "gif" && opts.Flatten == nil {
+				return convertAnimatedGIF(g, data, outputPath)
+			}
+		}
+	}
+
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+
+	alpha := ""
+	if hasAlpha(img) {
+		switch {
+		case opts.Flatten != nil:
+			img = flattenImage(img, opts.Flatten)
+			alpha = "flattened"
+		case outFormat == "jpeg":
+			result["success"] = false
+			result["error"] = "image has transparency; use a format with alpha or --flatten to write jpeg"
+			return result
+		case outFormat == "gif":
+			alpha = "binary"
+		default:
+			alpha = "kept"
+		}
+	}
+
+	source := data
+	if !opts.KeepMetadata {
+		source = nil
+	}
+	result = compressDecoded(img, format, source, 1, outputPath, CompressOptions{
+		Quality:      opts.Quality,
+		Format:       outFormat,
+		KeepMetadata: opts.KeepMetadata,
+	})
+	if ok, _ := result["success"].(bool); !ok {
+		return result
+	}
+
+	if alpha != "" {
+		result["alpha"] = alpha
+	}
+	if frames > 1 {
+		result["frames"] = 1
+		result["dropped_frames"] = frames - 1
+	}
+	result["original_size"] = int64(len(data))
+	return result
+}
+
+// convertAnimatedGIF rewrites every frame with its own palette, delay and
+// disposal, so a GIF to GIF conversion keeps the animation intact
+func convertAnimatedGIF(g *gif.GIF, data []byte, outputPath string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		result["success"] = false
+		result["error"] = fmt.Sprintf("encode: %v", err)
+		return result
+	}
+	if err := writeFileAtomic(outputPath, buf.Bytes()); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = g.Config.Width
+	result["height"] = g.Config.Height
+	result["format"] = "gif"
+	result["output_format"] = "gif"
+	result["frames"] = len(g.Image)
+	result["size"] = int64(buf.Len())
+	result["original_size"] = int64(len(data))
+	return result
+}
+
+// batchConvertStreaming converts files into outputDir, renaming each to the
+// target format's extension
+func batchConvertStreaming(files []string, outputDir string, opts ConvertOptions, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+formatExt(source, opts.Format))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return processItemFromResult(source, outputPath, convertImageFile(source, outputPath, opts))
+	})
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ PERCEPTUAL HASHING ============
+
+var validHashTypes = map[string]bool{"ahash": true, "dhash": true, "phash": true}
+
+// hashGray renders img as a w x h grayscale grid, composited over white so
+// transparent areas hash the same as they display. Large images are
+// box-shrunk first so scaling stays cheap.
+func hashGray(img image.Image, w, h int) []float64 {
+	bounds := img.Bounds()
+	if factor := min(bounds.Dx()/(w*4), bounds.Dy()/(h*4)); factor >= 2 {
+		img = boxShrink(img, factor)
+		bounds = img.Bounds()
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	gray := make([]float64, w*h)
+	for i := range gray {
+		p := dst.Pix[i*4:]
+		gray[i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	}
+	return gray
+}
+
+// averageHash sets a bit for each 8x8 cell brighter than the mean
+func averageHash(img image.Image) uint64 {
+	gray := hashGray(img, 8, 8)
+	mean := 0.0
+	for _, v := range gray {
+		mean += v
+	}
+	mean /= float64(len(gray))
+
+	var hash uint64
+	for _, v := range gray {
+		hash <<= 1
+		if v > mean {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// differenceHash sets a bit where a cell of a 9x8 grid is brighter than its
+// right neighbour
+func differenceHash(img image.Image) uint64 {
+	gray := hashGray(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y*9+x] > gray[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// phashSize and phashLow are the DCT input size and the low-frequency block kept
+const (
+	phashSize = 32
+	phashLow  = 8
+)
+
+// phashCos holds cos((2x+1)uπ/2N) for the low frequencies u
+var phashCos = func() [phashLow][phashSize]float64 {
+	var c [phashLow][phashSize]float64
+	for u := 0; u < phashLow; u++ {
+		for x := 0; x < phashSize; x++ {
+			c[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+		}
+	}
+	return c
+}()
+
+// perceptualHash takes the 8x8 lowest frequencies of a 32x32 DCT and sets a
+// bit for each coefficient above their median (the DC term is left out of
+// the median, as it only reflects overall brightness)
+func perceptualHash(img image.Image) uint64 {
+	gray := hashGray(img, phashSize, phashSize)
+
+	// Rows first, then columns, only for the frequencies kept
+	var rows [phashSize][phashLow]float64
+	for y := 0; y < phashSize; y++ {
+		for u := 0; u < phashLow; u++ {
+			sum := 0.0
+			for x := 0; x < phashSize; x++ {
+				sum += gray[y*phashSize+x] * phashCos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coeffs := make([]float64, 0, phashLow*phashLow)
+	for v := 0; v < phashLow; v++ {
+		for u := 0; u < phashLow; u++ {
+			sum := 0.0
+			for y := 0; y < phashSize; y++ {
+				sum += rows[y][u] * phashCos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, c := range coeffs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// imageHash computes the named perceptual hash
+func imageHash(img image.Image, hashType string) uint64 {
+	switch hashType {
+	case "ahash":
+		return averageHash(img)
+	case "dhash":
+		return differenceHash(img)
+	}
+	return perceptualHash(img)
+}
+
+// hammingDistance counts the differing bits of two hashes
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// ============ DEDUPE MODE ============
+
+// DedupeFile is the hashed view of one input
+type DedupeFile struct {
+	Source string `json:"source"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Hash   string `json:"hash,omitempty"` // 16 hex digits
+	Error  string `json:"error,omitempty"`
+
+	hash uint64
+}
+
+// DedupeMember is a duplicate of a group's keeper
+type DedupeMember struct {
+	Source   string `json:"source"`
+	Distance int    `json:"distance"` // Hamming distance to the keeper
+	Exact    bool   `json:"exact"`    // byte-identical to the keeper
+}
+
+// DedupeGroup is a cluster of similar images; Keeper is the one to retain
+type DedupeGroup struct {
+	Keeper     string         `json:"keeper"`
+	Duplicates []DedupeMember `json:"duplicates"`
+}
+
+// hashDedupeFile loads one local file or URL and fills its hashes. JPEGs are
+// auto-oriented so a rotated copy matches the original.
+func hashDedupeFile(source, hashType string) DedupeFile {
+	f := DedupeFile{Source: source}
+	img, _, data, err := loadImage(source)
+	if err != nil {
+		f.Error = err.Error()
+		return f
+	}
+
+	sum := sha256.Sum256(data)
+	f.SHA256 = hex.EncodeToString(sum[:])
+	f.Size = int64(len(data))
+	f.Width, f.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	if isJPEG(data) {
+		if o := jpegOrientation(data); o > 1 {
+			img, _ = transformImage(img, o, TransformOptions{Op: "auto-orient"})
+			f.Width, f.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+	}
+	f.hash = imageHash(img, hashType)
+	f.Hash = fmt.Sprintf("%016x", f.hash)
+	return f
+}
+
+// keeperLess orders files by preference: largest resolution, then largest file
+func keeperLess(a, b DedupeFile) bool {
+	if pa, pb := a.Width*a.Height, b.Width*b.Height; pa != pb {
+		return pa > pb
+	}
+	return a.Size > b.Size
+}
+
+// dedupeFiles hashes sources concurrently and groups the duplicates
+func dedupeFiles(sources []string, hashType string, distance, concurrency int) map[string]interface{} {
+	sources = cleanFileList(sources)
+	files := make([]DedupeFile, len(sources))
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(idx int, src string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			files[idx] = hashDedupeFile(src, hashType)
+		}(i, source)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, f := range files {
+		if f.Error != "" {
+			failed++
+		}
+	}
+	groups := groupDuplicates(files, distance)
+	duplicates := 0
+	for _, g := range groups {
+		duplicates += len(g.Duplicates)
+	}
+
+	return map[string]interface{}{
+		"success":    true,
+		"hash":       hashType,
+		"distance":   distance,
+		"files":      files,
+		"groups":     groups,
+		"duplicates": duplicates,
+		"failed":     failed,
+	}
+}
+
+// groupDuplicates clusters hashed files. Files are linked when byte-identical
+// or within distance bits, but linked files can chain well beyond distance end
+// to end, so each cluster is split around its best keeper: a group holds only
+// files within distance of (or identical to) that keeper, and the rest of the
+// cluster is grouped again around its own keeper.
+func groupDuplicates(files []DedupeFile, distance int) []DedupeGroup {
+	// Union-find over all hashed pairs
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	similar := func(a, b DedupeFile) bool {
+		return a.SHA256 == b.SHA256 || hammingDistance(a.hash, b.hash) <= distance
+	}
+	for i := range files {
+		if files[i].Error != "" {
+			continue
+		}
+		for j := i + 1; j < len(files); j++ {
+			if files[j].Error == "" && similar(files[i], files[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	// Clusters in order of their first file
+	members := make(map[int][]int)
+	var roots []int
+	for i := range files {
+		if files[i].Error != "" {
+			continue
+		}
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	groups := []DedupeGroup{}
+	for _, root := range roots {
+		for rest := members[root]; len(rest) > 1; {
+			keeper := rest[0]
+			for _, i := range rest[1:] {
+				if keeperLess(files[i], files[keeper]) {
+					keeper = i
+				}
+			}
+
+			group := DedupeGroup{Keeper: files[keeper].Source}
+			var left []int
+			for _, i := range rest {
+				switch {
+				case i == keeper:
+				case similar(files[i], files[keeper]):
+					group.Duplicates = append(group.Duplicates, DedupeMember{
+						Source:   files[i].Source,
+						Distance: hammingDistance(files[i].hash, files[keeper].hash),
+						Exact:    files[i].SHA256 == files[keeper].SHA256,
+					})
+				default:
+					left = append(left, i)
+				}
+			}
+			if len(group.Duplicates) > 0 {
+				groups = append(groups, group)
+			}
+			rest = left
+		}
+	}
+	return groups
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xffffffffffffffff, 0xffffffffffffffff, 0},
+		{0, 1, 1},
+		{0, 0xffffffffffffffff, 64},
+		{0xf0f0f0f0f0f0f0f0, 0x0f0f0f0f0f0f0f0f, 64},
+		{0b1011, 0b0110, 3},
+	}
+	for _, tt := range tests {
+		if got := hammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("hammingDistance(%#x, %#x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// sceneImage draws a few overlapping shapes, shifted in brightness by tint
+func sceneImage(w, h, tint int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := x * 255 / w
+			if (x-w/3)*(x-w/3)+(y-h/2)*(y-h/2) < h*h/9 {
+				v = 255 - y*255/h
+			}
+			if x > w*2/3 && y < h/3 {
+				v = 30
+			}
+			v = min(255, max(0, v+tint))
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v / 2), uint8(255 - v), 255})
+		}
+	}
+	return img
+}
+
+func TestImageHashDistances(t *testing.T) {
+	orig := sceneImage(320, 240, 0)
+	small := image.NewRGBA(image.Rect(0, 0, 100, 75))
+	draw.CatmullRom.Scale(small, small.Bounds(), orig, orig.Bounds(), draw.Src, nil)
+	brighter := sceneImage(320, 240, 12)
+	flipped := image.NewRGBA(orig.Bounds())
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			flipped.Set(319-x, y, orig.At(x, y))
+		}
+	}
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		maxDist int // inclusive; -1 = must be further than minDist
+		minDist int
+	}{
+		{name: "identical", img: sceneImage(320, 240, 0), maxDist: 0},
+		{name: "downscaled", img: small, maxDist: 6},
+		{name: "brighter", img: brighter, maxDist: 8},
+		{name: "mirrored", img: flipped, maxDist: -1, minDist: 12},
+	}
+	for _, hashType := range []string{"ahash", "dhash", "phash"} {
+		base := imageHash(orig, hashType)
+		if again := imageHash(orig, hashType); again != base {
+			t.Errorf("%s: hash not stable: %016x then %016x", hashType, base, again)
+		}
+		for _, tt := range tests {
+			d := hammingDistance(base, imageHash(tt.img, hashType))
+			if tt.maxDist >= 0 && d > tt.maxDist {
+				t.Errorf("%s %s: distance %d, want <= %d", hashType, tt.name, d, tt.maxDist)
+			}
+			if tt.maxDist < 0 && d <= tt.minDist {
+				t.Errorf("%s %s: distance %d, want > %d", hashType, tt.name, d, tt.minDist)
+			}
+		}
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	file := func(source string, hash uint64, pixels int, sha string) DedupeFile {
+		return DedupeFile{Source: source, hash: hash, Width: pixels, Height: 1, SHA256: sha}
+	}
+
+	tests := []struct {
+		name     string
+		files    []DedupeFile
+		distance int
+		want     []DedupeGroup
+	}{
+		{
+			name:     "keeper is the largest",
+			files:    []DedupeFile{file("a", 0b0, 10, "1"), file("b", 0b1, 20, "2")},
+			distance: 2,
+			want:     []DedupeGroup{{Keeper: "b", Duplicates: []DedupeMember{{Source: "a", Distance: 1}}}},
+		},
+		{
+			// b-a, a-c and c-d are close, b-c is not: the chain ends in two groups
+			name:     "chain split around the keeper",
+			files:    []DedupeFile{file("a", 0b00011, 10, "1"), file("b", 0b00000, 30, "2"), file("c", 0b01111, 5, "3"), file("d", 0b11111, 1, "4")},
+			distance: 2,
+			want: []DedupeGroup{
+				{Keeper: "b", Duplicates: []DedupeMember{{Source: "a", Distance: 2}}},
+				{Keeper: "c", Duplicates: []DedupeMember{{Source: "d", Distance: 1}}},
+			},
+		},
+		{
+			name:     "leftover without a partner is dropped",
+			files:    []DedupeFile{file("a", 0b0011, 10, "1"), file("b", 0b0000, 30, "2"), file("c", 0b1111, 5, "3")},
+			distance: 2,
+			want:     []DedupeGroup{{Keeper: "b", Duplicates: []DedupeMember{{Source: "a", Distance: 2}}}},
+		},
+		{
+			name:     "identical bytes group at any distance",
+			files:    []DedupeFile{file("a", 0, 10, "same"), file("b", 0xffff, 10, "same")},
+			distance: 0,
+			want:     []DedupeGroup{{Keeper: "a", Duplicates: []DedupeMember{{Source: "b", Distance: 16, Exact: true}}}},
+		},
+		{
+			name:     "unreadable files are skipped",
+			files:    []DedupeFile{file("a", 0, 10, "1"), {Source: "bad", Error: "boom"}, file("c", 0xff, 10, "3")},
+			distance: 4,
+			want:     []DedupeGroup{},
+		},
+	}
+	for _, tt := range tests {
+		got := groupDuplicates(tt.files, tt.distance)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d groups %+v, want %+v", tt.name, len(got), got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Keeper != tt.want[i].Keeper || len(got[i].Duplicates) != len(tt.want[i].Duplicates) {
+				t.Errorf("%s: group %d = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+				continue
+			}
+			for j := range got[i].Duplicates {
+				if got[i].Duplicates[j] != tt.want[i].Duplicates[j] {
+					t.Errorf("%s: group %d member %d = %+v, want %+v", tt.name, i, j, got[i].Duplicates[j], tt.want[i].Duplicates[j])
+				}
+			}
+		}
+	}
+}
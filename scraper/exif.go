@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ============ JPEG SEGMENTS ============
+
+// JPEG marker bytes used by the metadata helpers
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+)
+
+// jpegSegment is one marker segment before the scan data
+type jpegSegment struct {
+	Marker byte
+	Data   []byte // payload without the 2-byte length
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0xFF && data[1] == markerSOI && data[2] == 0xFF
+}
+
+// readJPEGSegments splits a JPEG into its header segments and the remainder
+// starting at the SOS marker (scan data through EOI)
+func readJPEGSegments(data []byte) ([]jpegSegment, []byte, error) {
+	if !isJPEG(data) {
+		return nil, nil, fmt.Errorf("not a JPEG")
+	}
+
+	var segments []jpegSegment
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, nil, fmt.Errorf("invalid marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+
+		// Fill bytes
+		if marker == 0xFF {
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[pos:], nil
+		}
+		if marker == markerEOI {
+			return segments, data[pos:], nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, fmt.Errorf("truncated segment at offset %d", pos)
+		}
+		segments = append(segments, jpegSegment{Marker: marker, Data: data[pos+4 : pos+2+length]})
+		pos += 2 + length
+	}
+
+	return nil, nil, fmt.Errorf("no scan data")
+}
+
+// writeJPEGSegments reassembles a JPEG from header segments and scan data
+func writeJPEGSegments(segments []jpegSegment, scan []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, markerSOI})
+	for _, seg := range segments {
+		buf.Write([]byte{0xFF, seg.Marker})
+		binary.Write(&buf, binary.BigEndian, uint16(len(seg.Data)+2))
+		buf.Write(seg.Data)
+	}
+	buf.Write(scan)
+	return buf.Bytes()
+}
+
+// findExifSegment returns the TIFF payload of the first APP1 Exif segment
+func findExifSegment(segments []jpegSegment) []byte {
+	for _, seg := range segments {
+		if seg.Marker == markerAPP1 && bytes.HasPrefix(seg.Data, exifHeader) {
+			return seg.Data[len(exifHeader):]
+		}
+	}
+	return nil
+}
+
+// ============ EXIF / TIFF ============
+
+// EXIF tags used across modes
+const (
+	tagOrientation = 0x0112
+	tagExifIFD     = 0x8769
+	tagGPSIFD      = 0x8825
+	tagThumbOffset = 0x0201
+	tagThumbLength = 0x0202
+
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagDateTime         = 0x0132
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensMake         = 0xA433
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// TIFF field types
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+	tiffSLong     = 9
+	tiffSRational = 10
+)
+
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffEntry is one IFD field. ValueOffset is the position of the value
+// bytes within the TIFF payload (inline values point into the entry itself).
+type tiffEntry struct {
+	Tag         uint16
+	Type        uint16
+	Count       uint32
+	EntryOffset int // offset of the 12-byte entry
+	ValueOffset int
+}
+
+// exifData is a parsed TIFF structure from an APP1 Exif segment
+type exifData struct {
+	order binary.ByteOrder
+	tiff  []byte
+	IFD0  map[uint16]tiffEntry
+	Exif  map[uint16]tiffEntry
+	GPS   map[uint16]tiffEntry
+	IFD1  map[uint16]tiffEntry
+}
+
+// parseExif parses the TIFF payload of an Exif segment (without the Exif\0\0 header)
+func parseExif(tiff []byte) (*exifData, error) {
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("exif: too short")
+	}
+
+	e := &exifData{tiff: tiff}
+	switch string(tiff[:2]) {
+	case "II":
+		e.order = binary.LittleEndian
+	case "MM":
+		e.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("exif: bad byte order")
+	}
+
+	ifd0Offset := int(e.order.Uint32(tiff[4:]))
+	var next int
+	var err error
+	e.IFD0, next, err = e.readIFD(ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sub-IFDs and the thumbnail IFD are optional; ignore broken ones
+	if entry, ok := e.IFD0[tagExifIFD]; ok {
+		e.Exif, _, _ = e.readIFD(int(e.uint(entry, 0)))
+	}
+	if entry, ok := e.IFD0[tagGPSIFD]; ok {
+		e.GPS, _, _ = e.readIFD(int(e.uint(entry, 0)))
+	}
+	if next > 0 {
+		e.IFD1, _, _ = e.readIFD(next)
+	}
+
+	return e, nil
+}
+
+// readIFD parses the IFD at offset and returns its entries and the next IFD offset
+func (e *exifData) readIFD(offset int) (map[uint16]tiffEntry, int, error) {
+	if offset < 8 || offset+2 > len(e.tiff) {
+		return nil, 0, fmt.Errorf("exif: IFD offset out of range")
+	}
+
+	count := int(e.order.Uint16(e.tiff[offset:]))
+	end := offset + 2 + count*12
+	if end+4 > len(e.tiff) {
+		return nil, 0, fmt.Errorf("exif: IFD truncated")
+	}
+
+	entries := make(map[uint16]tiffEntry, count)
+	for i := 0; i < count; i++ {
+		p := offset + 2 + i*12
+		entry := tiffEntry{
+			Tag:         e.order.Uint16(e.tiff[p:]),
+			Type:        e.order.Uint16(e.tiff[p+2:]),
+			Count:       e.order.Uint32(e.tiff[p+4:]),
+			EntryOffset: p,
+		}
+
+		size := tiffTypeSizes[entry.Type] * int(entry.Count)
+		if size <= 4 {
+			entry.ValueOffset = p + 8
+		} else {
+			entry.ValueOffset = int(e.order.Uint32(e.tiff[p+8:]))
+			if entry.ValueOffset < 0 || entry.ValueOffset+size > len(e.tiff) {
+				continue
+			}
+		}
+		entries[entry.Tag] = entry
+	}
+
+	return entries, int(e.order.Uint32(e.tiff[end:])), nil
+}
+
+// uint reads the i-th integer value of a BYTE, SHORT or LONG field
+func (e *exifData) uint(entry tiffEntry, i int) uint32 {
+	if uint32(i) >= entry.Count {
+		return 0
+	}
+	switch entry.Type {
+	case tiffByte, tiffUndefined:
+		return uint32(e.tiff[entry.ValueOffset+i])
+	case tiffShort:
+		return uint32(e.order.Uint16(e.tiff[entry.ValueOffset+i*2:]))
+	case tiffLong, tiffSLong:
+		return e.order.Uint32(e.tiff[entry.ValueOffset+i*4:])
+	}
+	return 0
+}
+
+// string reads an ASCII field, trimming NUL padding and spaces
+func (e *exifData) string(entry tiffEntry) string {
+	if entry.Type != tiffASCII && entry.Type != tiffUndefined {
+		return ""
+	}
+	raw := e.tiff[entry.ValueOffset : entry.ValueOffset+int(entry.Count)]
+	if i := bytes.IndexByte(raw, 0); i >= 0 {
+		raw = raw[:i]
+	}
+	return string(bytes.TrimSpace(raw))
+}
+
+// rational reads the i-th value of a RATIONAL or SRATIONAL field as a
+// numerator/denominator pair
+func (e *exifData) rational(entry tiffEntry, i int) (int64, int64) {
+	if uint32(i) >= entry.Count {
+		return 0, 0
+	}
+	p := entry.ValueOffset + i*8
+	switch entry.Type {
+	case tiffRational:
+		return int64(e.order.Uint32(e.tiff[p:])), int64(e.order.Uint32(e.tiff[p+4:]))
+	case tiffSRational:
+		return int64(int32(e.order.Uint32(e.tiff[p:]))), int64(int32(e.order.Uint32(e.tiff[p+4:])))
+	}
+	return 0, 0
+}
+
+// float reads the i-th value of a rational field, or 0 for a zero denominator
+func (e *exifData) float(entry tiffEntry, i int) float64 {
+	num, den := e.rational(entry, i)
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// thumbnail returns the embedded JPEG thumbnail from IFD1, if any
+func (e *exifData) thumbnail() []byte {
+	offEntry, ok1 := e.IFD1[tagThumbOffset]
+	lenEntry, ok2 := e.IFD1[tagThumbLength]
+	if !ok1 || !ok2 {
+		return nil
+	}
+	off := int(e.uint(offEntry, 0))
+	n := int(e.uint(lenEntry, 0))
+	if off <= 0 || n <= 0 || off+n > len(e.tiff) {
+		return nil
+	}
+	return e.tiff[off : off+n]
+}
+
+// orientation returns the EXIF orientation (1-8), or 1 when absent
+func (e *exifData) orientation() int {
+	if entry, ok := e.IFD0[tagOrientation]; ok {
+		if v := int(e.uint(entry, 0)); v >= 1 && v <= 8 {
+			return v
+		}
+	}
+	return 1
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, or 1
+func jpegOrientation(data []byte) int {
+	segments, _, err := readJPEGSegments(data)
+	if err != nil {
+		return 1
+	}
+	tiff := findExifSegment(segments)
+	if tiff == nil {
+		return 1
+	}
+	exif, err := parseExif(tiff)
+	if err != nil {
+		return 1
+	}
+	return exif.orientation()
+}
+
+// setJPEGOrientation rewrites the EXIF orientation tag without touching the
+// scan data. An existing tag is patched in place; otherwise IFD0 is copied to
+// the end of the TIFF payload with the tag added, or a minimal Exif segment
+// is inserted when the file has none.
+func setJPEGOrientation(data []byte, orientation int) ([]byte, error) {
+	segments, scan, err := readJPEGSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, seg := range segments {
+		if seg.Marker != markerAPP1 || !bytes.HasPrefix(seg.Data, exifHeader) {
+			continue
+		}
+
+		tiff := append([]byte(nil), seg.Data[len(exifHeader):]...)
+		exif, err := parseExif(tiff)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry, ok := exif.IFD0[tagOrientation]; ok && entry.Type == tiffShort {
+			exif.order.PutUint16(tiff[entry.ValueOffset:], uint16(orientation))
+		} else {
+			tiff = exif.appendIFD0Entry(tagOrientation, tiffShort, uint16(orientation))
+		}
+
+		if len(exifHeader)+len(tiff)+2 > 0xFFFF {
+			return nil, fmt.Errorf("exif segment too large")
+		}
+		segments[i] = jpegSegment{Marker: markerAPP1, Data: append(append([]byte(nil), exifHeader...), tiff...)}
+		return writeJPEGSegments(segments, scan), nil
+	}
+
+	// No Exif yet: insert one after JFIF/APP0 so JFIF stays first
+	seg := jpegSegment{Marker: markerAPP1, Data: append(append([]byte(nil), exifHeader...), minimalOrientationTIFF(orientation)...)}
+	pos := 0
+	for pos < len(segments) && segments[pos].Marker == markerAPP0 {
+		pos++
+	}
+	segments = append(segments[:pos], append([]jpegSegment{seg}, segments[pos:]...)...)
+	return writeJPEGSegments(segments, scan), nil
+}
+
+// appendIFD0Entry returns a TIFF payload whose IFD0 is a copy of the original
+// plus one inline SHORT entry. Existing value offsets stay valid because the
+// copy is appended after all current data.
+func (e *exifData) appendIFD0Entry(tag, typ uint16, value uint16) []byte {
+	tiff := append([]byte(nil), e.tiff...)
+	oldOffset := int(e.order.Uint32(tiff[4:]))
+	count := int(e.order.Uint16(tiff[oldOffset:]))
+	next := e.order.Uint32(tiff[oldOffset+2+count*12:])
+
+	// IFDs start on a word boundary
+	if len(tiff)%2 == 1 {
+		tiff = append(tiff, 0)
+	}
+	newOffset := len(tiff)
+
+	entries := make([][]byte, 0, count+1)
+	for i := 0; i < count; i++ {
+		p := oldOffset + 2 + i*12
+		entries = append(entries, tiff[p:p+12])
+	}
+	added := make([]byte, 12)
+	e.order.PutUint16(added, tag)
+	e.order.PutUint16(added[2:], typ)
+	e.order.PutUint32(added[4:], 1)
+	e.order.PutUint16(added[8:], value)
+	entries = append(entries, added)
+
+	// Entries must be sorted by tag
+	for i := len(entries) - 1; i > 0 && e.order.Uint16(entries[i]) < e.order.Uint16(entries[i-1]); i-- {
+		entries[i], entries[i-1] = entries[i-1], entries[i]
+	}
+
+	ifd := make([]byte, 2, 2+len(entries)*12+4)
+	e.order.PutUint16(ifd, uint16(len(entries)))
+	for _, entry := range entries {
+		ifd = append(ifd, entry...)
+	}
+	ifd = ifd[:len(ifd)+4]
+	e.order.PutUint32(ifd[len(ifd)-4:], next)
+
+	tiff = append(tiff, ifd...)
+	e.order.PutUint32(tiff[4:], uint32(newOffset))
+	return tiff
+}
+
+// minimalOrientationTIFF builds a big-endian TIFF payload holding only IFD0 orientation
+func minimalOrientationTIFF(orientation int) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, tagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, tiffShort)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)                     // pad value to 4 bytes
+	tiff = binary.BigEndian.AppendUint32(tiff, 0) // no next IFD
+	return tiff
+}
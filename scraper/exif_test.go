@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"sort"
+	"testing"
+)
+
+// tiffOrder is a byte order that can both put and append values
+type tiffOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffField is one IFD entry for buildTIFF; value is already in the TIFF byte order
+type tiffField struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func shortField(order tiffOrder, tag uint16, v uint16) tiffField {
+	return tiffField{tag, tiffShort, 1, order.AppendUint16(nil, v)}
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag, tiffASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rationalField(order tiffOrder, tag uint16, pairs ...uint32) tiffField {
+	var b []byte
+	for _, v := range pairs {
+		b = order.AppendUint32(b, v)
+	}
+	return tiffField{tag, tiffRational, uint32(len(pairs) / 2), b}
+}
+
+// buildTIFF lays out IFD0 (with pointers to the Exif and GPS IFDs when given),
+// the sub-IFDs and IFD1 after the header, followed by out-of-line values
+func buildTIFF(order tiffOrder, ifd0, exif, gps, ifd1 []tiffField) []byte {
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+
+	pointers := 0
+	if exif != nil {
+		pointers++
+	}
+	if gps != nil {
+		pointers++
+	}
+	ifd0Off := 8
+	exifOff := ifd0Off + ifdSize(len(ifd0)+pointers)
+	gpsOff := exifOff
+	if exif != nil {
+		gpsOff += ifdSize(len(exif))
+	}
+	ifd1Off := gpsOff
+	if gps != nil {
+		ifd1Off += ifdSize(len(gps))
+	}
+	dataOff := ifd1Off
+	if ifd1 != nil {
+		dataOff += ifdSize(len(ifd1))
+	}
+
+	ifd0 = append([]tiffField(nil), ifd0...)
+	if exif != nil {
+		ifd0 = append(ifd0, tiffField{tagExifIFD, tiffLong, 1, order.AppendUint32(nil, uint32(exifOff))})
+	}
+	if gps != nil {
+		ifd0 = append(ifd0, tiffField{tagGPSIFD, tiffLong, 1, order.AppendUint32(nil, uint32(gpsOff))})
+	}
+
+	var head, data []byte
+	writeIFD := func(fields []tiffField, next int) {
+		sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+		head = order.AppendUint16(head, uint16(len(fields)))
+		for _, f := range fields {
+			head = order.AppendUint16(head, f.tag)
+			head = order.AppendUint16(head, f.typ)
+			head = order.AppendUint32(head, f.count)
+			if len(f.value) <= 4 {
+				head = append(head, f.value...)
+				head = append(head, make([]byte, 4-len(f.value))...)
+				continue
+			}
+			head = order.AppendUint32(head, uint32(dataOff+len(data)))
+			data = append(data, f.value...)
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+		head = order.AppendUint32(head, uint32(next))
+	}
+
+	next0 := 0
+	if ifd1 != nil {
+		next0 = ifd1Off
+	}
+	writeIFD(ifd0, next0)
+	if exif != nil {
+		writeIFD(exif, 0)
+	}
+	if gps != nil {
+		writeIFD(gps, 0)
+	}
+	if ifd1 != nil {
+		writeIFD(ifd1, 0)
+	}
+
+	tiff := []byte("II\x2a\x00")
+	if order == binary.BigEndian {
+		tiff = []byte("MM\x00\x2a")
+	}
+	tiff = order.AppendUint32(tiff, uint32(ifd0Off))
+	return append(append(tiff, head...), data...)
+}
+
+func TestParseExif(t *testing.T) {
+	for _, order := range []tiffOrder{binary.LittleEndian, binary.BigEndian} {
+		thumb := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+		ifd0 := []tiffField{
+			asciiField(tagMake, "Acme"),
+			shortField(order, tagOrientation, 8),
+		}
+		exif := []tiffField{shortField(order, tagISO, 400)}
+		gps := []tiffField{asciiField(tagGPSLatitudeRef, "N")}
+		tiff := buildTIFF(order, ifd0, exif, gps, []tiffField{
+			{tagThumbOffset, tiffLong, 1, nil},
+			{tagThumbLength, tiffLong, 1, order.AppendUint32(nil, uint32(len(thumb)))},
+		})
+		// Point the thumbnail at bytes appended to the payload
+		e, err := parseExif(tiff)
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		order.PutUint32(tiff[e.IFD1[tagThumbOffset].ValueOffset:], uint32(len(tiff)))
+		tiff = append(tiff, thumb...)
+
+		e, err = parseExif(tiff)
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if got := e.string(e.IFD0[tagMake]); got != "Acme" {
+			t.Errorf("%v: make %q", order, got)
+		}
+		if got := e.orientation(); got != 8 {
+			t.Errorf("%v: orientation %d, want 8", order, got)
+		}
+		if got := e.uint(e.Exif[tagISO], 0); got != 400 {
+			t.Errorf("%v: ISO %d, want 400", order, got)
+		}
+		if got := e.string(e.GPS[tagGPSLatitudeRef]); got != "N" {
+			t.Errorf("%v: GPS ref %q", order, got)
+		}
+		if got := e.thumbnail(); !bytes.Equal(got, thumb) {
+			t.Errorf("%v: thumbnail %x, want %x", order, got, thumb)
+		}
+	}
+}
+
+func TestParseExifErrors(t *testing.T) {
+	order := binary.LittleEndian
+	valid := buildTIFF(order, []tiffField{shortField(order, tagOrientation, 3)}, nil, nil, nil)
+
+	badOffset := append([]byte(nil), valid...)
+	order.PutUint32(badOffset[4:], 4000)
+	truncated := valid[:len(valid)-4]
+
+	tests := []struct {
+		name string
+		tiff []byte
+	}{
+		{"too short", []byte("II\x2a\x00")},
+		{"bad byte order", append([]byte("XX"), valid[2:]...)},
+		{"IFD offset out of range", badOffset},
+		{"IFD truncated", truncated},
+	}
+	for _, tt := range tests {
+		if _, err := parseExif(tt.tiff); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestParseExifSkipsBrokenEntries(t *testing.T) {
+	order := binary.BigEndian
+	tiff := buildTIFF(order, []tiffField{
+		asciiField(tagModel, "a model name that does not fit inline"),
+		shortField(order, tagOrientation, 0), // out of range, reads as 1
+	}, nil, nil, nil)
+
+	e, err := parseExif(tiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Point the model value past the end of the payload
+	order.PutUint32(tiff[e.IFD0[tagModel].EntryOffset+8:], uint32(len(tiff)))
+
+	e, err = parseExif(tiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.IFD0[tagModel]; ok {
+		t.Error("entry with an out-of-range value offset was kept")
+	}
+	if got := e.orientation(); got != 1 {
+		t.Errorf("orientation %d, want 1", got)
+	}
+	if e.thumbnail() != nil {
+		t.Error("thumbnail without IFD1")
+	}
+}
+
+func TestReadJPEGSegments(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPattern(), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	segments, scan, err := readJPEGSegments(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(writeJPEGSegments(segments, scan), plain) {
+		t.Error("segments do not reassemble into the original file")
+	}
+
+	// Fill bytes before a marker are skipped
+	filled := append([]byte{0xFF, markerSOI, 0xFF}, plain[2:]...)
+	if got, _, err := readJPEGSegments(filled); err != nil || len(got) != len(segments) {
+		t.Errorf("fill bytes: %d segments, err %v", len(got), err)
+	}
+
+	bad := []struct {
+		name string
+		data []byte
+	}{
+		{"not a JPEG", []byte("\x89PNG\r\n\x1a\n")},
+		{"truncated segment", plain[:8]},
+		{"no scan", []byte{0xFF, markerSOI, 0xFF, markerAPP0, 0, 4, 'x', 'y'}},
+		{"bad marker", []byte{0xFF, markerSOI, 0xFF, markerAPP0, 0, 2, 0x00, 0x00, 0, 0}},
+	}
+	for _, tt := range bad {
+		if _, _, err := readJPEGSegments(tt.data); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestSetJPEGOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPattern(), nil); err != nil {
+		t.Fatal(err)
+	}
+	segments, scan, err := readJPEGSegments(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSegments := func(extra ...jpegSegment) []byte {
+		return writeJPEGSegments(append(extra, segments...), scan)
+	}
+	exifSegment := func(tiff []byte) jpegSegment {
+		return jpegSegment{Marker: markerAPP1, Data: append(append([]byte(nil), exifHeader...), tiff...)}
+	}
+	jfif := jpegSegment{Marker: markerAPP0, Data: []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")}
+	le := binary.LittleEndian
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no exif", withSegments()},
+		{"no exif after JFIF", withSegments(jfif)},
+		{"existing tag", withSegments(exifSegment(buildTIFF(le, []tiffField{asciiField(tagMake, "Acme"), shortField(le, tagOrientation, 6)}, nil, nil, nil)))},
+		{"exif without tag", withSegments(exifSegment(buildTIFF(le, []tiffField{asciiField(tagMake, "Acme"), asciiField(tagModel, "Model X1 long name")}, []tiffField{shortField(le, tagISO, 200)}, nil, nil)))},
+	}
+	for _, tt := range tests {
+		for _, orientation := range []int{1, 3, 8} {
+			out, err := setJPEGOrientation(tt.data, orientation)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if got := jpegOrientation(out); got != orientation {
+				t.Errorf("%s: orientation %d, want %d", tt.name, got, orientation)
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("%s: output does not decode: %v", tt.name, err)
+			}
+
+			outSegments, outScan, _ := readJPEGSegments(out)
+			if !bytes.Equal(outScan, scan) {
+				t.Errorf("%s: scan data changed", tt.name)
+			}
+			if outSegments[0].Marker == markerAPP1 && bytes.Equal(tt.data[2:4], []byte{0xFF, markerAPP0}) {
+				t.Errorf("%s: Exif inserted before JFIF", tt.name)
+			}
+
+			// Other tags survive the rewrite
+			e, err := parseExif(findExifSegment(outSegments))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			if bytes.Contains(tt.data, []byte("Acme")) && e.string(e.IFD0[tagMake]) != "Acme" {
+				t.Errorf("%s: make lost", tt.name)
+			}
+			if bytes.Contains(tt.data, []byte("Model X1")) {
+				if e.string(e.IFD0[tagModel]) != "Model X1 long name" || e.uint(e.Exif[tagISO], 0) != 200 {
+					t.Errorf("%s: model or Exif IFD lost", tt.name)
+				}
+			}
+		}
+	}
+}
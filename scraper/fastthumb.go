@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+)
+
+// ============ FAST THUMBNAIL PATH ============
+
+// Fast mode avoids the two expensive steps of the quality path:
+//  1. JPEGs with a large enough EXIF thumbnail skip the full decode entirely.
+//  2. Large images get an integer box-filter pre-shrink to about twice the
+//     target size, so CatmullRom only runs over a small buffer.
+
+// thumbAspectTolerance is how far an embedded thumbnail's aspect ratio may
+// drift from the main image before it is rejected (letterboxed thumbnails)
+const thumbAspectTolerance = 0.02
+
+// fitScale returns the factor fitImage will scale a w x h source by
+func fitScale(w, h int, fit FitOptions) float64 {
+	sx := float64(fit.Width) / float64(w)
+	sy := float64(fit.Height) / float64(h)
+
+	switch fit.Mode {
+	case "cover", "exact":
+		return math.Max(sx, sy)
+	default: // contain, pad never upscale
+		return math.Min(1, math.Min(sx, sy))
+	}
+}
+
+// decodeFast decodes data for thumbnailing, using the embedded EXIF
+// thumbnail when it is big enough and box-shrinking large images
+func decodeFast(data []byte, fit FitOptions) (image.Image, string, error) {
+	if isJPEG(data) {
+		if thumb := embeddedThumbnail(data, fit); thumb != nil {
+			return thumb, "jpeg", nil
+		}
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := img.Bounds()
+	scale := fitScale(bounds.Dx(), bounds.Dy(), fit)
+	if factor := int(1 / (2 * scale)); factor >= 2 {
+		img = boxShrink(img, factor)
+	}
+	return img, format, nil
+}
+
+// embeddedThumbnail returns the decoded EXIF thumbnail if it matches the main
+// image's aspect ratio and has enough pixels for the requested fit
+func embeddedThumbnail(data []byte, fit FitOptions) image.Image {
+	segments, _, err := readJPEGSegments(data)
+	if err != nil {
+		return nil
+	}
+	tiff := findExifSegment(segments)
+	if tiff == nil {
+		return nil
+	}
+	exif, err := parseExif(tiff)
+	if err != nil {
+		return nil
+	}
+	thumbData := exif.thumbnail()
+	if thumbData == nil {
+		return nil
+	}
+
+	mainCfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	thumbCfg, err := jpeg.DecodeConfig(bytes.NewReader(thumbData))
+	if err != nil {
+		return nil
+	}
+
+	mainAspect := float64(mainCfg.Width) / float64(mainCfg.Height)
+	thumbAspect := float64(thumbCfg.Width) / float64(thumbCfg.Height)
+	if math.Abs(mainAspect-thumbAspect)/mainAspect > thumbAspectTolerance {
+		return nil
+	}
+
+	// The thumbnail must be at least as large as the fitted output
+	scale := fitScale(mainCfg.Width, mainCfg.Height, fit)
+	if float64(thumbCfg.Width) < float64(mainCfg.Width)*scale ||
+		float64(thumbCfg.Height) < float64(mainCfg.Height)*scale {
+		return nil
+	}
+
+	thumb, err := jpeg.Decode(bytes.NewReader(thumbData))
+	if err != nil {
+		return nil
+	}
+	return thumb
+}
+
+// boxShrink downsamples img by an integer factor, averaging factor x factor
+// blocks. YCbCr (decoded JPEG) and RGBA/NRGBA sources read pixels directly.
+func boxShrink(img image.Image, factor int) *image.RGBA {
+	bounds := img.Bounds()
+	dstW := max(1, bounds.Dx()/factor)
+	dstH := max(1, bounds.Dy()/factor)
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		boxShrinkYCbCr(dst, src, factor)
+	case *image.RGBA:
+		boxShrinkPix(dst, src.Pix, src.Stride, factor, false)
+	case *image.NRGBA:
+		boxShrinkPix(dst, src.Pix, src.Stride, factor, true)
+	default:
+		n := uint32(factor * factor)
+		for dy := 0; dy < dstH; dy++ {
+			for dx := 0; dx < dstW; dx++ {
+				var r, g, b, a uint32
+				for y := 0; y < factor; y++ {
+					for x := 0; x < factor; x++ {
+						pr, pg, pb, pa := img.At(bounds.Min.X+dx*factor+x, bounds.Min.Y+dy*factor+y).RGBA()
+						r += pr
+						g += pg
+						b += pb
+						a += pa
+					}
+				}
+				i := dst.PixOffset(dx, dy)
+				dst.Pix[i] = uint8(r / n >> 8)
+				dst.Pix[i+1] = uint8(g / n >> 8)
+				dst.Pix[i+2] = uint8(b / n >> 8)
+				dst.Pix[i+3] = uint8(a / n >> 8)
+			}
+		}
+	}
+
+	return dst
+}
+
+// boxShrinkYCbCr averages in YCbCr space (a linear transform of RGB) and
+// converts once per output pixel
+func boxShrinkYCbCr(dst *image.RGBA, src *image.YCbCr, factor int) {
+	bounds := src.Rect
+	n := factor * factor
+
+	for dy := 0; dy < dst.Rect.Dy(); dy++ {
+		for dx := 0; dx < dst.Rect.Dx(); dx++ {
+			var ys, cbs, crs int
+			for y := 0; y < factor; y++ {
+				py := bounds.Min.Y + dy*factor + y
+				for x := 0; x < factor; x++ {
+					px := bounds.Min.X + dx*factor + x
+					ys += int(src.Y[src.YOffset(px, py)])
+					ci := src.COffset(px, py)
+					cbs += int(src.Cb[ci])
+					crs += int(src.Cr[ci])
+				}
+			}
+			r, g, b := color.YCbCrToRGB(uint8(ys/n), uint8(cbs/n), uint8(crs/n))
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = r
+			dst.Pix[i+1] = g
+			dst.Pix[i+2] = b
+			dst.Pix[i+3] = 0xff
+		}
+	}
+}
+
+// boxShrinkPix averages 8-bit RGBA-layout pixels. NRGBA sources are
+// premultiplied while summing so transparent pixels do not bleed colour.
+func boxShrinkPix(dst *image.RGBA, pix []uint8, stride int, factor int, nonPremul bool) {
+	n := uint32(factor * factor)
+
+	for dy := 0; dy < dst.Rect.Dy(); dy++ {
+		for dx := 0; dx < dst.Rect.Dx(); dx++ {
+			var r, g, b, a uint32
+			for y := 0; y < factor; y++ {
+				row := (dy*factor + y) * stride
+				for x := 0; x < factor; x++ {
+					i := row + (dx*factor+x)*4
+					pa := uint32(pix[i+3])
+					if nonPremul {
+						r += uint32(pix[i]) * pa / 255
+						g += uint32(pix[i+1]) * pa / 255
+						b += uint32(pix[i+2]) * pa / 255
+					} else {
+						r += uint32(pix[i])
+						g += uint32(pix[i+1])
+						b += uint32(pix[i+2])
+					}
+					a += pa
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+}
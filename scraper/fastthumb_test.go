@@ -0,0 +1,49 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchJPEG writes a 3000x2000 gradient JPEG, about the size of a phone photo
+func benchJPEG(b *testing.B) string {
+	b.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 3000, 2000))
+	for y := 0; y < 2000; y++ {
+		for x := 0; x < 3000; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	path := filepath.Join(b.TempDir(), "photo.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 85}); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+func benchmarkThumbnail(b *testing.B, mode string) {
+	path := benchJPEG(b)
+	opts := ThumbnailOptions{
+		Fit:  FitOptions{Mode: "contain", Width: 200, Height: 200, Gravity: "center", Background: color.White},
+		Mode: mode,
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := renderThumbnail(path, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThumbnailQuality(b *testing.B) { benchmarkThumbnail(b, "quality") }
+
+func BenchmarkThumbnailFast(b *testing.B) { benchmarkThumbnail(b, "fast") }
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ FILTER PIPELINE ============
+
+// FilterStep is one adjustment in a filter pipeline. Amount defaults:
+// grayscale, sepia and invert 1 (full strength); sharpen 1; auto-levels
+// 0.001 (fraction of pixels clipped at each end). Brightness, contrast and
+// saturation take -1..1; gamma > 0.
+type FilterStep struct {
+	Type      string   `json:"type"` // brightness, contrast, saturation, gamma, grayscale, sepia, invert, sharpen, blur, auto-levels
+	Amount    *float64 `json:"amount,omitempty"`
+	Radius    float64  `json:"radius,omitempty"`    // blur and sharpen sigma in pixels
+	Threshold float64  `json:"threshold,omitempty"` // sharpen: minimum difference (0-255) to enhance
+}
+
+// Filter defaults
+const (
+	filterBlurRadius    = 2
+	filterSharpenRadius = 1
+	filterLevelsClip    = 0.001
+)
+
+// amount returns the step's amount or def when unset
+func (s FilterStep) amount(def float64) float64 {
+	if s.Amount == nil {
+		return def
+	}
+	return *s.Amount
+}
+
+// parseFilters reads a JSON array of filter steps inline or from a file
+func parseFilters(s string) ([]FilterStep, error) {
+	data, err := jsonArgBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("filters: %v", err)
+	}
+
+	var steps []FilterStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("filters: %v", err)
+	}
+	for i, step := range steps {
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("filter %d: %v", i, err)
+		}
+	}
+	return steps, nil
+}
+
+// validate checks the step type and ranges
+func (s FilterStep) validate() error {
+	switch s.Type {
+	case "brightness", "contrast", "saturation":
+		if s.Amount == nil {
+			return fmt.Errorf("%s needs an amount", s.Type)
+		}
+		if a := *s.Amount; a < -1 || a > 1 {
+			return fmt.Errorf("%s amount must be between -1 and 1", s.Type)
+		}
+	case "gamma":
+		if s.Amount == nil || *s.Amount <= 0 {
+			return fmt.Errorf("gamma needs an amount > 0")
+		}
+	case "grayscale", "sepia", "invert":
+		if a := s.amount(1); a < 0 || a > 1 {
+			return fmt.Errorf("%s amount must be between 0 and 1", s.Type)
+		}
+	case "sharpen":
+		if s.amount(1) < 0 || s.Radius < 0 || s.Threshold < 0 {
+			return fmt.Errorf("sharpen amount, radius and threshold must not be negative")
+		}
+	case "blur":
+		if s.Radius < 0 {
+			return fmt.Errorf("blur radius must not be negative")
+		}
+	case "auto-levels":
+		if a := s.amount(filterLevelsClip); a < 0 || a >= 0.5 {
+			return fmt.Errorf("auto-levels clip must be in [0, 0.5)")
+		}
+	default:
+		return fmt.Errorf("unknown filter: %q", s.Type)
+	}
+	return nil
+}
+
+// parallelRows splits h rows into bands and runs fn on them concurrently
+func parallelRows(h int, fn func(y0, y1 int)) {
+	workers := runtime.NumCPU()
+	band := max(16, (h+workers*4-1)/(workers*4))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for y0 := 0; y0 < h; y0 += band {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fn(y0, y1)
+		}(y0, min(h, y0+band))
+	}
+	wg.Wait()
+}
+
+// pointOp is a per-pixel colour adjustment: an optional 3x3 matrix on RGB
+// followed by optional per-channel lookup tables. Alpha is left untouched.
+type pointOp struct {
+	matrix *[3][3]float64
+	luts   *[3][256]uint8
+}
+
+// clamp8 rounds and clamps v to 0-255
+func clamp8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, v+0.5)))
+}
+
+// lutOp builds a pointOp applying f to every channel
+func lutOp(f func(v float64) float64) pointOp {
+	var luts [3][256]uint8
+	for v := 0; v < 256; v++ {
+		out := clamp8(f(float64(v)))
+		luts[0][v], luts[1][v], luts[2][v] = out, out, out
+	}
+	return pointOp{luts: &luts}
+}
+
+// mixMatrix blends the identity with m by amount (0 = identity, 1 = m)
+func mixMatrix(m [3][3]float64, amount float64) pointOp {
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			id := 0.0
+			if i == j {
+				id = 1
+			}
+			out[i][j] = id + (m[i][j]-id)*amount
+		}
+	}
+	return pointOp{matrix: &out}
+}
+
+// Rec. 601 luma weights, as used by the CSS grayscale and saturate filters
+var lumaWeights = [3]float64{0.299, 0.587, 0.114}
+
+// grayMatrix maps every channel to luma
+var grayMatrix = [3][3]float64{lumaWeights, lumaWeights, lumaWeights}
+
+// sepiaMatrix is the CSS filter sepia(1) matrix
+var sepiaMatrix = [3][3]float64{
+	{0.393, 0.769, 0.189},
+	{0.349, 0.686, 0.168},
+	{0.272, 0.534, 0.131},
+}
+
+// pointStep returns the pointOp for a colour step, or false for spatial steps
+func pointStep(img *image.NRGBA, s FilterStep) (pointOp, bool) {
+	switch s.Type {
+	case "brightness":
+		offset := s.amount(0) * 255
+		return lutOp(func(v float64) float64 { return v + offset }), true
+	case "contrast":
+		factor := 1 + s.amount(0)
+		return lutOp(func(v float64) float64 { return (v-127.5)*factor + 127.5 }), true
+	case "gamma":
+		inv := 1 / s.amount(1)
+		return lutOp(func(v float64) float64 { return 255 * math.Pow(v/255, inv) }), true
+	case "invert":
+		a := s.amount(1)
+		return lutOp(func(v float64) float64 { return v + (255-2*v)*a }), true
+	case "grayscale":
+		return mixMatrix(grayMatrix, s.amount(1)), true
+	case "saturation":
+		// Saturation 1+amount: -1 is fully grey, 1 doubles saturation
+		return mixMatrix(grayMatrix, -s.amount(0)), true
+	case "sepia":
+		return mixMatrix(sepiaMatrix, s.amount(1)), true
+	case "auto-levels":
+		return autoLevels(img, s.amount(filterLevelsClip)), true
+	}
+	return pointOp{}, false
+}
+
+// apply runs the op over img in parallel row bands
+func (op pointOp) apply(img *image.NRGBA) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for i := 0; i < len(row); i += 4 {
+				if m := op.matrix; m != nil {
+					r, g, b := float64(row[i]), float64(row[i+1]), float64(row[i+2])
+					row[i] = clamp8(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+					row[i+1] = clamp8(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+					row[i+2] = clamp8(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+				}
+				if l := op.luts; l != nil {
+					row[i] = l[0][row[i]]
+					row[i+1] = l[1][row[i+1]]
+					row[i+2] = l[2][row[i+2]]
+				}
+			}
+		}
+	})
+}
+
+// autoLevels stretches each channel so that clip of the visible pixels fall
+// below the new black point and clip above the new white point
+func autoLevels(img *image.NRGBA, clip float64) pointOp {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var mu sync.Mutex
+	var hist [3][256]int
+	total := 0
+	parallelRows(h, func(y0, y1 int) {
+		var local [3][256]int
+		n := 0
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for i := 0; i < len(row); i += 4 {
+				if row[i+3] == 0 {
+					continue
+				}
+				local[0][row[i]]++
+				local[1][row[i+1]]++
+				local[2][row[i+2]]++
+				n++
+			}
+		}
+		mu.Lock()
+		for ch := range hist {
+			for v, c := range local[ch] {
+				hist[ch][v] += c
+			}
+		}
+		total += n
+		mu.Unlock()
+	})
+
+	var luts [3][256]uint8
+	limit := int(clip * float64(total))
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 0, 255
+		for acc := 0; lo < 255; lo++ {
+			if acc += hist[ch][lo]; acc > limit {
+				break
+			}
+		}
+		for acc := 0; hi > 0; hi-- {
+			if acc += hist[ch][hi]; acc > limit {
+				break
+			}
+		}
+		for v := 0; v < 256; v++ {
+			if hi <= lo {
+				luts[ch][v] = uint8(v) // flat channel: nothing to stretch
+				continue
+			}
+			luts[ch][v] = clamp8(float64(v-lo) * 255 / float64(hi-lo))
+		}
+	}
+	return pointOp{luts: &luts}
+}
+
+// gaussianPlane extracts channel ch of img as floats (optionally multiplied
+// by alpha) and blurs it
+func gaussianPlane(img *image.NRGBA, ch int, sigma float64, premultiply bool) []float32 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	plane := make([]float32, w*h)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := img.Pix[y*img.Stride:]
+			for x := 0; x < w; x++ {
+				v := float32(row[x*4+ch])
+				if premultiply {
+					v = v * float32(row[x*4+3]) / 255
+				}
+				plane[y*w+x] = v
+			}
+		}
+	})
+	for _, radius := range gaussianBoxes(sigma, 3) {
+		boxBlurPass(plane, w, h, radius)
+	}
+	return plane
+}
+
+// blurImage applies a gaussian blur one channel at a time. Colours of
+// translucent images are weighted by alpha so transparent pixels do not
+// bleed dark fringes.
+func blurImage(img *image.NRGBA, sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	opaque := img.Opaque()
+
+	var alpha []float32
+	if !opaque {
+		alpha = gaussianPlane(img, 3, sigma, false)
+	}
+	for ch := 0; ch < 3; ch++ {
+		plane := gaussianPlane(img, ch, sigma, !opaque)
+		parallelRows(h, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				row := img.Pix[y*img.Stride:]
+				for x := 0; x < w; x++ {
+					v := plane[y*w+x]
+					if !opaque {
+						a := alpha[y*w+x]
+						if a <= 0 {
+							continue
+						}
+						v = v * 255 / a
+					}
+					row[x*4+ch] = clamp8(float64(v))
+				}
+			}
+		})
+	}
+	if !opaque {
+		parallelRows(h, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				row := img.Pix[y*img.Stride:]
+				for x := 0; x < w; x++ {
+					row[x*4+3] = clamp8(float64(alpha[y*w+x]))
+				}
+			}
+		})
+	}
+}
+
+// sharpenImage applies an unsharp mask: pixels move away from their blurred
+// value by amount wherever they differ by more than threshold
+func sharpenImage(img *image.NRGBA, sigma, amount, threshold float64) {
+	if sigma <= 0 || amount == 0 {
+		return
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	for ch := 0; ch < 3; ch++ {
+		blurred := gaussianPlane(img, ch, sigma, false)
+		parallelRows(h, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				row := img.Pix[y*img.Stride:]
+				for x := 0; x < w; x++ {
+					v := float64(row[x*4+ch])
+					diff := v - float64(blurred[y*w+x])
+					if math.Abs(diff) > threshold {
+						row[x*4+ch] = clamp8(v + diff*amount)
+					}
+				}
+			}
+		})
+	}
+}
+
+// toNRGBA copies img into a new NRGBA image at the origin. Opaque images go
+// through RGBA, which has a fast path from YCbCr and the same pixel layout.
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	r := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	if !hasAlpha(img) {
+		rgba := image.NewRGBA(r)
+		draw.Draw(rgba, r, img, bounds.Min, draw.Src)
+		return &image.NRGBA{Pix: rgba.Pix, Stride: rgba.Stride, Rect: r}
+	}
+	dst := image.NewNRGBA(r)
+	draw.Draw(dst, r, img, bounds.Min, draw.Src)
+	return dst
+}
+
+// fromNRGBA returns opaque results as RGBA (sharing pixels), which the
+// encoders handle much faster
+func fromNRGBA(img *image.NRGBA) image.Image {
+	if img.Opaque() {
+		return &image.RGBA{Pix: img.Pix, Stride: img.Stride, Rect: img.Rect}
+	}
+	return img
+}
+
+// applyFilters runs the steps in order on a copy of img
+func applyFilters(img image.Image, steps []FilterStep) image.Image {
+	dst := toNRGBA(img)
+	for _, s := range steps {
+		if op, ok := pointStep(dst, s); ok {
+			op.apply(dst)
+			continue
+		}
+		switch s.Type {
+		case "blur":
+			radius := s.Radius
+			if radius == 0 {
+				radius = filterBlurRadius
+			}
+			blurImage(dst, radius)
+		case "sharpen":
+			radius := s.Radius
+			if radius == 0 {
+				radius = filterSharpenRadius
+			}
+			sharpenImage(dst, radius, s.amount(1), s.Threshold)
+		}
+	}
+	return fromNRGBA(dst)
+}
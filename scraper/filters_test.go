@@ -0,0 +1,328 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func floatAmount(v float64) *float64 {
+	return &v
+}
+
+func TestFilterStepValidate(t *testing.T) {
+	tests := []struct {
+		step    FilterStep
+		wantErr bool
+	}{
+		{FilterStep{Type: "brightness", Amount: floatAmount(0.5)}, false},
+		{FilterStep{Type: "brightness"}, true},
+		{FilterStep{Type: "contrast", Amount: floatAmount(-1.5)}, true},
+		{FilterStep{Type: "gamma", Amount: floatAmount(2.2)}, false},
+		{FilterStep{Type: "gamma", Amount: floatAmount(0)}, true},
+		{FilterStep{Type: "grayscale"}, false},
+		{FilterStep{Type: "sepia", Amount: floatAmount(1.2)}, true},
+		{FilterStep{Type: "sharpen", Threshold: -1}, true},
+		{FilterStep{Type: "blur", Radius: -2}, true},
+		{FilterStep{Type: "auto-levels", Amount: floatAmount(0.5)}, true},
+		{FilterStep{Type: "auto-levels"}, false},
+		{FilterStep{Type: "vignette"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.step.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s %v: error = %v, wantErr %v", tt.step.Type, tt.step.Amount, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPointSteps(t *testing.T) {
+	tests := []struct {
+		name string
+		step FilterStep
+		in   color.NRGBA
+		want color.NRGBA
+	}{
+		{"brightness", FilterStep{Type: "brightness", Amount: floatAmount(0.2)}, color.NRGBA{100, 100, 100, 128}, color.NRGBA{151, 151, 151, 128}},
+		{"brightness clamps", FilterStep{Type: "brightness", Amount: floatAmount(-1)}, color.NRGBA{200, 10, 255, 255}, color.NRGBA{0, 0, 0, 255}},
+		{"contrast", FilterStep{Type: "contrast", Amount: floatAmount(0.5)}, color.NRGBA{200, 50, 128, 255}, color.NRGBA{236, 11, 128, 255}},
+		{"contrast flattens", FilterStep{Type: "contrast", Amount: floatAmount(-1)}, color.NRGBA{0, 90, 255, 255}, color.NRGBA{128, 128, 128, 255}},
+		{"gamma", FilterStep{Type: "gamma", Amount: floatAmount(2)}, color.NRGBA{64, 0, 255, 255}, color.NRGBA{128, 0, 255, 255}},
+		{"invert", FilterStep{Type: "invert"}, color.NRGBA{0, 100, 255, 64}, color.NRGBA{255, 155, 0, 64}},
+		{"half invert", FilterStep{Type: "invert", Amount: floatAmount(0.5)}, color.NRGBA{0, 100, 255, 255}, color.NRGBA{128, 128, 128, 255}},
+		{"grayscale", FilterStep{Type: "grayscale"}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{76, 76, 76, 255}},
+		{"grayscale none", FilterStep{Type: "grayscale", Amount: floatAmount(0)}, color.NRGBA{255, 0, 0, 255}, color.NRGBA{255, 0, 0, 255}},
+		{"desaturate", FilterStep{Type: "saturation", Amount: floatAmount(-1)}, color.NRGBA{0, 255, 0, 255}, color.NRGBA{150, 150, 150, 255}},
+		{"saturate", FilterStep{Type: "saturation", Amount: floatAmount(1)}, color.NRGBA{200, 100, 100, 255}, color.NRGBA{255, 70, 70, 255}}, // 2v - luma(129.9)
+		{"sepia", FilterStep{Type: "sepia"}, color.NRGBA{100, 100, 100, 255}, color.NRGBA{135, 120, 94, 255}},
+	}
+
+	for _, tt := range tests {
+		img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				img.SetNRGBA(x, y, tt.in)
+			}
+		}
+		op, ok := pointStep(img, tt.step)
+		if !ok {
+			t.Errorf("%s: not a point step", tt.name)
+			continue
+		}
+		op.apply(img)
+		if got := img.NRGBAAt(2, 1); got != tt.want {
+			t.Errorf("%s: %v -> %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+
+	for _, spatial := range []string{"blur", "sharpen"} {
+		if _, ok := pointStep(image.NewNRGBA(image.Rect(0, 0, 1, 1)), FilterStep{Type: spatial}); ok {
+			t.Errorf("%s reported as a point step", spatial)
+		}
+	}
+}
+
+func TestAutoLevels(t *testing.T) {
+	// 151 pixels with values 50..200 in red, a flat green channel and blue
+	// outliers at 0 and 255; one transparent black pixel is ignored
+	build := func() *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 154, 1))
+		for x := 0; x < 151; x++ {
+			img.SetNRGBA(x, 0, color.NRGBA{uint8(50 + x), 90, uint8(50 + x), 255})
+		}
+		img.SetNRGBA(151, 0, color.NRGBA{125, 90, 0, 255})
+		img.SetNRGBA(152, 0, color.NRGBA{125, 90, 255, 255})
+		img.SetNRGBA(153, 0, color.NRGBA{0, 0, 0, 0})
+		return img
+	}
+
+	tests := []struct {
+		name string
+		clip float64
+		// input value -> expected output, per channel
+		red, green, blue [][2]uint8
+	}{
+		{
+			name:  "no clipping keeps outliers as end points",
+			clip:  0,
+			red:   [][2]uint8{{50, 0}, {125, 128}, {200, 255}},
+			green: [][2]uint8{{90, 90}},
+			blue:  [][2]uint8{{0, 0}, {50, 50}, {255, 255}},
+		},
+		{
+			name:  "clipping ignores single outliers",
+			clip:  0.01, // 1% of 153 visible pixels: one pixel at each end
+			red:   [][2]uint8{{50, 0}, {125, 128}, {200, 255}},
+			green: [][2]uint8{{90, 90}},
+			blue:  [][2]uint8{{0, 0}, {50, 0}, {125, 128}, {200, 255}, {255, 255}},
+		},
+	}
+	for _, tt := range tests {
+		op := autoLevels(build(), tt.clip)
+		for ch, pairs := range [][][2]uint8{tt.red, tt.green, tt.blue} {
+			for _, p := range pairs {
+				if got := op.luts[ch][p[0]]; got != p[1] {
+					t.Errorf("%s: channel %d maps %d to %d, want %d", tt.name, ch, p[0], got, p[1])
+				}
+			}
+		}
+	}
+}
+
+// serialBoxBlur is a direct box blur with clamped edges, horizontal then vertical
+func serialBoxBlur(plane []float32, w, h, radius int) []float32 {
+	tmp := make([]float32, len(plane))
+	out := make([]float32, len(plane))
+	n := float32(2*radius + 1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float32
+			for i := -radius; i <= radius; i++ {
+				sum += plane[y*w+max(0, min(w-1, x+i))]
+			}
+			tmp[y*w+x] = sum / n
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float32
+			for i := -radius; i <= radius; i++ {
+				sum += tmp[max(0, min(h-1, y+i))*w+x]
+			}
+			out[y*w+x] = sum / n
+		}
+	}
+	return out
+}
+
+func TestBoxBlurPassMatchesSerial(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct{ w, h, radius int }{
+		{1, 1, 2},
+		{7, 5, 1},
+		{37, 53, 3},
+		{200, 150, 7}, // several row and column bands
+		{13, 300, 20}, // radius wider than the image
+		{300, 9, 0},   // no-op
+		{64, 64, 64},
+	}
+	for _, tt := range tests {
+		plane := make([]float32, tt.w*tt.h)
+		for i := range plane {
+			plane[i] = float32(rng.Intn(256))
+		}
+		want := serialBoxBlur(plane, tt.w, tt.h, tt.radius)
+		if tt.radius == 0 {
+			want = append([]float32(nil), plane...)
+		}
+		boxBlurPass(plane, tt.w, tt.h, tt.radius)
+		for i := range plane {
+			if math.Abs(float64(plane[i]-want[i])) > 0.01 {
+				t.Errorf("%dx%d r%d: pixel %d = %v, want %v", tt.w, tt.h, tt.radius, i, plane[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestGaussianBoxes(t *testing.T) {
+	// A box of radius r has variance ((2r+1)^2 - 1) / 12; the passes add up
+	for _, sigma := range []float64{1, 2, 3.5, 10, 25} {
+		variance := 0.0
+		for _, r := range gaussianBoxes(sigma, 3) {
+			size := float64(2*r + 1)
+			variance += (size*size - 1) / 12
+		}
+		if got := math.Sqrt(variance); math.Abs(got-sigma) > 0.6 {
+			t.Errorf("sigma %v: boxes give %v", sigma, got)
+		}
+	}
+}
+
+func TestBlurImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *image.NRGBA
+		check func(t *testing.T, img *image.NRGBA)
+	}{
+		{
+			name: "uniform stays uniform",
+			build: func() *image.NRGBA {
+				img := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+				for i := 0; i < len(img.Pix); i += 4 {
+					copy(img.Pix[i:], []uint8{40, 120, 200, 255})
+				}
+				return img
+			},
+			check: func(t *testing.T, img *image.NRGBA) {
+				for i := 0; i < len(img.Pix); i += 4 {
+					if c := img.Pix[i : i+4]; c[0] != 40 || c[1] != 120 || c[2] != 200 || c[3] != 255 {
+						t.Fatalf("pixel %d = %v", i/4, c)
+					}
+				}
+			},
+		},
+		{
+			name: "opaque edge softens",
+			build: func() *image.NRGBA {
+				img := image.NewNRGBA(image.Rect(0, 0, 40, 10))
+				for y := 0; y < 10; y++ {
+					for x := 0; x < 40; x++ {
+						v := uint8(0)
+						if x >= 20 {
+							v = 255
+						}
+						img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+					}
+				}
+				return img
+			},
+			check: func(t *testing.T, img *image.NRGBA) {
+				left, right := img.NRGBAAt(19, 5).R, img.NRGBAAt(20, 5).R
+				if left == 0 || right == 255 || left >= right {
+					t.Errorf("edge %d|%d not softened", left, right)
+				}
+				if img.NRGBAAt(0, 5).R != 0 || img.NRGBAAt(39, 5).R != 255 {
+					t.Error("far pixels changed")
+				}
+			},
+		},
+		{
+			// Transparent black next to opaque red must not darken the red
+			name: "translucent colours weighted by alpha",
+			build: func() *image.NRGBA {
+				img := image.NewNRGBA(image.Rect(0, 0, 40, 10))
+				for y := 0; y < 10; y++ {
+					for x := 20; x < 40; x++ {
+						img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+					}
+				}
+				return img
+			},
+			check: func(t *testing.T, img *image.NRGBA) {
+				for x := 15; x < 25; x++ {
+					c := img.NRGBAAt(x, 5)
+					if c.A == 0 {
+						continue
+					}
+					if c.R < 254 || c.G != 0 || c.B != 0 {
+						t.Errorf("x=%d: colour %v darkened or tinted", x, c)
+					}
+				}
+				if a := img.NRGBAAt(19, 5).A; a == 0 || a == 255 {
+					t.Errorf("alpha at the edge = %d, want feathered", a)
+				}
+				if img.NRGBAAt(0, 5).A != 0 || img.NRGBAAt(39, 5).A != 255 {
+					t.Error("far alpha changed")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		img := tt.build()
+		blurImage(img, 2)
+		t.Run(tt.name, func(t *testing.T) { tt.check(t, img) })
+	}
+}
+
+func TestSharpenThreshold(t *testing.T) {
+	// A soft step from 100 to 150
+	build := func() *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, 40, 8))
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 40; x++ {
+				v := uint8(100)
+				if x >= 20 {
+					v = 150
+				}
+				img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+			}
+		}
+		return img
+	}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		threshold float64
+		sharpened bool
+	}{
+		{"no threshold", 1, 0, true},
+		{"threshold below the local difference", 1, 5, true},
+		{"threshold above the local difference", 1, 30, false},
+		{"zero amount", 0, 0, false},
+	}
+	for _, tt := range tests {
+		img := build()
+		sharpenImage(img, 1, tt.amount, tt.threshold)
+		dark, bright := img.NRGBAAt(19, 4).R, img.NRGBAAt(20, 4).R
+		if got := dark < 100 && bright > 150; got != tt.sharpened {
+			t.Errorf("%s: edge %d|%d, sharpened = %v, want %v", tt.name, dark, bright, got, tt.sharpened)
+		}
+		// Flat areas never change
+		if img.NRGBAAt(2, 4).R != 100 || img.NRGBAAt(37, 4).R != 150 {
+			t.Errorf("%s: flat area changed", tt.name)
+		}
+	}
+}
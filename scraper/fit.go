@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ FIT MODES ============
+
+// FitOptions describes how an image is fitted into a target box
+type FitOptions struct {
+	Mode       string      // contain, cover, pad, exact
+	Width      int         // target box width
+	Height     int         // target box height
+	Gravity    string      // cover anchor: center, top, bottom, left, right, smart
+	Background color.Color // pad fill colour
+}
+
+var validFitModes = map[string]bool{"contain": true, "cover": true, "pad": true, "exact": true}
+
+var validGravities = map[string]bool{
+	"center": true, "top": true, "bottom": true, "left": true, "right": true, "smart": true,
+}
+
+// validate normalises the options and reports unsupported values
+func (o *FitOptions) validate() error {
+	if o.Mode == "" {
+		o.Mode = "contain"
+	}
+	if o.Gravity == "" {
+		o.Gravity = "center"
+	}
+	if !validFitModes[o.Mode] {
+		return fmt.Errorf("unknown fit mode: %s", o.Mode)
+	}
+	if !validGravities[o.Gravity] {
+		return fmt.Errorf("unknown gravity: %s", o.Gravity)
+	}
+	if o.Width <= 0 || o.Height <= 0 {
+		return fmt.Errorf("invalid fit box: %dx%d", o.Width, o.Height)
+	}
+	if o.Background == nil {
+		o.Background = color.White
+	}
+	return nil
+}
+
+// fitImage scales img into the box described by opts using CatmullRom
+func fitImage(img image.Image, opts FitOptions) *image.RGBA {
+	bounds := img.Bounds()
+	origW := bounds.Dx()
+	origH := bounds.Dy()
+
+	switch opts.Mode {
+	case "exact":
+		dst := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+		return dst
+
+	case "cover":
+		src := coverRect(img, opts.Width, opts.Height, opts.Gravity)
+		dst := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+		return dst
+
+	case "pad":
+		newW, newH := containSize(origW, origH, opts.Width, opts.Height)
+		dst := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+		offX := (opts.Width - newW) / 2
+		offY := (opts.Height - newH) / 2
+		draw.CatmullRom.Scale(dst, image.Rect(offX, offY, offX+newW, offY+newH), img, bounds, draw.Over, nil)
+		return dst
+
+	default: // contain
+		newW, newH := containSize(origW, origH, opts.Width, opts.Height)
+		dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+		return dst
+	}
+}
+
+// containSize fits origW x origH inside boxW x boxH without upscaling
+func containSize(origW, origH, boxW, boxH int) (int, int) {
+	if origW <= boxW && origH <= boxH {
+		return origW, origH
+	}
+
+	scale := float64(boxW) / float64(origW)
+	if s := float64(boxH) / float64(origH); s < scale {
+		scale = s
+	}
+
+	newW := int(float64(origW) * scale)
+	newH := int(float64(origH) * scale)
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+	return newW, newH
+}
+
+// coverRect returns the largest source rectangle with the box aspect ratio,
+// positioned according to gravity
+func coverRect(img image.Image, boxW, boxH int, gravity string) image.Rectangle {
+	return aspectRect(img, float64(boxW)/float64(boxH), gravity)
+}
+
+// aspectRect returns the largest rectangle with the given width/height ratio
+// that fits in img, positioned according to gravity
+func aspectRect(img image.Image, ratio float64, gravity string) image.Rectangle {
+	bounds := img.Bounds()
+	origW := bounds.Dx()
+	origH := bounds.Dy()
+
+	cropW := origW
+	cropH := int(float64(origW) / ratio)
+	if cropH > origH {
+		cropH = origH
+		cropW = int(float64(origH) * ratio)
+	}
+	if cropW < 1 {
+		cropW = 1
+	}
+	if cropH < 1 {
+		cropH = 1
+	}
+
+	return anchorRect(img, cropW, cropH, gravity)
+}
+
+// anchorRect places a cropW x cropH window inside img according to gravity
+func anchorRect(img image.Image, cropW, cropH int, gravity string) image.Rectangle {
+	bounds := img.Bounds()
+	origW := bounds.Dx()
+	origH := bounds.Dy()
+
+	x := (origW - cropW) / 2
+	y := (origH - cropH) / 2
+
+	switch gravity {
+	case "top":
+		y = 0
+	case "bottom":
+		y = origH - cropH
+	case "left":
+		x = 0
+	case "right":
+		x = origW - cropW
+	case "smart":
+		x, y = smartCropOffset(img, cropW, cropH)
+	}
+
+	return image.Rect(bounds.Min.X+x, bounds.Min.Y+y, bounds.Min.X+x+cropW, bounds.Min.Y+y+cropH)
+}
+
+// ============ SMART CROP ============
+
+// smartCropAnalysisSize is the longest side of the downsampled copy used for scoring
+const smartCropAnalysisSize = 256
+
+// smartCropOffset picks the crop window position with the most edge energy.
+// Scoring runs on a downsampled copy: per-pixel energy is the luma gradient
+// plus a saturation term, summed over candidate windows via an integral image.
+func smartCropOffset(img image.Image, cropW, cropH int) (int, int) {
+	bounds := img.Bounds()
+	origW := bounds.Dx()
+	origH := bounds.Dy()
+
+	if cropW >= origW && cropH >= origH {
+		return 0, 0
+	}
+
+	// Downsample for analysis
+	scale := 1.0
+	if origW > smartCropAnalysisSize || origH > smartCropAnalysisSize {
+		scale = float64(smartCropAnalysisSize) / float64(max(origW, origH))
+	}
+	aw := max(1, int(float64(origW)*scale))
+	ah := max(1, int(float64(origH)*scale))
+	small := image.NewRGBA(image.Rect(0, 0, aw, ah))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, bounds, draw.Src, nil)
+
+	// Luma and saturation per pixel
+	luma := make([]float64, aw*ah)
+	sat := make([]float64, aw*ah)
+	for y := 0; y < ah; y++ {
+		for x := 0; x < aw; x++ {
+			i := small.PixOffset(x, y)
+			r := float64(small.Pix[i])
+			g := float64(small.Pix[i+1])
+			b := float64(small.Pix[i+2])
+			luma[y*aw+x] = 0.299*r + 0.587*g + 0.114*b
+			hi := max(r, g, b)
+			lo := min(r, g, b)
+			if hi > 0 {
+				sat[y*aw+x] = (hi - lo) / hi
+			}
+		}
+	}
+
+	// Integral image of energy (edge magnitude + weighted saturation)
+	integral := make([]float64, (aw+1)*(ah+1))
+	for y := 0; y < ah; y++ {
+		rowSum := 0.0
+		for x := 0; x < aw; x++ {
+			c := luma[y*aw+x]
+			dx, dy := 0.0, 0.0
+			if x+1 < aw {
+				dx = luma[y*aw+x+1] - c
+			}
+			if y+1 < ah {
+				dy = luma[(y+1)*aw+x] - c
+			}
+			energy := abs(dx) + abs(dy) + 32*sat[y*aw+x]
+			rowSum += energy
+			integral[(y+1)*(aw+1)+x+1] = integral[y*(aw+1)+x+1] + rowSum
+		}
+	}
+
+	windowSum := func(x0, y0, x1, y1 int) float64 {
+		w := aw + 1
+		return integral[y1*w+x1] - integral[y0*w+x1] - integral[y1*w+x0] + integral[y0*w+x0]
+	}
+
+	cw := min(aw, max(1, int(float64(cropW)*scale)))
+	ch := min(ah, max(1, int(float64(cropH)*scale)))
+
+	// Start centred so ties keep the centre crop
+	bestX := (aw - cw) / 2
+	bestY := (ah - ch) / 2
+	bestScore := windowSum(bestX, bestY, bestX+cw, bestY+ch)
+
+	for y := 0; y+ch <= ah; y++ {
+		for x := 0; x+cw <= aw; x++ {
+			if s := windowSum(x, y, x+cw, y+ch); s > bestScore {
+				bestScore = s
+				bestX = x
+				bestY = y
+			}
+		}
+	}
+
+	// Map back to source coordinates
+	x := int(float64(bestX) / scale)
+	y := int(float64(bestY) / scale)
+	if x+cropW > origW {
+		x = origW - cropW
+	}
+	if y+cropH > origH {
+		y = origH - cropH
+	}
+	return max(0, x), max(0, y)
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// ============ COLOUR HELPERS ============
+
+// parseHexColor parses #rgb, #rrggbb or #rrggbbaa (leading # optional)
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if s == "" {
+		return color.RGBA{}, fmt.Errorf("empty colour")
+	}
+
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) == 6 {
+		s += "ff"
+	}
+	if len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid colour: %s", s)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid colour: %s", s)
+	}
+
+	// color.RGBA is alpha-premultiplied
+	a := uint32(v & 0xff)
+	r := uint32(v>>24) * a / 255
+	g := uint32(v>>16&0xff) * a / 255
+	b := uint32(v>>8&0xff) * a / 255
+	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a)}, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestContainSize(t *testing.T) {
+	tests := []struct {
+		w, h, boxW, boxH int
+		wantW, wantH     int
+	}{
+		{100, 50, 200, 200, 100, 50}, // never upscales
+		{400, 200, 200, 200, 200, 100},
+		{200, 400, 200, 200, 100, 200},
+		{1000, 10, 100, 100, 100, 1},
+		{10000, 1, 100, 100, 100, 1}, // never below one pixel
+	}
+	for _, tt := range tests {
+		w, h := containSize(tt.w, tt.h, tt.boxW, tt.boxH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("containSize(%d, %d, %d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.boxW, tt.boxH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	src := solidImage(400, 200, red)
+
+	tests := []struct {
+		mode       string
+		wantW      int
+		wantH      int
+		corner     color.RGBA // pixel (0,0)
+		centreFill color.RGBA
+	}{
+		{"contain", 100, 50, red, red},
+		{"cover", 100, 100, red, red},
+		{"exact", 100, 100, red, red},
+		{"pad", 100, 100, blue, red}, // 100x50 centred on a blue 100x100
+	}
+	for _, tt := range tests {
+		opts := FitOptions{Mode: tt.mode, Width: 100, Height: 100, Background: blue}
+		if err := opts.validate(); err != nil {
+			t.Fatal(err)
+		}
+		got := fitImage(src, opts)
+		if got.Bounds().Dx() != tt.wantW || got.Bounds().Dy() != tt.wantH {
+			t.Errorf("%s: %v, want %dx%d", tt.mode, got.Bounds(), tt.wantW, tt.wantH)
+			continue
+		}
+		if c := got.RGBAAt(0, 0); c != tt.corner {
+			t.Errorf("%s: corner %v, want %v", tt.mode, c, tt.corner)
+		}
+		if c := got.RGBAAt(tt.wantW/2, tt.wantH/2); c != tt.centreFill {
+			t.Errorf("%s: centre %v, want %v", tt.mode, c, tt.centreFill)
+		}
+	}
+}
+
+func TestFitOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    FitOptions
+		wantErr bool
+	}{
+		{FitOptions{Width: 10, Height: 10}, false},
+		{FitOptions{Mode: "stretch", Width: 10, Height: 10}, true},
+		{FitOptions{Gravity: "north", Width: 10, Height: 10}, true},
+		{FitOptions{Width: 0, Height: 10}, true},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		err := opts.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%+v: error %v, want error %v", tt.opts, err, tt.wantErr)
+		}
+		if err == nil && (opts.Mode != "contain" || opts.Gravity != "center" || opts.Background != color.White) {
+			t.Errorf("%+v: defaults not applied: %+v", tt.opts, opts)
+		}
+	}
+}
+
+func TestAnchorRect(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 20, 110, 70)) // 100x50, offset origin
+	tests := []struct {
+		gravity string
+		want    image.Rectangle
+	}{
+		{"center", image.Rect(40, 30, 80, 60)},
+		{"top", image.Rect(40, 20, 80, 50)},
+		{"bottom", image.Rect(40, 40, 80, 70)},
+		{"left", image.Rect(10, 30, 50, 60)},
+		{"right", image.Rect(70, 30, 110, 60)},
+	}
+	for _, tt := range tests {
+		if got := anchorRect(img, 40, 30, tt.gravity); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.gravity, got, tt.want)
+		}
+	}
+
+	// aspectRect takes the largest window of the ratio
+	if got := aspectRect(img, 1, "center"); got != image.Rect(35, 20, 85, 70) {
+		t.Errorf("aspectRect 1:1 = %v", got)
+	}
+	if got := coverRect(img, 200, 50, "top"); got != image.Rect(10, 20, 110, 45) {
+		t.Errorf("coverRect 4:1 = %v", got)
+	}
+}
+
+func TestSmartCropOffset(t *testing.T) {
+	// A flat grey image with a saturated, busy patch
+	tests := []struct {
+		name       string
+		patch      image.Rectangle
+		cropW      int
+		cropH      int
+		wantInside image.Point // a patch pixel the crop must contain
+	}{
+		{"patch on the right", image.Rect(520, 100, 580, 160), 200, 300, image.Pt(550, 130)},
+		{"patch on the left", image.Rect(10, 100, 70, 160), 200, 300, image.Pt(40, 130)},
+		{"patch at the bottom", image.Rect(270, 250, 330, 290), 600, 100, image.Pt(300, 270)},
+	}
+	for _, tt := range tests {
+		img := solidImage(600, 300, color.RGBA{128, 128, 128, 255})
+		for y := tt.patch.Min.Y; y < tt.patch.Max.Y; y++ {
+			for x := tt.patch.Min.X; x < tt.patch.Max.X; x++ {
+				if (x/4+y/4)%2 == 0 {
+					img.Set(x, y, color.RGBA{255, 0, 0, 255})
+				} else {
+					img.Set(x, y, color.RGBA{0, 0, 255, 255})
+				}
+			}
+		}
+		r := anchorRect(img, tt.cropW, tt.cropH, "smart")
+		if r.Dx() != tt.cropW || r.Dy() != tt.cropH || !r.In(img.Bounds()) {
+			t.Errorf("%s: crop %v does not fit", tt.name, r)
+		}
+		if !tt.wantInside.In(r) {
+			t.Errorf("%s: crop %v misses the detail at %v", tt.name, r, tt.wantInside)
+		}
+	}
+
+	// A flat image keeps the centre crop, up to the analysis scale rounding
+	flat := solidImage(600, 300, color.RGBA{90, 90, 90, 255})
+	got, centre := anchorRect(flat, 200, 300, "smart"), anchorRect(flat, 200, 300, "center")
+	if d := got.Min.X - centre.Min.X; d < -2 || d > 2 || got.Min.Y != centre.Min.Y {
+		t.Errorf("flat image: smart crop %v, want about %v", got, centre)
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{"#fff", color.RGBA{255, 255, 255, 255}, false},
+		{"ff0000", color.RGBA{255, 0, 0, 255}, false},
+		{" #00ff0080 ", color.RGBA{0, 128, 0, 128}, false}, // premultiplied
+		{"#00000000", color.RGBA{}, false},
+		{"", color.RGBA{}, true},
+		{"#ff00", color.RGBA{}, true},
+		{"#gggggg", color.RGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, %v; want %v (error %v)", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
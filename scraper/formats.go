@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"golang.org/x/image/bmp" // also registers the BMP decoder
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff" // also registers the TIFF decoder
+)
+
+// ============ BMP / TIFF / ICO FORMATS ============
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", decodeICO, decodeICOConfig)
+}
+
+// icoMaxSide is the largest icon an ICO directory entry can describe
+const icoMaxSide = 256
+
+// icoEntry is one image of an ICO/CUR file
+type icoEntry struct {
+	Width, Height int
+	BitCount      int
+	Data          []byte // PNG stream or headerless BMP (DIB)
+}
+
+// readICOEntries parses the icon directory and measures each entry from its
+// own header, since directory sizes are often wrong or zero
+func readICOEntries(r io.Reader) ([]icoEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 {
+		return nil, fmt.Errorf("ico: short header")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 || len(data) < 6+count*16 {
+		return nil, fmt.Errorf("ico: bad directory")
+	}
+
+	entries := make([]icoEntry, 0, count)
+	for i := 0; i < count; i++ {
+		dir := data[6+i*16:]
+		size := int(binary.LittleEndian.Uint32(dir[8:]))
+		offset := int(binary.LittleEndian.Uint32(dir[12:]))
+		if offset < 0 || size <= 0 || offset+size > len(data) || offset+size < offset {
+			continue
+		}
+		e := icoEntry{Data: data[offset : offset+size]}
+
+		if bytes.HasPrefix(e.Data, pngSignature) {
+			cfg, err := png.DecodeConfig(bytes.NewReader(e.Data))
+			if err != nil {
+				continue
+			}
+			e.Width, e.Height, e.BitCount = cfg.Width, cfg.Height, 32
+		} else {
+			if len(e.Data) < 40 {
+				continue
+			}
+			e.Width = int(int32(binary.LittleEndian.Uint32(e.Data[4:])))
+			e.Height = int(int32(binary.LittleEndian.Uint32(e.Data[8:]))) / 2 // colour rows + AND mask rows
+			e.BitCount = int(binary.LittleEndian.Uint16(e.Data[14:]))
+			if e.Width <= 0 || e.Height <= 0 {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("ico: no readable images")
+	}
+	return entries, nil
+}
+
+// largestICOEntry picks the biggest image, preferring more colours on ties
+func largestICOEntry(entries []icoEntry) icoEntry {
+	best := entries[0]
+	for _, e := range entries[1:] {
+		area, bestArea := e.Width*e.Height, best.Width*best.Height
+		if area > bestArea || area == bestArea && e.BitCount > best.BitCount {
+			best = e
+		}
+	}
+	return best
+}
+
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	entries, err := readICOEntries(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	e := largestICOEntry(entries)
+	return image.Config{ColorModel: color.NRGBAModel, Width: e.Width, Height: e.Height}, nil
+}
+
+// decodeICO decodes the largest image of an ICO or CUR file
+func decodeICO(r io.Reader) (image.Image, error) {
+	entries, err := readICOEntries(r)
+	if err != nil {
+		return nil, err
+	}
+	e := largestICOEntry(entries)
+	if bytes.HasPrefix(e.Data, pngSignature) {
+		return png.Decode(bytes.NewReader(e.Data))
+	}
+	return decodeICODIB(e)
+}
+
+// decodeICODIB decodes a bottom-up 1/4/8/24/32-bit DIB followed by its 1-bit
+// AND mask. 32-bit entries use their alpha channel unless it is all zero.
+func decodeICODIB(e icoEntry) (image.Image, error) {
+	data := e.Data
+	headerSize := int(binary.LittleEndian.Uint32(data))
+	compression := binary.LittleEndian.Uint32(data[16:])
+	colorsUsed := int(binary.LittleEndian.Uint32(data[32:]))
+	if compression != 0 && !(compression == 3 && e.BitCount == 32) {
+		return nil, fmt.Errorf("ico: unsupported compression %d", compression)
+	}
+
+	var palette []color.NRGBA
+	switch e.BitCount {
+	case 1, 4, 8:
+		if colorsUsed == 0 || colorsUsed > 1<<e.BitCount {
+			colorsUsed = 1 << e.BitCount
+		}
+		palette = make([]color.NRGBA, colorsUsed)
+		for i := range palette {
+			p := headerSize + i*4
+			if p+4 > len(data) {
+				return nil, fmt.Errorf("ico: short palette")
+			}
+			palette[i] = color.NRGBA{R: data[p+2], G: data[p+1], B: data[p], A: 255}
+		}
+	case 24, 32:
+		if compression == 3 && headerSize == 40 {
+			headerSize += 12 // bit masks follow a 40-byte header
+		}
+	default:
+		return nil, fmt.Errorf("ico: unsupported bit depth %d", e.BitCount)
+	}
+
+	w, h := e.Width, e.Height
+	stride := (w*e.BitCount + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	pixels := headerSize + len(palette)*4
+	mask := pixels + stride*h
+	if pixels+stride*h > len(data) {
+		return nil, fmt.Errorf("ico: short pixel data")
+	}
+	hasMask := mask+maskStride*h <= len(data)
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	alphaSeen := false
+	for y := 0; y < h; y++ {
+		row := data[pixels+(h-1-y)*stride:]
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch e.BitCount {
+			case 1, 4, 8:
+				bit := x * e.BitCount
+				idx := int(row[bit/8]>>(8-e.BitCount-bit%8)) & (1<<e.BitCount - 1)
+				if idx < len(palette) {
+					c = palette[idx]
+				}
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 255}
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				alphaSeen = alphaSeen || c.A != 0
+			}
+			out[x*4], out[x*4+1], out[x*4+2], out[x*4+3] = c.R, c.G, c.B, c.A
+		}
+	}
+
+	if e.BitCount == 32 && alphaSeen {
+		return img, nil
+	}
+	for y := 0; y < h; y++ {
+		out := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			transparent := false
+			if hasMask {
+				m := data[mask+(h-1-y)*maskStride+x/8]
+				transparent = m&(0x80>>(x%8)) != 0
+			}
+			if transparent {
+				out[x*4+3] = 0
+			} else {
+				out[x*4+3] = 255
+			}
+		}
+	}
+	return img, nil
+}
+
+// icoFit scales img down to fit within 256x256, as ICO cannot describe
+// larger images
+func icoFit(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= icoMaxSide && bounds.Dy() <= icoMaxSide {
+		return img
+	}
+	scale := float64(icoMaxSide) / float64(max(bounds.Dx(), bounds.Dy()))
+	w := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+	h := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// encodeICO writes img as a single PNG-compressed icon, scaled by icoFit
+func encodeICO(w io.Writer, img image.Image) error {
+	img = icoFit(img)
+	bounds := img.Bounds()
+
+	var payload bytes.Buffer
+	if err := png.Encode(&payload, img); err != nil {
+		return err
+	}
+
+	header := make([]byte, 6+16)
+	binary.LittleEndian.PutUint16(header[2:], 1) // type: icon
+	binary.LittleEndian.PutUint16(header[4:], 1) // one image
+	header[6] = byte(bounds.Dx() % icoMaxSide)   // 0 means 256
+	header[7] = byte(bounds.Dy() % icoMaxSide)
+	binary.LittleEndian.PutUint16(header[10:], 1)  // planes
+	binary.LittleEndian.PutUint16(header[12:], 32) // bits per pixel
+	binary.LittleEndian.PutUint32(header[14:], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(header[18:], uint32(len(header)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// encodeExtraFormat encodes img as bmp, tiff, ico or lossless webp; ok is
+// false for other formats
+func encodeExtraFormat(w io.Writer, img image.Image, format string) (ok bool, err error) {
+	switch format {
+	case "bmp":
+		return true, bmp.Encode(w, img)
+	case "tiff":
+		return true, tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case "ico":
+		return true, encodeICO(w, img)
+	case "webp":
+		return true, encodeWebP(w, img)
+	}
+	return false, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// icoFile wraps entry payloads in an ICO (kind 1) or CUR (kind 2) directory
+func icoFile(kind uint16, entries ...[]byte) []byte {
+	header := make([]byte, 6+16*len(entries))
+	binary.LittleEndian.PutUint16(header[2:], kind)
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(entries)))
+	offset := len(header)
+	for i, e := range entries {
+		dir := header[6+16*i:]
+		binary.LittleEndian.PutUint32(dir[8:], uint32(len(e)))
+		binary.LittleEndian.PutUint32(dir[12:], uint32(offset))
+		offset += len(e)
+	}
+	return append(header, bytes.Join(entries, nil)...)
+}
+
+// dibEntry builds a bottom-up DIB icon entry. Indexed depths take palette
+// indices from index; 24 and 32 bit take colours from pixel. masked marks
+// pixels set in the AND mask.
+func dibEntry(w, h, bpp int, palette []color.NRGBA, index func(x, y int) int, pixel func(x, y int) color.NRGBA, masked func(x, y int) bool) []byte {
+	header := make([]byte, 40)
+	binary.LittleEndian.PutUint32(header, 40)
+	binary.LittleEndian.PutUint32(header[4:], uint32(w))
+	binary.LittleEndian.PutUint32(header[8:], uint32(h*2))
+	binary.LittleEndian.PutUint16(header[12:], 1)
+	binary.LittleEndian.PutUint16(header[14:], uint16(bpp))
+	binary.LittleEndian.PutUint32(header[32:], uint32(len(palette)))
+
+	data := header
+	for _, c := range palette {
+		data = append(data, c.B, c.G, c.R, 0)
+	}
+
+	stride := (w*bpp + 31) / 32 * 4
+	for y := h - 1; y >= 0; y-- {
+		row := make([]byte, stride)
+		for x := 0; x < w; x++ {
+			switch bpp {
+			case 1, 4, 8:
+				bit := x * bpp
+				row[bit/8] |= byte(index(x, y) << (8 - bpp - bit%8))
+			case 24:
+				c := pixel(x, y)
+				copy(row[x*3:], []byte{c.B, c.G, c.R})
+			case 32:
+				c := pixel(x, y)
+				copy(row[x*4:], []byte{c.B, c.G, c.R, c.A})
+			}
+		}
+		data = append(data, row...)
+	}
+
+	maskStride := (w + 31) / 32 * 4
+	for y := h - 1; y >= 0; y-- {
+		row := make([]byte, maskStride)
+		for x := 0; x < w; x++ {
+			if masked(x, y) {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		data = append(data, row...)
+	}
+	return data
+}
+
+func pngEntry(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeICO(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	halfGreen := color.NRGBA{0, 255, 0, 128}
+	none := func(x, y int) bool { return false }
+	corner := func(x, y int) bool { return x == 0 && y == 0 }
+	checker := func(x, y int) color.NRGBA {
+		if (x+y)%2 == 0 {
+			return red
+		}
+		return blue
+	}
+
+	pngImg := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := range pngImg.Pix {
+		pngImg.Pix[i] = uint8(i)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		size   image.Point
+		want   map[image.Point]color.NRGBA
+	}{
+		{
+			name: "png entry", data: icoFile(1, pngEntry(t, pngImg)), format: "ico", size: image.Pt(32, 32),
+			want: map[image.Point]color.NRGBA{{0, 0}: pngImg.NRGBAAt(0, 0), {31, 31}: pngImg.NRGBAAt(31, 31)},
+		},
+		{
+			name: "24-bit dib with mask", data: icoFile(1, dibEntry(3, 2, 24, nil, nil, checker, corner)), format: "ico", size: image.Pt(3, 2),
+			want: map[image.Point]color.NRGBA{{0, 0}: {255, 0, 0, 0}, {1, 0}: blue, {2, 1}: blue, {0, 1}: blue},
+		},
+		{
+			name: "32-bit dib alpha wins over mask", data: icoFile(1, dibEntry(2, 2, 32, nil, nil, func(x, y int) color.NRGBA { return halfGreen }, corner)), format: "ico", size: image.Pt(2, 2),
+			want: map[image.Point]color.NRGBA{{0, 0}: halfGreen, {1, 1}: halfGreen},
+		},
+		{
+			name: "32-bit dib with zero alpha uses mask", data: icoFile(1, dibEntry(2, 2, 32, nil, nil, func(x, y int) color.NRGBA { return color.NRGBA{R: 9} }, corner)), format: "ico", size: image.Pt(2, 2),
+			want: map[image.Point]color.NRGBA{{0, 0}: {9, 0, 0, 0}, {1, 0}: {9, 0, 0, 255}},
+		},
+		{
+			name: "1-bit dib", data: icoFile(1, dibEntry(9, 1, 1, []color.NRGBA{red, blue}, func(x, y int) int { return x % 2 }, nil, none)), format: "ico", size: image.Pt(9, 1),
+			want: map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue, {8, 0}: red},
+		},
+		{
+			name: "8-bit dib", data: icoFile(1, dibEntry(2, 2, 8, []color.NRGBA{red, blue, halfGreen}, func(x, y int) int { return y*2 + x }, nil, none)), format: "ico", size: image.Pt(2, 2),
+			want: map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue, {0, 1}: {0, 255, 0, 255}, {1, 1}: {0, 0, 0, 255}},
+		},
+		{
+			name: "largest entry wins", data: icoFile(1, dibEntry(16, 16, 24, nil, nil, checker, none), pngEntry(t, pngImg)), format: "ico", size: image.Pt(32, 32),
+		},
+		{
+			name: "cursor", data: icoFile(2, dibEntry(2, 2, 24, nil, nil, checker, none)), format: "cur", size: image.Pt(2, 2),
+			want: map[image.Point]color.NRGBA{{0, 0}: red, {1, 0}: blue},
+		},
+	}
+	for _, tt := range tests {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(tt.data))
+		if err != nil || format != tt.format || cfg.Width != tt.size.X || cfg.Height != tt.size.Y {
+			t.Errorf("%s: config %dx%d %q (%v), want %v %q", tt.name, cfg.Width, cfg.Height, format, err, tt.size, tt.format)
+		}
+		img, _, err := image.Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if img.Bounds().Size() != tt.size {
+			t.Errorf("%s: size %v, want %v", tt.name, img.Bounds().Size(), tt.size)
+		}
+		for p, want := range tt.want {
+			if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeICOErrors(t *testing.T) {
+	valid := icoFile(1, dibEntry(2, 2, 24, nil, nil, func(x, y int) color.NRGBA { return color.NRGBA{A: 255} }, func(x, y int) bool { return false }))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", []byte{0, 0, 1, 0}},
+		{"empty directory", []byte{0, 0, 1, 0, 0, 0}},
+		{"entry beyond the file", valid[:30]},
+		{"unsupported depth", icoFile(1, dibEntry(2, 2, 16, nil, nil, nil, func(x, y int) bool { return false }))},
+	}
+	for _, tt := range tests {
+		if _, err := decodeICO(bytes.NewReader(tt.data)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestEncodeICORoundTrip(t *testing.T) {
+	tests := []struct {
+		w, h int
+		want image.Point
+	}{
+		{16, 16, image.Pt(16, 16)},
+		{256, 256, image.Pt(256, 256)},
+		{600, 300, image.Pt(256, 128)},
+	}
+	for _, tt := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		for i := range src.Pix {
+			src.Pix[i] = 200
+		}
+		var buf bytes.Buffer
+		if err := encodeICO(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		img, err := decodeICO(&buf)
+		if err != nil {
+			t.Errorf("%dx%d: %v", tt.w, tt.h, err)
+			continue
+		}
+		if img.Bounds().Size() != tt.want {
+			t.Errorf("%dx%d: decoded %v, want %v", tt.w, tt.h, img.Bounds().Size(), tt.want)
+		}
+	}
+}
@@ -3,3 +3,5 @@ module repic/scraper
 go 1.21
 
 require golang.org/x/image v0.23.0
+
+require golang.org/x/text v0.21.0 // indirect
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"strconv"
+)
+
+// ============ INFO MODE ============
+
+// ImageInfo is the metadata report for one image
+type ImageInfo struct {
+	Source     string    `json:"source"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	Format     string    `json:"format,omitempty"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	ColorModel string    `json:"color_model,omitempty"`
+	BitDepth   int       `json:"bit_depth,omitempty"`
+	HasAlpha   bool      `json:"has_alpha"`
+	Frames     int       `json:"frames,omitempty"`
+	Size       int64     `json:"size"`
+	Exif       *ExifInfo `json:"exif,omitempty"`
+	ICCProfile string    `json:"icc_profile,omitempty"`
+	HasICC     bool      `json:"has_icc"`
+	HasXMP     bool      `json:"has_xmp"`
+}
+
+// ExifInfo holds the EXIF fields shown in the Info panel
+type ExifInfo struct {
+	Make         string   `json:"make,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Lens         string   `json:"lens,omitempty"`
+	DateTime     string   `json:"datetime,omitempty"`
+	ExposureTime string   `json:"exposure_time,omitempty"`
+	FNumber      float64  `json:"f_number,omitempty"`
+	ISO          int      `json:"iso,omitempty"`
+	FocalLength  float64  `json:"focal_length,omitempty"`
+	Orientation  int      `json:"orientation,omitempty"`
+	GPS          *GPSInfo `json:"gps,omitempty"`
+}
+
+// GPSInfo is a decimal-degree position; Altitude is metres above sea level
+type GPSInfo struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"`
+}
+
+// containerInfo is what the format-specific inspectors report
+type containerInfo struct {
+	BitDepth   int
+	Alpha      bool
+	AlphaKnown bool // Alpha comes from the container rather than the colour model
+	Frames     int
+	Meta       imageMetadata
+}
+
+// describeColorModel returns the model name, its bit depth and whether it carries alpha
+func describeColorModel(m color.Model) (string, int, bool) {
+	if p, ok := m.(color.Palette); ok {
+		alpha := false
+		for _, c := range p {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				alpha = true
+				break
+			}
+		}
+		return "paletted", 8, alpha
+	}
+
+	switch m {
+	case color.RGBAModel:
+		return "rgba", 8, true
+	case color.RGBA64Model:
+		return "rgba64", 16, true
+	case color.NRGBAModel:
+		return "nrgba", 8, true
+	case color.NRGBA64Model:
+		return "nrgba64", 16, true
+	case color.AlphaModel:
+		return "alpha", 8, true
+	case color.Alpha16Model:
+		return "alpha16", 16, true
+	case color.GrayModel:
+		return "gray", 8, false
+	case color.Gray16Model:
+		return "gray16", 16, false
+	case color.YCbCrModel:
+		return "ycbcr", 8, false
+	case color.NYCbCrAModel:
+		return "nycbcra", 8, true
+	case color.CMYKModel:
+		return "cmyk", 8, false
+	}
+	return "unknown", 8, false
+}
+
+// inspectContainer reads format-specific details that DecodeConfig does not expose
+func inspectContainer(format string, data []byte) containerInfo {
+	info := containerInfo{Frames: 1, AlphaKnown: true}
+
+	switch format {
+	case "jpeg":
+		segments, _, err := readJPEGSegments(data)
+		if err != nil {
+			return info
+		}
+		info.Meta = jpegMetadata(segments)
+		for _, seg := range segments {
+			// SOFn (excluding DHT, JPG and DAC): precision is the first byte
+			if seg.Marker >= 0xC0 && seg.Marker <= 0xCF && seg.Marker != 0xC4 && seg.Marker != 0xC8 && seg.Marker != 0xCC && len(seg.Data) > 0 {
+				info.BitDepth = int(seg.Data[0])
+				break
+			}
+		}
+
+	case "png":
+		chunks, err := readPNGChunks(data)
+		if err != nil {
+			return info
+		}
+		info.Meta = pngMetadata(chunks)
+		for _, c := range chunks {
+			switch c.Type {
+			case "IHDR":
+				if len(c.Data) >= 10 {
+					info.BitDepth = int(c.Data[8])
+					colorType := c.Data[9]
+					info.Alpha = info.Alpha || colorType == 4 || colorType == 6
+				}
+			case "tRNS":
+				info.Alpha = true
+			case "acTL":
+				// APNG: number of frames
+				if len(c.Data) >= 4 {
+					info.Frames = int(binary.BigEndian.Uint32(c.Data))
+				}
+			}
+		}
+
+	case "gif":
+		if len(data) >= 11 && data[10]&0x80 != 0 {
+			info.BitDepth = int(data[10]&0x07) + 1
+		}
+		if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil {
+			info.Frames = len(g.Image)
+			// Transparency lives in each frame's palette, not the global table
+			for _, frame := range g.Image {
+				if _, _, alpha := describeColorModel(frame.Palette); alpha {
+					info.Alpha = true
+					break
+				}
+			}
+		}
+
+	case "webp":
+		chunks, err := readWebPChunks(data)
+		if err != nil {
+			return info
+		}
+		info.Meta = webpMetadata(chunks)
+		info.BitDepth = 8
+		frames := 0
+		for _, c := range chunks {
+			switch c.FourCC {
+			case "VP8X":
+				if len(c.Data) > 0 && c.Data[0]&0x10 != 0 {
+					info.Alpha = true
+				}
+			case "ALPH":
+				info.Alpha = true
+			case "ANMF":
+				frames++
+			}
+		}
+		if frames > 0 {
+			info.Frames = frames
+		}
+
+	default:
+		info.AlphaKnown = false
+	}
+
+	return info
+}
+
+// exifInfo extracts the Info panel fields from a TIFF payload
+func exifInfo(tiff []byte) *ExifInfo {
+	e, err := parseExif(tiff)
+	if err != nil {
+		return nil
+	}
+
+	info := &ExifInfo{}
+	if entry, ok := e.IFD0[tagMake]; ok {
+		info.Make = e.string(entry)
+	}
+	if entry, ok := e.IFD0[tagModel]; ok {
+		info.Model = e.string(entry)
+	}
+	if entry, ok := e.IFD0[tagOrientation]; ok {
+		info.Orientation = int(e.uint(entry, 0))
+	}
+
+	dateTime := ""
+	if entry, ok := e.IFD0[tagDateTime]; ok {
+		dateTime = e.string(entry)
+	}
+	if entry, ok := e.Exif[tagDateTimeOriginal]; ok {
+		dateTime = e.string(entry)
+	}
+	info.DateTime = formatExifDateTime(dateTime)
+
+	if entry, ok := e.Exif[tagLensModel]; ok {
+		info.Lens = e.string(entry)
+	}
+	if entry, ok := e.Exif[tagLensMake]; ok && info.Lens == "" {
+		info.Lens = e.string(entry)
+	}
+	if entry, ok := e.Exif[tagExposureTime]; ok {
+		info.ExposureTime = formatExposure(e.rational(entry, 0))
+	}
+	if entry, ok := e.Exif[tagFNumber]; ok {
+		info.FNumber = math.Round(e.float(entry, 0)*10) / 10
+	}
+	if entry, ok := e.Exif[tagISO]; ok {
+		info.ISO = int(e.uint(entry, 0))
+	}
+	if entry, ok := e.Exif[tagFocalLength]; ok {
+		info.FocalLength = math.Round(e.float(entry, 0)*10) / 10
+	}
+
+	info.GPS = gpsInfo(e)
+	return info
+}
+
+// gpsInfo converts the GPS IFD to decimal degrees
+func gpsInfo(e *exifData) *GPSInfo {
+	lat, ok1 := e.GPS[tagGPSLatitude]
+	lon, ok2 := e.GPS[tagGPSLongitude]
+	if !ok1 || !ok2 || lat.Count < 3 || lon.Count < 3 {
+		return nil
+	}
+
+	degrees := func(entry tiffEntry) float64 {
+		return e.float(entry, 0) + e.float(entry, 1)/60 + e.float(entry, 2)/3600
+	}
+
+	gps := &GPSInfo{Latitude: degrees(lat), Longitude: degrees(lon)}
+	if ref, ok := e.GPS[tagGPSLatitudeRef]; ok && e.string(ref) == "S" {
+		gps.Latitude = -gps.Latitude
+	}
+	if ref, ok := e.GPS[tagGPSLongitudeRef]; ok && e.string(ref) == "W" {
+		gps.Longitude = -gps.Longitude
+	}
+	if entry, ok := e.GPS[tagGPSAltitude]; ok {
+		alt := e.float(entry, 0)
+		if ref, ok := e.GPS[tagGPSAltitudeRef]; ok && e.uint(ref, 0) == 1 {
+			alt = -alt
+		}
+		gps.Altitude = &alt
+	}
+	return gps
+}
+
+// formatExifDateTime converts "2006:01:02 15:04:05" to "2006-01-02T15:04:05"
+func formatExifDateTime(s string) string {
+	if len(s) < 19 || s[4] != ':' || s[7] != ':' {
+		return s
+	}
+	return s[:4] + "-" + s[5:7] + "-" + s[8:10] + "T" + s[11:19]
+}
+
+// formatExposure renders an exposure time as "1/125" or "2.5"
+func formatExposure(num, den int64) string {
+	if num <= 0 || den <= 0 {
+		return ""
+	}
+	if num < den {
+		return fmt.Sprintf("1/%d", int64(math.Round(float64(den)/float64(num))))
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+}
+
+// imageInfo reports metadata for a local file, URL or .repic file without
+// decoding pixel data (GIFs are fully decoded to count frames)
+func imageInfo(source string) ImageInfo {
+	info := ImageInfo{Source: source}
+
+	data, err := loadSourceBytes(source)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Size = int64(len(data))
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		info.Error = fmt.Sprintf("decode: %v", err)
+		return info
+	}
+
+	info.Format = format
+	info.Width = cfg.Width
+	info.Height = cfg.Height
+	info.ColorModel, info.BitDepth, info.HasAlpha = describeColorModel(cfg.ColorModel)
+
+	container := inspectContainer(format, data)
+	info.Frames = container.Frames
+	if container.AlphaKnown {
+		info.HasAlpha = container.Alpha
+	}
+	if container.BitDepth > 0 {
+		info.BitDepth = container.BitDepth
+	}
+
+	meta := container.Meta
+	if meta.Exif != nil {
+		info.Exif = exifInfo(meta.Exif)
+	}
+	if meta.ICC != nil || meta.ICCName != "" {
+		info.HasICC = true
+		info.ICCProfile = iccDescription(meta.ICC)
+		if info.ICCProfile == "" {
+			info.ICCProfile = meta.ICCName
+		}
+	}
+	info.HasXMP = meta.XMP != nil
+
+	info.Success = true
+	return info
+}
+
+// batchInfoStreaming reports metadata for files concurrently as NDJSON
+func batchInfoStreaming(files []string, concurrency int) {
+	streamBatch(files, concurrency, func(source string) ProcessItem {
+		info := imageInfo(source)
+		if !info.Success {
+			return ProcessItem{Error: info.Error}
+		}
+		return ProcessItem{Success: true, Width: info.Width, Height: info.Height, Info: &info}
+	})
+}
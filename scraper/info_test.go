@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestExifInfo(t *testing.T) {
+	order := binary.LittleEndian
+	camera := []tiffField{
+		asciiField(tagMake, "Acme"),
+		asciiField(tagModel, "Shooter 5  "),
+		shortField(order, tagOrientation, 6),
+		asciiField(tagDateTime, "2024:01:02 03:04:05"),
+	}
+	exposure := []tiffField{
+		asciiField(tagDateTimeOriginal, "2023:07:08 09:10:11"),
+		rationalField(order, tagExposureTime, 1, 125),
+		rationalField(order, tagFNumber, 28, 10),
+		shortField(order, tagISO, 800),
+		rationalField(order, tagFocalLength, 501, 10),
+		asciiField(tagLensMake, "Acme Optics"),
+	}
+	gps := func(latRef, lonRef string, altRef byte) []tiffField {
+		return []tiffField{
+			asciiField(tagGPSLatitudeRef, latRef),
+			rationalField(order, tagGPSLatitude, 51, 1, 30, 1, 36, 1),
+			asciiField(tagGPSLongitudeRef, lonRef),
+			rationalField(order, tagGPSLongitude, 0, 1, 7, 1, 3, 10),
+			{tagGPSAltitudeRef, tiffByte, 1, []byte{altRef}},
+			rationalField(order, tagGPSAltitude, 255, 10),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		tiff    []byte
+		want    ExifInfo
+		wantGPS *GPSInfo
+	}{
+		{
+			name: "camera only",
+			tiff: buildTIFF(order, camera, nil, nil, nil),
+			want: ExifInfo{Make: "Acme", Model: "Shooter 5", Orientation: 6, DateTime: "2024-01-02T03:04:05"},
+		},
+		{
+			name: "exposure, original date wins",
+			tiff: buildTIFF(order, camera, exposure, nil, nil),
+			want: ExifInfo{Make: "Acme", Model: "Shooter 5", Orientation: 6, DateTime: "2023-07-08T09:10:11",
+				ExposureTime: "1/125", FNumber: 2.8, ISO: 800, FocalLength: 50.1, Lens: "Acme Optics"},
+		},
+		{
+			name: "lens model preferred over lens make",
+			tiff: buildTIFF(order, nil, append(exposure[:len(exposure):len(exposure)], asciiField(tagLensModel, "50mm F1.8")), nil, nil),
+			want: ExifInfo{DateTime: "2023-07-08T09:10:11", ExposureTime: "1/125", FNumber: 2.8, ISO: 800, FocalLength: 50.1, Lens: "50mm F1.8"},
+		},
+		{
+			name:    "north east",
+			tiff:    buildTIFF(order, nil, nil, gps("N", "E", 0), nil),
+			wantGPS: &GPSInfo{Latitude: 51.51, Longitude: 0.11675, Altitude: floatPtr(25.5)},
+		},
+		{
+			name:    "south west below sea level",
+			tiff:    buildTIFF(order, nil, nil, gps("S", "W", 1), nil),
+			wantGPS: &GPSInfo{Latitude: -51.51, Longitude: -0.11675, Altitude: floatPtr(-25.5)},
+		},
+	}
+	for _, tt := range tests {
+		got := exifInfo(tt.tiff)
+		if got == nil {
+			t.Errorf("%s: nil info", tt.name)
+			continue
+		}
+		gotGPS := got.GPS
+		got.GPS = nil
+		if *got != tt.want {
+			t.Errorf("%s: info = %+v, want %+v", tt.name, *got, tt.want)
+		}
+		if (gotGPS == nil) != (tt.wantGPS == nil) {
+			t.Errorf("%s: GPS = %+v, want %+v", tt.name, gotGPS, tt.wantGPS)
+			continue
+		}
+		if tt.wantGPS == nil {
+			continue
+		}
+		if math.Abs(gotGPS.Latitude-tt.wantGPS.Latitude) > 1e-9 || math.Abs(gotGPS.Longitude-tt.wantGPS.Longitude) > 1e-9 {
+			t.Errorf("%s: position %v,%v, want %v,%v", tt.name, gotGPS.Latitude, gotGPS.Longitude, tt.wantGPS.Latitude, tt.wantGPS.Longitude)
+		}
+		if gotGPS.Altitude == nil || *gotGPS.Altitude != *tt.wantGPS.Altitude {
+			t.Errorf("%s: altitude %v, want %v", tt.name, gotGPS.Altitude, *tt.wantGPS.Altitude)
+		}
+	}
+
+	if exifInfo([]byte("garbage")) != nil {
+		t.Error("exifInfo accepted an invalid payload")
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestFormatExifDateTime(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"2024:01:02 03:04:05", "2024-01-02T03:04:05"},
+		{"2024:01:02 03:04:05.123", "2024-01-02T03:04:05"},
+		{"2024-01-02", "2024-01-02"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := formatExifDateTime(tt.in); got != tt.want {
+			t.Errorf("formatExifDateTime(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatExposure(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		want     string
+	}{
+		{1, 125, "1/125"},
+		{10, 1250, "1/125"},
+		{3, 1000, "1/333"},
+		{5, 2, "2.5"},
+		{30, 1, "30"},
+		{0, 1, ""},
+		{1, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := formatExposure(tt.num, tt.den); got != tt.want {
+			t.Errorf("formatExposure(%d, %d) = %q, want %q", tt.num, tt.den, got, tt.want)
+		}
+	}
+}
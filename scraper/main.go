@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -16,6 +17,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -88,6 +90,16 @@ type ThumbnailItem struct {
 	Error     string `json:"error,omitempty"`
 	Width     int    `json:"width,omitempty"`
 	Height    int    `json:"height,omitempty"`
+	Cached    bool   `json:"cached,omitempty"` // true if served from the thumbnail cache
+	Index     int    `json:"index"`            // position in the input list
+}
+
+// ThumbnailOptions controls thumbnail sizing and output
+type ThumbnailOptions struct {
+	Fit      FitOptions
+	Mode     string // quality (full decode) or fast (EXIF thumbnail / box pre-shrink)
+	Base64   bool
+	CacheDir string // on-disk thumbnail cache, empty to disable
 }
 
 type ThumbnailResult struct {
@@ -103,6 +115,7 @@ type ThumbnailResult struct {
 func main() {
 	// Scrape mode
 	urlFlag := flag.String("url", "", "URL to scrape")
+	contentDedupeFlag := flag.Bool("content-dedupe", false, "With --url, fetch every image and drop byte-identical copies")
 
 	// Download mode
 	downloadFlag := flag.Bool("download", false, "Enable batch download mode")
@@ -116,6 +129,28 @@ func main() {
 	sizeFlag := flag.Int("size", 200, "Thumbnail max dimension")
 	base64Flag := flag.Bool("base64", false, "Output thumbnails as base64 instead of files")
 	streamFlag := flag.Bool("stream", false, "Stream results as NDJSON (one item per line)")
+	fitFlag := flag.String("fit", "contain", "Thumbnail fit mode: contain, cover, pad, exact")
+	widthFlag := flag.Int("width", 0, "Target box width (defaults to --size)")
+	heightFlag := flag.Int("height", 0, "Target box height (defaults to --size)")
+	gravityFlag := flag.String("gravity", "center", "Cover anchor: center, top, bottom, left, right, smart")
+	backgroundFlag := flag.String("background", "#ffffff", "Background colour for pad mode (#rrggbb)")
+	cacheDirFlag := flag.String("cache-dir", "", "Thumbnail cache directory (enables caching)")
+	dirFlag := flag.String("dir", "", "Directory to thumbnail instead of --files")
+	recursiveFlag := flag.Bool("recursive", false, "Walk --dir recursively")
+	includeFlag := flag.String("include", "", "Comma-separated globs a file must match (name or relative path)")
+	excludeFlag := flag.String("exclude", "", "Comma-separated globs to skip (files and directories)")
+	extsFlag := flag.String("exts", defaultImageExts, "Comma-separated extensions for --dir")
+	sortFlag := flag.String("sort", "name", "Order for --dir: name, mtime, natural")
+	reverseFlag := flag.Bool("reverse", false, "Reverse the --dir sort order")
+	orderedFlag := flag.Bool("ordered", false, "Stream thumbnails in input order instead of completion order (--priority then reorders work within the window)")
+	windowFlag := flag.Int("window", 0, "How many items past the next unemitted one --ordered may dispatch (default 4x concurrency)")
+	priorityFlag := flag.String("priority", "", "Index ranges to process first, e.g. 0-40")
+	serveFlag := flag.Bool("serve", false, "Keep streaming and read enqueue/priority commands from stdin")
+	thumbModeFlag := flag.String("thumb-mode", "quality", "Thumbnail decode mode: quality, fast")
+
+	// Thumbnail cache maintenance
+	cacheOpFlag := flag.String("cache-op", "", "Thumbnail cache operation: list, prune")
+	cacheMaxMBFlag := flag.Int("cache-max-mb", 0, "Cap total cache size when pruning (MB, 0 = no cap)")
 
 	// Crop mode
 	cropFlag := flag.Bool("crop", false, "Enable crop mode")
@@ -124,31 +159,518 @@ func main() {
 	cropYFlag := flag.Int("y", 0, "Crop Y position (pixels)")
 	cropWFlag := flag.Int("w", 0, "Crop width (pixels)")
 	cropHFlag := flag.Int("h", 0, "Crop height (pixels)")
+	cropPctFlag := flag.String("pct", "", "Crop box as percentages x,y,w,h (overrides --x/--y/--w/--h)")
+
+	// Batch crop mode
+	batchCropFlag := flag.Bool("batch-crop", false, "Enable batch crop mode (uses --files)")
+	aspectFlag := flag.String("aspect", "", "Batch crop aspect ratio, e.g. 16:9 (instead of a box)")
+	anchorFlag := flag.String("anchor", "center", "Aspect crop anchor: center, top, bottom, left, right, smart")
+	outputModeFlag := flag.String("output-mode", "suffix", "Batch crop output: suffix (keeps originals), replace, folder, custom (--output dir)")
+	suffixFlag := flag.String("suffix", "_cropped", "File name suffix for --output-mode suffix")
+
+	// Transform mode
+	transformFlag := flag.String("transform", "", "Transform op: rotate90, rotate180, rotate270, flip-h, flip-v, transpose, transverse, auto-orient, rotate (uses --angle)")
+	angleFlag := flag.Float64("angle", 0, "Rotation in degrees clockwise for --transform rotate")
+	losslessFlag := flag.Bool("lossless", false, "JPEG: rewrite the EXIF orientation instead of re-encoding pixels")
+
+	// Resize mode
+	resizeFlag := flag.Bool("resize", false, "Enable resize mode (--input/--output, or --files with --output dir)")
+	percentFlag := flag.Float64("percent", 0, "Resize by percentage of the original size")
+	maxMPFlag := flag.Float64("max-mp", 0, "Downscale to at most this many megapixels")
+	kernelFlag := flag.String("kernel", "catmullrom", "Resampling kernel: nearest, approx-bilinear, bilinear, catmullrom")
+
+	// Metadata carry-over for crop, batch crop, resize and compress
+	keepMetadataFlag := flag.Bool("keep-metadata", false, "Copy EXIF (updated dimensions), ICC and XMP from the source into JPEG/PNG output")
+
+	// Strip mode (also applies to --download, --prefetch and --transform --lossless)
+	stripFlag := flag.Bool("strip", false, "Remove EXIF/GPS, XMP and comments without re-encoding (--input/--output, or --files with --output dir)")
+	keepOrientationFlag := flag.Bool("keep-orientation", false, "With --strip: keep the EXIF orientation")
+	keepICCFlag := flag.Bool("keep-icc", false, "With --strip: keep the ICC colour profile")
+
+	// Remove background mode
+	removeBgFlag := flag.Bool("remove-bg", false, "Make edge-connected background transparent (PNG); --input may be a file, URL, data URL or - for stdin")
+	toleranceFlag := flag.Float64("tolerance", 40, "Max RGB distance from the detected background colour (0-441)")
+	softnessFlag := flag.Float64("softness", 1, "Edge feather radius in pixels (0 = hard edge)")
+
+	// Annotate mode
+	annotateFlag := flag.String("annotate", "", "Render annotations onto --input: a JSON array of shapes or a path to a .json file")
+	strokeScaleFlag := flag.Float64("stroke-scale", 1, "With --annotate: multiply stroke widths, arrow heads and font sizes")
+
+	// Redact mode
+	redactFlag := flag.String("redact", "", "Hide regions of --input: a JSON array of rectangles/polygons or a path to a .json file (output has no metadata)")
+	redactModeFlag := flag.String("redact-mode", "pixelate", "Redaction: pixelate, fill, blur")
+	redactColorFlag := flag.String("redact-color", "#000000", "Fill colour for --redact-mode fill")
+	redactAmountFlag := flag.Float64("redact-amount", 0, "Pixelate block size or blur sigma (0 = scaled to each region)")
+
+	// Watermark mode (the --wm-* flags also apply to --download, --compress, --crop and --batch-crop)
+	watermarkFlag := flag.Bool("watermark", false, "Stamp a logo or text onto --input/--output, or --files with --output dir")
+	wmLogoFlag := flag.String("wm-logo", "", "Watermark PNG logo (file or URL)")
+	wmTextFlag := flag.String("wm-text", "", "Watermark text (used when --wm-logo is not set)")
+	wmColorFlag := flag.String("wm-color", "#ffffff", "Watermark text colour")
+	wmPositionFlag := flag.String("wm-position", "bottom-right", "Watermark position: center, top-left, top, top-right, left, right, bottom-left, bottom, bottom-right")
+	wmMarginFlag := flag.Float64("wm-margin", 0.02, "Watermark margin as a fraction of the image width")
+	wmOpacityFlag := flag.Float64("wm-opacity", 0.5, "Watermark opacity (0-1)")
+	wmScaleFlag := flag.Float64("wm-scale", 0.2, "Watermark width as a fraction of the image width")
+	wmTileFlag := flag.Bool("wm-tile", false, "Repeat the watermark across the whole image")
+
+	// Info mode
+	infoFlag := flag.Bool("info", false, "Report image metadata for --input, or --files as NDJSON")
 
 	// Compress mode
 	compressFlag := flag.Bool("compress", false, "Enable compress mode")
-	qualityFlag := flag.Int("quality", 85, "JPEG quality (1-100)")
+	qualityFlag := flag.Int("quality", 85, "JPEG quality (1-100; WebP output is always lossless)")
+	targetSizeFlag := flag.String("target-size", "", "Compress to at most this size, e.g. 200KB or 1MB (--quality is the upper bound)")
+	minQualityFlag := flag.Int("min-quality", 40, "Lowest JPEG quality tried for --target-size before downscaling")
+	formatFlag := flag.String("format", "", "Output format: jpeg, png, gif, bmp, tiff, ico, webp (lossless) (default: from the output extension)")
+	colorsFlag := flag.Int("colors", 0, "PNG/GIF palette size 2-256 (median cut; 0 = truecolour PNG)")
+	ditherFlag := flag.Bool("dither", false, "Floyd-Steinberg dithering for palette output")
+	dedupeFlag := flag.Bool("dedupe", false, "Find duplicate images among --files (paths or URLs) or --dir by perceptual and content hash")
+	hashTypeFlag := flag.String("hash-type", "phash", "Perceptual hash for --dedupe: ahash, dhash, phash")
+	distanceFlag := flag.Int("distance", 8, "Max Hamming distance (of 64 bits) from a group's keeper for --dedupe to treat an image as its duplicate")
+	indexOpFlag := flag.String("index-op", "", "Similar-image index operation: build (from --dir), query (--input file or URL)")
+	indexFlag := flag.String("index", "", "Similar-image index file (default: one per --dir in the user cache directory)")
+	topFlag := flag.Int("top", 10, "Number of matches returned by --index-op query")
+	convertFlag := flag.Bool("convert", false, "Convert --input (or --files into the --output dir) to --format with before/after sizes")
+	flattenFlag := flag.String("flatten", "", "Background colour to flatten transparency onto for jpeg output, or for any output with --convert (default: refuse)")
+	filtersFlag := flag.String("filters", "", "Filter pipeline applied before encoding: a JSON array of steps or a path to a .json file (implies --compress)")
+	pipelineFlag := flag.String("pipeline", "", "Run a JSON array of operations (crop, resize, rotate, flip, filter, annotate, watermark, strip, encode) or a path to a .json file, decoding and encoding once")
 
 	// Prefetch mode - download URLs to temp, return local paths (streaming)
 	prefetchFlag := flag.Bool("prefetch", false, "Enable prefetch mode")
 
 	flag.Parse()
 
-	if *cropFlag {
+	// The --wm-* flags only apply to modes that stamp their output; the first
+	// mode set wins, in the order of the dispatch below
+	wmFlagSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "wm-") {
+			wmFlagSet = true
+		}
+	})
+	if wmFlagSet {
+		stamps := false
+		for _, mode := range []struct{ set, stamps bool }{
+			{*batchCropFlag, true},
+			{*transformFlag != "", false},
+			{*removeBgFlag, false},
+			{*annotateFlag != "", false},
+			{*redactFlag != "", false},
+			{*indexOpFlag != "", false},
+			{*dedupeFlag, false},
+			{*convertFlag, false},
+			{*pipelineFlag != "", false},
+			{*watermarkFlag, true},
+			{*infoFlag, false},
+			{*resizeFlag, false},
+			{*cropFlag, true},
+			{*compressFlag || *filtersFlag != "", true},
+			{*prefetchFlag, false},
+			{*cacheOpFlag != "", false},
+			{*thumbnailFlag, false},
+			{*downloadFlag, true},
+		} {
+			if mode.set {
+				stamps = mode.stamps
+				break
+			}
+		}
+		if !stamps {
+			outputJSON(map[string]interface{}{"success": false, "error": "wm-* flags only apply to --watermark, --download, --compress, --crop and --batch-crop (use a watermark op with --pipeline)"})
+			return
+		}
+		if *wmLogoFlag == "" && *wmTextFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "wm-logo or wm-text required"})
+			return
+		}
+	}
+
+	// Watermark step, prepared once for every mode that supports it
+	var wm *watermark
+	if *wmLogoFlag != "" || *wmTextFlag != "" {
+		textColor, err := parseHexColor(*wmColorFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		wm, err = newWatermark(WatermarkOptions{
+			Logo:     *wmLogoFlag,
+			Text:     *wmTextFlag,
+			Color:    textColor,
+			Position: *wmPositionFlag,
+			Margin:   *wmMarginFlag,
+			Opacity:  *wmOpacityFlag,
+			Scale:    *wmScaleFlag,
+			Tile:     *wmTileFlag,
+		})
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+	}
+
+	if *batchCropFlag {
+		// Batch crop mode
+		if *filesFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "files required"})
+			return
+		}
+		opts := BatchCropOptions{
+			Box:          cropBox{X: float64(*cropXFlag), Y: float64(*cropYFlag), W: float64(*cropWFlag), H: float64(*cropHFlag)},
+			Anchor:       *anchorFlag,
+			OutputMode:   *outputModeFlag,
+			OutputDir:    *outputFlag,
+			Suffix:       *suffixFlag,
+			KeepMetadata: *keepMetadataFlag,
+			Watermark:    wm,
+		}
+		if *cropPctFlag != "" {
+			pct, err := parsePercentBox(*cropPctFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.Box = pct
+		}
+		if *aspectFlag != "" {
+			ratio, err := parseAspect(*aspectFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.Aspect = ratio
+		}
+		if !validCropOutputModes[opts.OutputMode] || !validGravities[opts.Anchor] {
+			outputJSON(map[string]interface{}{"success": false, "error": "invalid output-mode or anchor"})
+			return
+		}
+		if opts.OutputMode == "custom" && opts.OutputDir == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "output required for custom output-mode"})
+			return
+		}
+		if opts.Aspect == 0 && opts.Box.isZero() {
+			outputJSON(map[string]interface{}{"success": false, "error": "crop box, pct or aspect required"})
+			return
+		}
+		batchCropStreaming(strings.Split(*filesFlag, ","), opts, *concurrencyFlag)
+	} else if *transformFlag != "" {
+		// Transform mode
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		bg, err := parseHexColor(*backgroundFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		result := transformImageFile(*inputFlag, *outputFlag, TransformOptions{
+			Op:         *transformFlag,
+			Angle:      *angleFlag,
+			Background: bg,
+			Lossless:   *losslessFlag,
+			Strip:      *stripFlag,
+			KeepICC:    *keepICCFlag,
+		})
+		outputJSON(result)
+	} else if *removeBgFlag {
+		// Remove background mode; without --output the PNG is returned as a data URL
+		if *inputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input required"})
+			return
+		}
+		result := removeBackgroundFile(*inputFlag, *outputFlag, RemoveBgOptions{
+			Tolerance: *toleranceFlag,
+			Softness:  *softnessFlag,
+		})
+		outputJSON(result)
+	} else if *annotateFlag != "" {
+		// Annotate mode
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		anns, err := parseAnnotations(*annotateFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		result := annotateImageFile(*inputFlag, *outputFlag, AnnotateOptions{
+			Annotations:  anns,
+			Scale:        *strokeScaleFlag,
+			KeepMetadata: *keepMetadataFlag,
+		})
+		outputJSON(result)
+	} else if *redactFlag != "" {
+		// Redact mode
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		if !validRedactModes[*redactModeFlag] {
+			outputJSON(map[string]interface{}{"success": false, "error": "invalid redact-mode"})
+			return
+		}
+		regions, err := parseRedactRegions(*redactFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		fill, err := parseHexColor(*redactColorFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		result := redactImageFile(*inputFlag, *outputFlag, RedactOptions{
+			Regions: regions,
+			Mode:    *redactModeFlag,
+			Fill:    fill,
+			Amount:  *redactAmountFlag,
+		})
+		outputJSON(result)
+	} else if *indexOpFlag != "" {
+		// Similar-image index mode
+		indexPath := *indexFlag
+		if indexPath == "" && *dirFlag != "" {
+			var err error
+			if indexPath, err = defaultIndexPath(*dirFlag); err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+		}
+		if indexPath == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "index or dir required"})
+			return
+		}
+		switch *indexOpFlag {
+		case "build":
+			if *dirFlag == "" {
+				outputJSON(map[string]interface{}{"success": false, "error": "dir required to build an index"})
+				return
+			}
+			root, err := filepath.Abs(*dirFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			files, err := listImageFiles(root, WalkOptions{
+				Recursive: *recursiveFlag,
+				Include:   splitGlobs(*includeFlag),
+				Exclude:   splitGlobs(*excludeFlag),
+				Exts:      parseExtList(*extsFlag),
+				Sort:      "name",
+			})
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			outputJSON(buildSimilarIndex(indexPath, root, files, *concurrencyFlag))
+		case "query":
+			if *inputFlag == "" {
+				outputJSON(map[string]interface{}{"success": false, "error": "input required"})
+				return
+			}
+			outputJSON(querySimilarIndex(indexPath, *inputFlag, *topFlag))
+		default:
+			outputJSON(map[string]interface{}{"success": false, "error": fmt.Sprintf("unknown index-op: %s", *indexOpFlag)})
+		}
+	} else if *dedupeFlag {
+		// Dedupe mode
+		if !validHashTypes[*hashTypeFlag] {
+			outputJSON(map[string]interface{}{"success": false, "error": fmt.Sprintf("unknown hash-type: %s", *hashTypeFlag)})
+			return
+		}
+		if *distanceFlag < 0 || *distanceFlag > 64 {
+			outputJSON(map[string]interface{}{"success": false, "error": "distance must be between 0 and 64"})
+			return
+		}
+		var files []string
+		if *dirFlag != "" {
+			var err error
+			files, err = listImageFiles(*dirFlag, WalkOptions{
+				Recursive: *recursiveFlag,
+				Include:   splitGlobs(*includeFlag),
+				Exclude:   splitGlobs(*excludeFlag),
+				Exts:      parseExtList(*extsFlag),
+				Sort:      "name",
+			})
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+		} else if *filesFlag != "" {
+			files = strings.Split(*filesFlag, ",")
+		} else {
+			outputJSON(map[string]interface{}{"success": false, "error": "files or dir required"})
+			return
+		}
+		outputJSON(dedupeFiles(files, *hashTypeFlag, *distanceFlag, *concurrencyFlag))
+	} else if *convertFlag {
+		// Convert mode
+		opts := ConvertOptions{
+			Quality:      *qualityFlag,
+			Format:       *formatFlag,
+			KeepMetadata: *keepMetadataFlag,
+		}
+		if opts.Format != "" {
+			if _, err := compressFormat("", opts.Format); err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+		}
+		if *flattenFlag != "" {
+			bg, err := parseHexColor(*flattenFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.Flatten = bg
+		}
+		if *filesFlag != "" && *outputFlag != "" {
+			if opts.Format == "" {
+				outputJSON(map[string]interface{}{"success": false, "error": "format required for batch convert"})
+				return
+			}
+			batchConvertStreaming(strings.Split(*filesFlag, ","), *outputFlag, opts, *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		outputJSON(convertImageFile(*inputFlag, *outputFlag, opts))
+	} else if *pipelineFlag != "" {
+		// Pipeline mode
+		p, err := parsePipeline(*pipelineFlag)
+		if err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		if *filesFlag != "" && *outputFlag != "" {
+			batchPipelineStreaming(strings.Split(*filesFlag, ","), *outputFlag, p, *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		outputJSON(runPipelineFile(*inputFlag, *outputFlag, p))
+	} else if *watermarkFlag {
+		// Watermark mode
+		if wm == nil {
+			outputJSON(map[string]interface{}{"success": false, "error": "wm-logo or wm-text required"})
+			return
+		}
+		if *filesFlag != "" && *outputFlag != "" {
+			batchWatermarkStreaming(strings.Split(*filesFlag, ","), *outputFlag, wm, *keepMetadataFlag, *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		outputJSON(watermarkImageFile(*inputFlag, *outputFlag, wm, *keepMetadataFlag))
+	} else if *infoFlag {
+		// Info mode
+		if *filesFlag != "" {
+			batchInfoStreaming(strings.Split(*filesFlag, ","), *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input or files required"})
+			return
+		}
+		outputJSON(imageInfo(*inputFlag))
+	} else if *resizeFlag {
+		// Resize mode
+		opts := ResizeOptions{
+			Width:         *widthFlag,
+			Height:        *heightFlag,
+			Percent:       *percentFlag,
+			MaxMegapixels: *maxMPFlag,
+			Fit:           *fitFlag,
+			Kernel:        *kernelFlag,
+			KeepMetadata:  *keepMetadataFlag,
+		}
+		if err := opts.validate(); err != nil {
+			outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+			return
+		}
+		if *filesFlag != "" && *outputFlag != "" {
+			batchResizeStreaming(strings.Split(*filesFlag, ","), *outputFlag, opts, *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		result := resizeImageFile(*inputFlag, *outputFlag, opts)
+		outputJSON(result)
+	} else if *cropFlag {
 		// Crop mode
 		if *inputFlag == "" || *outputFlag == "" {
 			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
 			return
 		}
-		result := cropImage(*inputFlag, *outputFlag, *cropXFlag, *cropYFlag, *cropWFlag, *cropHFlag)
+		box := cropBox{X: float64(*cropXFlag), Y: float64(*cropYFlag), W: float64(*cropWFlag), H: float64(*cropHFlag)}
+		if *cropPctFlag != "" {
+			pct, err := parsePercentBox(*cropPctFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			box = pct
+		}
+		result := cropImage(*inputFlag, *outputFlag, box, *keepMetadataFlag, wm)
 		outputJSON(result)
-	} else if *compressFlag {
-		// Compress mode
+	} else if *compressFlag || *filtersFlag != "" {
+		// Compress mode, optionally with a filter pipeline
+		opts := CompressOptions{
+			Quality:      *qualityFlag,
+			MinQuality:   *minQualityFlag,
+			Format:       *formatFlag,
+			Colors:       *colorsFlag,
+			Dither:       *ditherFlag,
+			KeepMetadata: *keepMetadataFlag,
+			Watermark:    wm,
+		}
+		if opts.Colors != 0 && (opts.Colors < 2 || opts.Colors > 256) {
+			outputJSON(map[string]interface{}{"success": false, "error": "colors must be between 2 and 256"})
+			return
+		}
+		if *flattenFlag != "" {
+			bg, err := parseHexColor(*flattenFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.Flatten = bg
+		}
+		if *targetSizeFlag != "" {
+			target, err := parseByteSize(*targetSizeFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.TargetSize = target
+		}
+		if *filtersFlag != "" {
+			steps, err := parseFilters(*filtersFlag)
+			if err != nil {
+				outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+				return
+			}
+			opts.Filters = steps
+		}
+		if *filesFlag != "" && *outputFlag != "" {
+			batchCompressStreaming(strings.Split(*filesFlag, ","), *outputFlag, opts, *concurrencyFlag)
+			return
+		}
 		if *inputFlag == "" || *outputFlag == "" {
 			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
 			return
 		}
-		result := compressImage(*inputFlag, *outputFlag, *qualityFlag)
+		result := compressImage(*inputFlag, *outputFlag, opts)
 		outputJSON(result)
 	} else if *prefetchFlag {
 		// Prefetch mode - streaming download to temp
@@ -157,19 +679,98 @@ func main() {
 			return
 		}
 		urls := strings.Split(*urlsFlag, ",")
-		prefetchImages(urls, *outputFlag, *concurrencyFlag)
+		var strip *StripOptions
+		if *stripFlag {
+			strip = &StripOptions{KeepOrientation: *keepOrientationFlag, KeepICC: *keepICCFlag}
+		}
+		prefetchImages(urls, *outputFlag, *concurrencyFlag, strip)
+	} else if *cacheOpFlag != "" {
+		// Thumbnail cache maintenance mode
+		result := thumbCacheOperation(*cacheDirFlag, *cacheOpFlag, int64(*cacheMaxMBFlag)*1024*1024)
+		outputJSON(result)
 	} else if *thumbnailFlag {
 		// Thumbnail generation mode
-		if *filesFlag == "" {
-			outputThumbnailError("files are required for thumbnail mode")
+		if *filesFlag == "" && *dirFlag == "" && !*serveFlag {
+			outputThumbnailError("files or dir are required for thumbnail mode")
+			return
+		}
+		bg, err := parseHexColor(*backgroundFlag)
+		if err != nil {
+			outputThumbnailError(err.Error())
+			return
+		}
+		opts := ThumbnailOptions{
+			Fit: FitOptions{
+				Mode:       *fitFlag,
+				Width:      *widthFlag,
+				Height:     *heightFlag,
+				Gravity:    *gravityFlag,
+				Background: bg,
+			},
+			Mode:     *thumbModeFlag,
+			Base64:   *base64Flag,
+			CacheDir: *cacheDirFlag,
+		}
+		if opts.Fit.Width <= 0 {
+			opts.Fit.Width = *sizeFlag
+		}
+		if opts.Fit.Height <= 0 {
+			opts.Fit.Height = *sizeFlag
+		}
+		if err := opts.Fit.validate(); err != nil {
+			outputThumbnailError(err.Error())
+			return
+		}
+		if opts.Mode != "quality" && opts.Mode != "fast" {
+			outputThumbnailError(fmt.Sprintf("unknown thumb-mode: %s", opts.Mode))
+			return
+		}
+		if opts.CacheDir != "" {
+			if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+				outputThumbnailError(err.Error())
+				return
+			}
+		}
+		priority, err := parseIndexRanges(*priorityFlag)
+		if err != nil {
+			outputThumbnailError(err.Error())
 			return
 		}
-		files := strings.Split(*filesFlag, ",")
-		if *streamFlag {
+		stream := StreamOptions{
+			Ordered:  *orderedFlag,
+			Window:   *windowFlag,
+			Priority: priority,
+			Serve:    *serveFlag,
+		}
+
+		var files []string
+		if *dirFlag != "" {
+			// Directory mode: walk and stream in listing order
+			if *sortFlag != "name" && *sortFlag != "mtime" && *sortFlag != "natural" {
+				outputThumbnailError(fmt.Sprintf("unknown sort: %s", *sortFlag))
+				return
+			}
+			files, err = listImageFiles(*dirFlag, WalkOptions{
+				Recursive: *recursiveFlag,
+				Include:   splitGlobs(*includeFlag),
+				Exclude:   splitGlobs(*excludeFlag),
+				Exts:      parseExtList(*extsFlag),
+				Sort:      *sortFlag,
+				Reverse:   *reverseFlag,
+			})
+			if err != nil {
+				outputThumbnailError(err.Error())
+				return
+			}
+			stream.Ordered = true
+		} else if *filesFlag != "" {
+			files = strings.Split(*filesFlag, ",")
+		}
+		if *streamFlag || *serveFlag {
 			// Streaming mode: output each item immediately as it completes
-			batchThumbnailsStreaming(files, *outputFlag, *sizeFlag, *concurrencyFlag, *base64Flag)
+			batchThumbnailsStreaming(files, *outputFlag, opts, *concurrencyFlag, stream)
 		} else {
-			result := batchThumbnails(files, *outputFlag, *sizeFlag, *concurrencyFlag, *base64Flag)
+			result := batchThumbnails(files, *outputFlag, opts, *concurrencyFlag)
 			json.NewEncoder(os.Stdout).Encode(result)
 		}
 	} else if *downloadFlag {
@@ -179,8 +780,24 @@ func main() {
 			return
 		}
 		urls := strings.Split(*urlsFlag, ",")
-		result := batchDownload(urls, *outputFlag, *concurrencyFlag)
+		var strip *StripOptions
+		if *stripFlag {
+			strip = &StripOptions{KeepOrientation: *keepOrientationFlag, KeepICC: *keepICCFlag}
+		}
+		result := batchDownload(urls, *outputFlag, *concurrencyFlag, strip, wm)
 		json.NewEncoder(os.Stdout).Encode(result)
+	} else if *stripFlag {
+		// Strip mode
+		opts := StripOptions{KeepOrientation: *keepOrientationFlag, KeepICC: *keepICCFlag}
+		if *filesFlag != "" && *outputFlag != "" {
+			batchStripStreaming(strings.Split(*filesFlag, ","), *outputFlag, opts, *concurrencyFlag)
+			return
+		}
+		if *inputFlag == "" || *outputFlag == "" {
+			outputJSON(map[string]interface{}{"success": false, "error": "input and output required"})
+			return
+		}
+		outputJSON(stripImageFile(*inputFlag, *outputFlag, opts))
 	} else if *urlFlag != "" {
 		// Scrape mode
 		images, err := scrapeImages(*urlFlag)
@@ -188,6 +805,9 @@ func main() {
 			outputScrapeError(err.Error())
 			return
 		}
+		if *contentDedupeFlag {
+			images = dedupeImagesByContent(images, *concurrencyFlag)
+		}
 		outputScrapeSuccess(images)
 	} else {
 		outputScrapeError("url, download, or thumbnail mode required")
@@ -216,50 +836,71 @@ func outputThumbnailError(msg string) {
 
 // ============ THUMBNAIL MODE ============
 
-// Streaming version: output each item immediately as NDJSON
-func batchThumbnailsStreaming(files []string, outputDir string, maxSize int, concurrency int, outputBase64 bool) {
+// Streaming version: output each item immediately as NDJSON.
+// Items are dispatched from a queue (priority ranges first); with Ordered set
+// they are emitted by input index, and dispatch never runs more than Window
+// indices past the next item to emit.
+func batchThumbnailsStreaming(files []string, outputDir string, opts ThumbnailOptions, concurrency int, stream StreamOptions) {
 	startTime := time.Now()
 	encoder := json.NewEncoder(os.Stdout)
 
 	// Create output dir if not base64 mode
-	if !outputBase64 && outputDir != "" {
+	if !opts.Base64 && outputDir != "" {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
 			encoder.Encode(ThumbnailItem{Source: "", Error: err.Error()})
 			return
 		}
 	}
 
-	sem := make(chan struct{}, concurrency)
-	results := make(chan ThumbnailItem, len(files))
-	var wg sync.WaitGroup
+	queue := newThumbQueue(stream.Priority)
+	queue.add(files)
 
-	for _, file := range files {
-		file = strings.TrimSpace(file)
-		if file == "" {
-			continue
+	// The limit bounds the reorder buffer; it moves forward as items are emitted
+	if stream.Ordered {
+		if stream.Window <= 0 {
+			stream.Window = 4 * concurrency
 		}
+		queue.setLimit(stream.Window)
+	}
 
-		wg.Add(1)
-		go func(filePath string) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			item := generateThumbnail(filePath, outputDir, maxSize, outputBase64)
-			results <- item
-		}(file)
+	results := make(chan ThumbnailItem)
+	controlErrors := make(chan string, 16)
+	if stream.Serve {
+		go readThumbControl(os.Stdin, queue, func(msg string) { controlErrors <- msg })
+	} else {
+		queue.close()
 	}
 
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
 	go func() {
+		for {
+			sem <- struct{}{}
+
+			// Pick after acquiring a slot so priority changes apply to the next dispatch
+			idx, filePath, ok := queue.pop()
+			if !ok {
+				break
+			}
+
+			wg.Add(1)
+			go func(idx int, filePath string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				item := generateThumbnail(filePath, outputDir, opts)
+				item.Index = idx
+				results <- item
+			}(idx, filePath)
+		}
 		wg.Wait()
 		close(results)
 	}()
 
 	completed := 0
 	failed := 0
-
-	// Stream each result immediately as it arrives
-	for item := range results {
+	emit := func(item ThumbnailItem) {
 		encoder.Encode(item) // Output one JSON line per item
 		if item.Success {
 			completed++
@@ -268,22 +909,52 @@ func batchThumbnailsStreaming(files []string, outputDir string, maxSize int, con
 		}
 	}
 
+	// Stream each result immediately as it arrives
+	pending := make(map[int]ThumbnailItem)
+	next := 0
+	for done := false; !done; {
+		select {
+		case msg := <-controlErrors:
+			encoder.Encode(map[string]interface{}{"type": "error", "error": msg})
+		case item, ok := <-results:
+			if !ok {
+				done = true
+				break
+			}
+			if !stream.Ordered {
+				emit(item)
+				continue
+			}
+			pending[item.Index] = item
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				emit(ready)
+				next++
+			}
+			queue.setLimit(next + stream.Window)
+		}
+	}
+
 	// Final summary line (type: "summary")
 	duration := time.Since(startTime).Milliseconds()
 	encoder.Encode(map[string]interface{}{
 		"type":        "summary",
-		"total":       len(files),
+		"total":       queue.total(),
 		"completed":   completed,
 		"failed":      failed,
 		"duration_ms": duration,
 	})
 }
 
-func batchThumbnails(files []string, outputDir string, maxSize int, concurrency int, outputBase64 bool) ThumbnailResult {
+func batchThumbnails(files []string, outputDir string, opts ThumbnailOptions, concurrency int) ThumbnailResult {
 	startTime := time.Now()
 
 	// Create output dir if not base64 mode
-	if !outputBase64 && outputDir != "" {
+	if !opts.Base64 && outputDir != "" {
 		if err := os.MkdirAll(outputDir, 0755); err != nil {
 			return ThumbnailResult{Success: false, Error: err.Error()}
 		}
@@ -293,21 +964,17 @@ func batchThumbnails(files []string, outputDir string, maxSize int, concurrency
 	results := make(chan ThumbnailItem, len(files))
 	var wg sync.WaitGroup
 
-	for _, file := range files {
-		file = strings.TrimSpace(file)
-		if file == "" {
-			continue
-		}
-
+	for i, file := range cleanFileList(files) {
 		wg.Add(1)
-		go func(filePath string) {
+		go func(idx int, filePath string) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			item := generateThumbnail(filePath, outputDir, maxSize, outputBase64)
+			item := generateThumbnail(filePath, outputDir, opts)
+			item.Index = idx
 			results <- item
-		}(file)
+		}(i, file)
 	}
 
 	go func() {
@@ -328,6 +995,9 @@ func batchThumbnails(files []string, outputDir string, maxSize int, concurrency
 		}
 	}
 
+	// Report in input order
+	sort.Slice(items, func(i, j int) bool { return items[i].Index < items[j].Index })
+
 	duration := time.Since(startTime).Milliseconds()
 
 	return ThumbnailResult{
@@ -340,76 +1010,40 @@ func batchThumbnails(files []string, outputDir string, maxSize int, concurrency
 	}
 }
 
-func generateThumbnail(source string, outputDir string, maxSize int, outputBase64 bool) ThumbnailItem {
+func generateThumbnail(source string, outputDir string, opts ThumbnailOptions) ThumbnailItem {
 	item := ThumbnailItem{Source: source}
-
-	// Open image file
-	var reader io.ReadCloser
-	var err error
-
-	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
-		// Download from URL
-		resp, err := sharedClient.Get(source)
-		if err != nil {
-			item.Error = err.Error()
-			return item
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			item.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
-			return item
-		}
-		reader = resp.Body
-	} else {
-		// Local file
-		f, err := os.Open(source)
-		if err != nil {
-			item.Error = err.Error()
-			return item
+	isURL := strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+
+	// Serve from the thumbnail cache when the source is unchanged (local files only)
+	var cache *thumbCacheLookup
+	if opts.CacheDir != "" && !isURL {
+		if lookup, err := resolveThumbCache(opts.CacheDir, source, opts); err == nil {
+			if meta, ok := loadThumbCache(lookup); ok {
+				item.Cached = true
+				return serveCachedThumbnail(item, lookup.path, meta, outputDir, opts)
+			}
+			cache = lookup
 		}
-		defer f.Close()
-		reader = f
 	}
 
-	// Decode image
-	img, format, err := image.Decode(reader)
+	thumb, format, err := renderThumbnail(source, opts)
 	if err != nil {
-		item.Error = fmt.Sprintf("decode: %v", err)
+		item.Error = err.Error()
 		return item
 	}
 
-	// Calculate thumbnail dimensions
-	bounds := img.Bounds()
-	origW := bounds.Dx()
-	origH := bounds.Dy()
-
-	var newW, newH int
-	if origW > origH {
-		if origW > maxSize {
-			newW = maxSize
-			newH = int(float64(origH) * float64(maxSize) / float64(origW))
-		} else {
-			newW = origW
-			newH = origH
-		}
-	} else {
-		if origH > maxSize {
-			newH = maxSize
-			newW = int(float64(origW) * float64(maxSize) / float64(origH))
-		} else {
-			newW = origW
-			newH = origH
+	// A cache that cannot be written (full disk, read-only dir) only costs
+	// the next run a re-render; the thumbnail is still delivered below
+	if cache != nil {
+		if meta, err := storeThumbCache(cache, thumb); err == nil {
+			return serveCachedThumbnail(item, cache.path, meta, outputDir, opts)
 		}
 	}
 
-	// Create thumbnail using high-quality CatmullRom scaling
-	thumb := image.NewRGBA(image.Rect(0, 0, newW, newH))
-	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
-
-	item.Width = newW
-	item.Height = newH
+	item.Width = thumb.Bounds().Dx()
+	item.Height = thumb.Bounds().Dy()
 
-	if outputBase64 {
+	if opts.Base64 {
 		// Encode to base64
 		var buf strings.Builder
 		buf.WriteString("data:image/jpeg;base64,")
@@ -431,16 +1065,7 @@ func generateThumbnail(source string, outputDir string, maxSize int, outputBase6
 		item.Success = true
 	} else {
 		// Save to file
-		filename := filepath.Base(source)
-		// Change extension to .jpg
-		ext := filepath.Ext(filename)
-		if ext != "" {
-			filename = filename[:len(filename)-len(ext)] + ".jpg"
-		} else {
-			filename = filename + ".jpg"
-		}
-
-		outputPath := filepath.Join(outputDir, "thumb_"+filename)
+		outputPath := filepath.Join(outputDir, thumbnailFilename(source))
 
 		f, err := os.Create(outputPath)
 		if err != nil {
@@ -468,9 +1093,80 @@ func generateThumbnail(source string, outputDir string, maxSize int, outputBase6
 	return item
 }
 
+// cleanFileList trims entries and drops empty ones from a --files list
+func cleanFileList(files []string) []string {
+	var cleaned []string
+	for _, file := range files {
+		if file = strings.TrimSpace(file); file != "" {
+			cleaned = append(cleaned, file)
+		}
+	}
+	return cleaned
+}
+
+// renderThumbnail loads source (file or URL) and fits it into the thumbnail box
+func renderThumbnail(source string, opts ThumbnailOptions) (*image.RGBA, string, error) {
+	// Open image file
+	var reader io.ReadCloser
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		// Download from URL
+		resp, err := sharedClient.Get(source)
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, "", fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		reader = resp.Body
+	} else {
+		// Local file
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	// Decode image
+	var img image.Image
+	var format string
+	var err error
+	if opts.Mode == "fast" {
+		data, readErr := io.ReadAll(reader)
+		if readErr != nil {
+			return nil, "", readErr
+		}
+		img, format, err = decodeFast(data, opts.Fit)
+	} else {
+		img, format, err = image.Decode(reader)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("decode: %v", err)
+	}
+
+	// Create thumbnail using high-quality CatmullRom scaling
+	return fitImage(img, opts.Fit), format, nil
+}
+
+// thumbnailFilename returns the thumb_<name>.jpg output name for a source
+func thumbnailFilename(source string) string {
+	filename := filepath.Base(source)
+	// Change extension to .jpg
+	ext := filepath.Ext(filename)
+	if ext != "" {
+		filename = filename[:len(filename)-len(ext)] + ".jpg"
+	} else {
+		filename = filename + ".jpg"
+	}
+	return "thumb_" + filename
+}
+
 // ============ DOWNLOAD MODE ============
 
-func batchDownload(urls []string, outputDir string, concurrency int) DownloadResult {
+func batchDownload(urls []string, outputDir string, concurrency int, strip *StripOptions, wm *watermark) DownloadResult {
 	startTime := time.Now()
 
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
@@ -494,8 +1190,12 @@ func batchDownload(urls []string, outputDir string, concurrency int) DownloadRes
 			defer func() { <-sem }()
 
 			filename := generateFilename(imageURL, idx)
+			if wm != nil {
+				// Watermarked files are re-encoded, so keep to formats we can write
+				filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + encodableExt(filename)
+			}
 			outputPath := filepath.Join(outputDir, filename)
-			size, err := downloadFile(imageURL, outputPath)
+			size, err := downloadFile(imageURL, outputPath, strip, wm)
 
 			item := DownloadItem{
 				URL:      imageURL,
@@ -544,7 +1244,11 @@ func batchDownload(urls []string, outputDir string, concurrency int) DownloadRes
 	}
 }
 
-func downloadFile(imageURL, outputPath string) (int64, error) {
+// downloadFile saves imageURL to outputPath; with strip set, metadata is
+// scrubbed before anything touches the disk. With wm set the image is
+// stamped and re-encoded, which drops its metadata except what strip keeps;
+// animated images are refused rather than flattened to one frame.
+func downloadFile(imageURL, outputPath string, strip *StripOptions, wm *watermark) (int64, error) {
 	req, err := http.NewRequest("GET", imageURL, nil)
 	if err != nil {
 		return 0, err
@@ -569,6 +1273,58 @@ func downloadFile(imageURL, outputPath string) (int64, error) {
 		return 0, fmt.Errorf("not an image: %s", contentType)
 	}
 
+	if wm != nil {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return 0, fmt.Errorf("decode: %v", err)
+		}
+		if inspectContainer(format, data).Frames > 1 {
+			return 0, fmt.Errorf("cannot watermark an animated %s", format)
+		}
+		stamped, orientation, err := wm.applyOriented(img, data)
+		if err != nil {
+			return 0, err
+		}
+		out, err := encodeImageFile(outputPath, stamped)
+		if err != nil {
+			return 0, err
+		}
+		if strip != nil {
+			// Carry what the scrub keeps (ICC, orientation)
+			source, _, err := stripMetadata(data, *strip)
+			if err != nil {
+				return 0, err
+			}
+			bounds := stamped.Bounds()
+			if out, err = carryMetadata(out, source, bounds.Dx(), bounds.Dy(), orientation); err != nil {
+				return 0, err
+			}
+		}
+		if err := writeFileAtomic(outputPath, out); err != nil {
+			return 0, err
+		}
+		return int64(len(out)), nil
+	}
+
+	if strip != nil {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		data, _, err = stripMetadata(data, *strip)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeFileAtomic(outputPath, data); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+
 	out, err := os.Create(outputPath)
 	if err != nil {
 		return 0, err
@@ -594,7 +1350,7 @@ func generateFilename(imageURL string, index int) string {
 	filename := filepath.Base(urlPath)
 
 	ext := strings.ToLower(filepath.Ext(filename))
-	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true, ".tif": true, ".tiff": true, ".ico": true}
 
 	if !validExts[ext] {
 		filename = fmt.Sprintf("image_%d.jpg", index)
@@ -650,8 +1406,7 @@ func scrapeImages(targetURL string) ([]string, error) {
 }
 
 func extractImages(html string, baseURL *url.URL) []string {
-	imageSet := make(map[string]bool)
-	var mu sync.Mutex
+	images := newImageList()
 
 	patterns := []string{
 		`<img[^>]+src=["']([^"']+)["']`,
@@ -674,25 +1429,20 @@ func extractImages(html string, baseURL *url.URL) []string {
 						part = strings.TrimSpace(part)
 						fields := strings.Fields(part)
 						if len(fields) > 0 {
-							addImage(imageSet, &mu, fields[0], baseURL)
+							addImage(images, fields[0], baseURL)
 						}
 					}
 				} else {
-					addImage(imageSet, &mu, match[1], baseURL)
+					addImage(images, match[1], baseURL)
 				}
 			}
 		}
 	}
 
-	var images []string
-	for img := range imageSet {
-		images = append(images, img)
-	}
-
-	return images
+	return images.urls
 }
 
-func addImage(imageSet map[string]bool, mu *sync.Mutex, imgURL string, baseURL *url.URL) {
+func addImage(images *imageList, imgURL string, baseURL *url.URL) {
 	imgURL = strings.TrimSpace(imgURL)
 
 	if imgURL == "" {
@@ -719,9 +1469,7 @@ func addImage(imageSet map[string]bool, mu *sync.Mutex, imgURL string, baseURL *
 		return
 	}
 
-	mu.Lock()
-	imageSet[imgURL] = true
-	mu.Unlock()
+	images.add(imgURL)
 }
 
 // ============ HELPERS ============
@@ -732,82 +1480,115 @@ func outputJSON(data interface{}) {
 
 // ============ CROP MODE ============
 
-func cropImage(inputPath, outputPath string, x, y, w, h int) map[string]interface{} {
+// cropImage crops a local file, http(s) URL or .repic file. The box is in
+// pixels or percent; an empty box on a .repic input uses its stored crop.
+func cropImage(inputPath, outputPath string, box cropBox, keepMetadata bool, wm *watermark) map[string]interface{} {
 	result := make(map[string]interface{})
 
-	f, err := os.Open(inputPath)
+	img, format, data, box, err := loadCropSource(inputPath, box)
 	if err != nil {
 		result["success"] = false
 		result["error"] = err.Error()
 		return result
 	}
-	defer f.Close()
 
-	img, format, err := image.Decode(f)
+	// Validate crop bounds
+	r, err := box.rect(img.Bounds())
 	if err != nil {
 		result["success"] = false
-		result["error"] = fmt.Sprintf("decode: %v", err)
+		result["error"] = err.Error()
 		return result
 	}
 
-	bounds := img.Bounds()
-	origW := bounds.Dx()
-	origH := bounds.Dy()
+	cropped := cropToRect(img, r)
+	orientation := 0
+	if wm != nil {
+		if cropped, orientation, err = wm.applyOriented(cropped, data); err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+	}
 
-	// Validate crop bounds
-	if w <= 0 || h <= 0 || x < 0 || y < 0 || x+w > origW || y+h > origH {
+	var source []byte
+	if keepMetadata {
+		source = data
+	}
+	if err := writeImageFileMeta(outputPath, cropped, source, orientation); err != nil {
 		result["success"] = false
-		result["error"] = fmt.Sprintf("invalid crop bounds: x=%d y=%d w=%d h=%d (image: %dx%d)", x, y, w, h, origW, origH)
+		result["error"] = err.Error()
 		return result
 	}
 
-	// Create cropped image using SubImage (zero-copy if possible)
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = cropped.Bounds().Dx()
+	result["height"] = cropped.Bounds().Dy()
+	result["format"] = format
+	return result
+}
+
+// cropToRect returns the r region of img using SubImage (zero-copy if possible)
+func cropToRect(img image.Image, r image.Rectangle) image.Image {
 	type subImager interface {
 		SubImage(r image.Rectangle) image.Image
 	}
 
-	var cropped image.Image
 	if si, ok := img.(subImager); ok {
-		cropped = si.SubImage(image.Rect(x, y, x+w, y+h))
-	} else {
-		// Fallback: copy pixels
-		dst := image.NewRGBA(image.Rect(0, 0, w, h))
-		draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
-		cropped = dst
+		return si.SubImage(r)
 	}
 
-	// Create output file
-	out, err := os.Create(outputPath)
+	// Fallback: copy pixels
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// writeImageFile encodes img based on the output extension and writes it
+// atomically, so overwriting the source never leaves a truncated file
+func writeImageFile(outputPath string, img image.Image) error {
+	return writeImageFileMeta(outputPath, img, nil, 0)
+}
+
+// writeImageFileMeta is writeImageFile that also carries the metadata of the
+// source image bytes (when non-nil) into the output; see carryMetadata
+func writeImageFileMeta(outputPath string, img image.Image, source []byte, orientation int) error {
+	data, err := encodeImageFile(outputPath, img)
 	if err != nil {
-		result["success"] = false
-		result["error"] = err.Error()
-		return result
+		return err
 	}
-	defer out.Close()
 
-	// Encode based on output extension or original format
+	if source != nil {
+		bounds := img.Bounds()
+		if data, err = carryMetadata(data, source, bounds.Dx(), bounds.Dy(), orientation); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(outputPath, data)
+}
+
+// encodeImageFile encodes img in the format implied by the output extension
+// (PNG, GIF, BMP, TIFF, ICO, lossless WebP, otherwise JPEG at quality 95)
+func encodeImageFile(outputPath string, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
 	ext := strings.ToLower(filepath.Ext(outputPath))
 	switch ext {
 	case ".png":
-		err = png.Encode(out, cropped)
+		err = png.Encode(&buf, img)
 	case ".gif":
-		err = gif.Encode(out, cropped, nil)
+		err = gif.Encode(&buf, img, nil)
+	case ".bmp", ".tif", ".tiff", ".ico", ".webp":
+		format, _ := compressFormat(outputPath, "")
+		_, err = encodeExtraFormat(&buf, img, format)
 	default:
-		err = jpeg.Encode(out, cropped, &jpeg.Options{Quality: 95})
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
 	}
-
 	if err != nil {
-		result["success"] = false
-		result["error"] = fmt.Sprintf("encode: %v", err)
-		return result
+		return nil, fmt.Errorf("encode: %v", err)
 	}
-
-	result["success"] = true
-	result["output"] = outputPath
-	result["width"] = w
-	result["height"] = h
-	result["format"] = format
-	return result
+	return buf.Bytes(), nil
 }
 
 // ============ COMPRESS MODE ============
@@ -824,8 +1605,9 @@ type PrefetchItem struct {
 	Cached    bool   `json:"cached,omitempty"` // true if file already existed
 }
 
-// prefetchImages downloads images to temp dir, streaming results as NDJSON
-func prefetchImages(urls []string, tempDir string, concurrency int) {
+// prefetchImages downloads images to temp dir, streaming results as NDJSON.
+// With strip set, metadata is scrubbed before anything touches the disk.
+func prefetchImages(urls []string, tempDir string, concurrency int, strip *StripOptions) {
 	encoder := json.NewEncoder(os.Stdout)
 
 	// Create temp dir if needed
@@ -850,7 +1632,7 @@ func prefetchImages(urls []string, tempDir string, concurrency int) {
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			item := prefetchSingleImage(imageURL, tempDir)
+			item := prefetchSingleImage(imageURL, tempDir, strip)
 			results <- item
 		}(rawURL)
 	}
@@ -881,11 +1663,15 @@ func prefetchImages(urls []string, tempDir string, concurrency int) {
 }
 
 // prefetchSingleImage downloads one image to temp dir
-func prefetchSingleImage(imageURL, tempDir string) PrefetchItem {
+func prefetchSingleImage(imageURL, tempDir string, strip *StripOptions) PrefetchItem {
 	item := PrefetchItem{URL: imageURL}
 
-	// Generate filename from URL hash (deterministic)
+	// Generate filename from URL hash (deterministic); scrubbed copies are
+	// cached apart from untouched ones
 	hash := hashURL(imageURL)
+	if strip != nil {
+		hash += "_stripped"
+	}
 	ext := getExtFromURL(imageURL)
 	filename := hash + ext
 	localPath := filepath.Join(tempDir, filename)
@@ -921,6 +1707,24 @@ func prefetchSingleImage(imageURL, tempDir string) PrefetchItem {
 		return item
 	}
 
+	if strip != nil {
+		data, err := io.ReadAll(resp.Body)
+		if err == nil {
+			data, _, err = stripMetadata(data, *strip)
+		}
+		if err == nil {
+			err = writeFileAtomic(localPath, data)
+		}
+		if err != nil {
+			item.Error = err.Error()
+			return item
+		}
+		item.Success = true
+		item.LocalPath = localPath
+		item.Size = int64(len(data))
+		return item
+	}
+
 	// Write to temp file
 	out, err := os.Create(localPath)
 	if err != nil {
@@ -959,66 +1763,151 @@ func getExtFromURL(u string) string {
 		return ".jpg"
 	}
 	ext := strings.ToLower(filepath.Ext(parsed.Path))
-	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+	validExts := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true, ".tif": true, ".tiff": true, ".ico": true}
 	if validExts[ext] {
 		return ext
 	}
 	return ".jpg"
 }
 
-func compressImage(inputPath, outputPath string, quality int) map[string]interface{} {
+func compressImage(inputPath, outputPath string, opts CompressOptions) map[string]interface{} {
 	result := make(map[string]interface{})
 
-	f, err := os.Open(inputPath)
+	source, err := os.ReadFile(inputPath)
 	if err != nil {
 		result["success"] = false
 		result["error"] = err.Error()
 		return result
 	}
-	defer f.Close()
 
-	img, format, err := image.Decode(f)
+	img, format, err := image.Decode(bytes.NewReader(source))
 	if err != nil {
 		result["success"] = false
 		result["error"] = fmt.Sprintf("decode: %v", err)
 		return result
 	}
+	return compressDecoded(img, format, source, 0, outputPath, opts)
+}
+
+// compressDecoded is compressImage for an already decoded image. source is
+// the original file (for metadata) and orientation is written to carried
+// EXIF when > 0.
+func compressDecoded(img image.Image, format string, source []byte, orientation int, outputPath string, opts CompressOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+	var err error
+
+	if len(opts.Filters) > 0 {
+		img = applyFilters(img, opts.Filters)
+		result["filters"] = len(opts.Filters)
+	}
+
+	if opts.Watermark != nil {
+		if img, orientation, err = opts.Watermark.applyOriented(img, source); err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+	}
 
 	// Clamp quality
+	quality := opts.Quality
 	if quality < 1 {
 		quality = 1
 	} else if quality > 100 {
 		quality = 100
 	}
 
-	// Create output file
-	out, err := os.Create(outputPath)
+	outFormat, err := compressFormat(outputPath, opts.Format)
 	if err != nil {
 		result["success"] = false
 		result["error"] = err.Error()
 		return result
 	}
-	defer out.Close()
-
-	// Always output JPEG for compression
-	err = jpeg.Encode(out, img, &jpeg.Options{Quality: quality})
-	if err != nil {
+	if outFormat != "jpeg" && opts.TargetSize > 0 {
 		result["success"] = false
-		result["error"] = fmt.Sprintf("encode: %v", err)
+		result["error"] = "target-size requires jpeg output"
 		return result
 	}
 
-	// Get file size
-	info, _ := os.Stat(outputPath)
-	var size int64
-	if info != nil {
-		size = info.Size()
+	// JPEG has no alpha: flatten only when asked to
+	if outFormat == "jpeg" && hasAlpha(img) {
+		if opts.Flatten == nil {
+			result["success"] = false
+			result["error"] = "image has transparency; use a png output or --flatten to write jpeg"
+			return result
+		}
+		img = flattenImage(img, opts.Flatten)
+		result["flattened"] = true
+	}
+
+	if outFormat == "ico" {
+		img = icoFit(img)
+	}
+
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	var data []byte
+	switch {
+	case outFormat != "jpeg":
+		data, err = encodeCompressed(img, outFormat, opts)
+		if err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+		if opts.Colors > 0 {
+			result["colors"] = opts.Colors
+		}
+	case opts.TargetSize > 0:
+		// Leave room for the metadata added afterwards
+		target := opts.TargetSize
+		if opts.KeepMetadata {
+			target -= metadataSize(source)
+		}
+		enc, err := compressToTarget(img, opts.MinQuality, quality, target)
+		if err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+		data, quality, width, height = enc.Data, enc.Quality, enc.Width, enc.Height
+		result["attempts"] = enc.Attempts
+		result["target_size"] = opts.TargetSize
+	default:
+		data, err = encodeJPEGBytes(img, quality)
+		if err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+	}
+
+	if opts.KeepMetadata {
+		if data, err = carryMetadata(data, source, width, height, orientation); err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+	}
+
+	if err := writeFileAtomic(outputPath, data); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
 	}
 
 	result["success"] = true
 	result["output"] = outputPath
-	result["quality"] = quality
-	result["size"] = size
+	switch outFormat {
+	case "jpeg":
+		result["quality"] = quality
+	case "webp":
+		// Our WebP encoder is lossless; --quality has no effect on it
+		result["lossless"] = true
+	}
+	result["size"] = int64(len(data))
+	result["width"] = width
+	result["height"] = height
 	result["format"] = format
+	result["output_format"] = outFormat
 	return result
 }
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// ============ IMAGE METADATA ============
+
+// imageMetadata holds the metadata blocks found in an image container
+type imageMetadata struct {
+	Exif    []byte // TIFF payload without the Exif\0\0 header
+	ICC     []byte // raw ICC profile
+	ICCName string // PNG iCCP keyword
+	XMP     []byte
+}
+
+var (
+	iccHeader = []byte("ICC_PROFILE\x00")
+	xmpHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+const (
+	markerAPP2 = 0xE2
+	markerCOM  = 0xFE
+)
+
+// jpegMetadata collects Exif, ICC (reassembled from APP2 chunks) and XMP
+func jpegMetadata(segments []jpegSegment) imageMetadata {
+	meta := imageMetadata{Exif: findExifSegment(segments)}
+
+	type iccChunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []iccChunk
+
+	for _, seg := range segments {
+		switch {
+		case seg.Marker == markerAPP1 && bytes.HasPrefix(seg.Data, xmpHeader) && meta.XMP == nil:
+			meta.XMP = seg.Data[len(xmpHeader):]
+		case seg.Marker == markerAPP2 && bytes.HasPrefix(seg.Data, iccHeader) && len(seg.Data) > len(iccHeader)+2:
+			// ICC_PROFILE\0, sequence number, chunk count, data
+			chunks = append(chunks, iccChunk{seq: seg.Data[len(iccHeader)], data: seg.Data[len(iccHeader)+2:]})
+		}
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	for _, c := range chunks {
+		meta.ICC = append(meta.ICC, c.data...)
+	}
+	return meta
+}
+
+// ============ PNG CHUNKS ============
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunk is one PNG chunk without its length and CRC
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// readPNGChunks splits a PNG into its chunks
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("not a PNG")
+	}
+
+	var chunks []pngChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			return nil, fmt.Errorf("truncated chunk at offset %d", pos)
+		}
+		chunk := pngChunk{Type: string(data[pos+4 : pos+8]), Data: data[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos += 12 + length
+		if chunk.Type == "IEND" {
+			return chunks, nil
+		}
+	}
+	return nil, fmt.Errorf("missing IEND")
+}
+
+// writePNGChunks reassembles a PNG, recomputing each CRC
+func writePNGChunks(chunks []pngChunk) []byte {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	for _, c := range chunks {
+		binary.Write(&buf, binary.BigEndian, uint32(len(c.Data)))
+		crc := crc32.NewIEEE()
+		crc.Write([]byte(c.Type))
+		crc.Write(c.Data)
+		buf.WriteString(c.Type)
+		buf.Write(c.Data)
+		binary.Write(&buf, binary.BigEndian, crc.Sum32())
+	}
+	return buf.Bytes()
+}
+
+// pngMetadata collects eXIf, iCCP and the XMP iTXt chunk
+func pngMetadata(chunks []pngChunk) imageMetadata {
+	var meta imageMetadata
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			meta.Exif = c.Data
+		case "iCCP":
+			// name\0, compression method, zlib data
+			name, rest, ok := bytes.Cut(c.Data, []byte{0})
+			if !ok || len(rest) < 1 {
+				continue
+			}
+			meta.ICCName = string(name)
+			if r, err := zlib.NewReader(bytes.NewReader(rest[1:])); err == nil {
+				meta.ICC, _ = io.ReadAll(r)
+				r.Close()
+			}
+		case "iTXt":
+			// keyword\0, compression flag, method, language\0, translated\0, text
+			if !bytes.HasPrefix(c.Data, []byte("XML:com.adobe.xmp\x00")) {
+				continue
+			}
+			rest := c.Data[len("XML:com.adobe.xmp\x00"):]
+			if len(rest) < 2 {
+				continue
+			}
+			parts := bytes.SplitN(rest[2:], []byte{0}, 3)
+			if len(parts) != 3 {
+				continue
+			}
+			if rest[0] == 0 {
+				meta.XMP = parts[2]
+			} else if r, err := zlib.NewReader(bytes.NewReader(parts[2])); err == nil {
+				meta.XMP, _ = io.ReadAll(r)
+				r.Close()
+			}
+		}
+	}
+	return meta
+}
+
+// ============ WEBP CHUNKS ============
+
+// webpChunk is one RIFF chunk inside a WebP file
+type webpChunk struct {
+	FourCC string
+	Data   []byte
+}
+
+// readWebPChunks splits a WebP RIFF container into its chunks
+func readWebPChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP")
+	}
+
+	var chunks []webpChunk
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if size < 0 || pos+8+size > len(data) {
+			return nil, fmt.Errorf("truncated chunk at offset %d", pos)
+		}
+		chunks = append(chunks, webpChunk{FourCC: string(data[pos : pos+4]), Data: data[pos+8 : pos+8+size]})
+		pos += 8 + size + size&1
+	}
+	return chunks, nil
+}
+
+// writeWebPChunks assembles chunks into a WebP RIFF container
+func writeWebPChunks(chunks []webpChunk) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.WriteString(c.FourCC)
+		binary.Write(&body, binary.LittleEndian, uint32(len(c.Data)))
+		body.Write(c.Data)
+		if len(c.Data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// webpMetadata collects the EXIF, ICCP and XMP chunks
+func webpMetadata(chunks []webpChunk) imageMetadata {
+	var meta imageMetadata
+	for _, c := range chunks {
+		switch c.FourCC {
+		case "EXIF":
+			meta.Exif = bytes.TrimPrefix(c.Data, exifHeader)
+		case "ICCP":
+			meta.ICC = c.Data
+		case "XMP ":
+			meta.XMP = c.Data
+		}
+	}
+	return meta
+}
+
+// ============ ICC PROFILES ============
+
+// iccDescription returns the profile description ('desc' tag), handling
+// both v2 textDescriptionType and v4 multiLocalizedUnicodeType
+func iccDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		p := 132 + i*12
+		if p+12 > len(profile) {
+			return ""
+		}
+		if string(profile[p:p+4]) != "desc" {
+			continue
+		}
+
+		off := int(binary.BigEndian.Uint32(profile[p+4:]))
+		size := int(binary.BigEndian.Uint32(profile[p+8:]))
+		if off < 0 || size < 12 || off+size > len(profile) {
+			return ""
+		}
+		d := profile[off : off+size]
+
+		switch string(d[:4]) {
+		case "desc":
+			n := int(binary.BigEndian.Uint32(d[8:]))
+			if 12+n > len(d) {
+				return ""
+			}
+			return strings.TrimRight(string(d[12:12+n]), "\x00 ")
+		case "mluc":
+			// First record: language, country, length, offset of UTF-16BE text
+			if len(d) < 28 || binary.BigEndian.Uint32(d[8:]) == 0 {
+				return ""
+			}
+			n := int(binary.BigEndian.Uint32(d[20:]))
+			o := int(binary.BigEndian.Uint32(d[24:]))
+			if o+n > len(d) {
+				return ""
+			}
+			units := make([]uint16, n/2)
+			for j := range units {
+				units[j] = binary.BigEndian.Uint16(d[o+j*2:])
+			}
+			return strings.TrimRight(string(utf16.Decode(units)), "\x00 ")
+		}
+		return ""
+	}
+	return ""
+}
+
+// ============ METADATA CARRY-OVER ============
+
+// Tags patched when metadata is carried into a re-encoded image
+const (
+	tagImageWidth       = 0x0100
+	tagImageLength      = 0x0101
+	tagPixelXDimension  = 0xA002
+	tagPixelYDimension  = 0xA003
+	maxICCChunk         = 0xFFFF - 2 - 12 - 2 // segment length, ICC_PROFILE\0, seq/count
+	defaultICCChunkName = "ICC profile"
+)
+
+// extractMetadata returns the metadata blocks of a JPEG, PNG or WebP file
+func extractMetadata(data []byte) imageMetadata {
+	switch {
+	case isJPEG(data):
+		if segments, _, err := readJPEGSegments(data); err == nil {
+			return jpegMetadata(segments)
+		}
+	case bytes.HasPrefix(data, pngSignature):
+		if chunks, err := readPNGChunks(data); err == nil {
+			return pngMetadata(chunks)
+		}
+	default:
+		if chunks, err := readWebPChunks(data); err == nil {
+			return webpMetadata(chunks)
+		}
+	}
+	return imageMetadata{}
+}
+
+// updatedExif returns a copy of a TIFF payload with the dimension tags set to
+// width x height and the IFD1 thumbnail unlinked, since it would still show
+// the original image. orientation > 0 replaces the orientation tag.
+func updatedExif(tiff []byte, width, height, orientation int) ([]byte, error) {
+	tiff = append([]byte(nil), tiff...)
+	e, err := parseExif(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := func(ifd map[uint16]tiffEntry, tag uint16, v int) {
+		entry, ok := ifd[tag]
+		if !ok {
+			return
+		}
+		switch {
+		case entry.Type == tiffShort && v <= 0xFFFF:
+			e.order.PutUint16(tiff[entry.ValueOffset:], uint16(v))
+		case entry.Type == tiffLong:
+			e.order.PutUint32(tiff[entry.ValueOffset:], uint32(v))
+		}
+	}
+	patch(e.IFD0, tagImageWidth, width)
+	patch(e.IFD0, tagImageLength, height)
+	patch(e.Exif, tagPixelXDimension, width)
+	patch(e.Exif, tagPixelYDimension, height)
+
+	ifd0 := int(e.order.Uint32(tiff[4:]))
+	count := int(e.order.Uint16(tiff[ifd0:]))
+	e.order.PutUint32(tiff[ifd0+2+count*12:], 0)
+
+	if orientation > 0 {
+		if entry, ok := e.IFD0[tagOrientation]; ok && entry.Type == tiffShort {
+			e.order.PutUint16(tiff[entry.ValueOffset:], uint16(orientation))
+		} else if orientation != 1 {
+			tiff = e.appendIFD0Entry(tagOrientation, tiffShort, uint16(orientation))
+		}
+	}
+	return tiff, nil
+}
+
+// embedMetadata inserts EXIF, ICC and XMP into an encoded JPEG (APP1/APP2
+// segments), PNG (eXIf, iCCP and iTXt chunks) or lossless WebP (a VP8X
+// header with ICCP, EXIF and XMP chunks). Other formats are returned
+// unchanged, as are blocks too large for a JPEG segment.
+func embedMetadata(out []byte, meta imageMetadata) ([]byte, error) {
+	switch {
+	case isJPEG(out):
+		segments, scan, err := readJPEGSegments(out)
+		if err != nil {
+			return nil, err
+		}
+
+		var added []jpegSegment
+		if meta.Exif != nil && len(exifHeader)+len(meta.Exif)+2 <= 0xFFFF {
+			added = append(added, jpegSegment{Marker: markerAPP1, Data: append(append([]byte(nil), exifHeader...), meta.Exif...)})
+		}
+		if meta.XMP != nil && len(xmpHeader)+len(meta.XMP)+2 <= 0xFFFF {
+			added = append(added, jpegSegment{Marker: markerAPP1, Data: append(append([]byte(nil), xmpHeader...), meta.XMP...)})
+		}
+		if n := (len(meta.ICC) + maxICCChunk - 1) / maxICCChunk; n > 0 && n <= 255 {
+			for i := 0; i < n; i++ {
+				chunk := meta.ICC[i*maxICCChunk : min(len(meta.ICC), (i+1)*maxICCChunk)]
+				data := append(append([]byte(nil), iccHeader...), byte(i+1), byte(n))
+				added = append(added, jpegSegment{Marker: markerAPP2, Data: append(data, chunk...)})
+			}
+		}
+
+		// After JFIF so it stays the first segment
+		pos := 0
+		for pos < len(segments) && segments[pos].Marker == markerAPP0 {
+			pos++
+		}
+		segments = append(segments[:pos], append(added, segments[pos:]...)...)
+		return writeJPEGSegments(segments, scan), nil
+
+	case bytes.HasPrefix(out, pngSignature):
+		chunks, err := readPNGChunks(out)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]pngChunk, 0, len(chunks)+3)
+		inserted := false
+		for _, c := range chunks {
+			if c.Type == "IDAT" && !inserted {
+				inserted = true
+				if meta.Exif != nil {
+					result = append(result, pngChunk{Type: "eXIf", Data: meta.Exif})
+				}
+				if meta.XMP != nil {
+					// Uncompressed iTXt with empty language and translated keyword
+					data := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), meta.XMP...)
+					result = append(result, pngChunk{Type: "iTXt", Data: data})
+				}
+			}
+			result = append(result, c)
+
+			// iCCP must precede PLTE and IDAT
+			if c.Type == "IHDR" && meta.ICC != nil {
+				name := meta.ICCName
+				if name == "" {
+					name = defaultICCChunkName
+				}
+				var buf bytes.Buffer
+				buf.WriteString(name)
+				buf.Write([]byte{0, 0}) // separator, deflate
+				w := zlib.NewWriter(&buf)
+				w.Write(meta.ICC)
+				w.Close()
+				result = append(result, pngChunk{Type: "iCCP", Data: buf.Bytes()})
+			}
+		}
+		return writePNGChunks(result), nil
+
+	case len(out) >= 12 && string(out[:4]) == "RIFF" && string(out[8:12]) == "WEBP":
+		chunks, err := readWebPChunks(out)
+		if err != nil {
+			return nil, err
+		}
+		// Only a plain VP8L file, as encodeWebP writes, is extended to VP8X
+		if len(chunks) != 1 || chunks[0].FourCC != "VP8L" || len(chunks[0].Data) < 5 || meta.Exif == nil && meta.ICC == nil && meta.XMP == nil {
+			return out, nil
+		}
+		header := binary.LittleEndian.Uint32(chunks[0].Data[1:])
+		width, height := header&0x3fff, header>>14&0x3fff // both stored minus one
+
+		// The alpha flag stays clear: x/image/webp then expects an ALPH
+		// chunk, and VP8L carries its own alpha either way
+		var flags byte
+		result := []webpChunk{{FourCC: "VP8X"}}
+		if meta.ICC != nil {
+			flags |= webpFlagICC
+			result = append(result, webpChunk{FourCC: "ICCP", Data: meta.ICC})
+		}
+		result = append(result, chunks[0])
+		if meta.Exif != nil {
+			flags |= webpFlagEXIF
+			result = append(result, webpChunk{FourCC: "EXIF", Data: meta.Exif})
+		}
+		if meta.XMP != nil {
+			flags |= webpFlagXMP
+			result = append(result, webpChunk{FourCC: "XMP ", Data: meta.XMP})
+		}
+		vp8x := []byte{flags, 0, 0, 0, byte(width), byte(width >> 8), byte(width >> 16), byte(height), byte(height >> 8), byte(height >> 16)}
+		result[0].Data = vp8x
+		return writeWebPChunks(result), nil
+	}
+
+	return out, nil
+}
+
+// metadataSize estimates the bytes carryMetadata adds to a JPEG
+func metadataSize(src []byte) int64 {
+	meta := extractMetadata(src)
+	n := len(meta.Exif) + len(meta.XMP) + len(meta.ICC)
+	if n == 0 {
+		return 0
+	}
+	chunks := len(meta.ICC)/maxICCChunk + 1
+	return int64(n + 4 + len(exifHeader) + 4 + len(xmpHeader) + chunks*(4+len(iccHeader)+2))
+}
+
+// carryMetadata copies the metadata of the source image into the encoded
+// output, updating the EXIF dimensions (and orientation when > 0)
+func carryMetadata(out, src []byte, width, height, orientation int) ([]byte, error) {
+	meta := extractMetadata(src)
+	if meta.Exif != nil {
+		exif, err := updatedExif(meta.Exif, width, height, orientation)
+		if err != nil {
+			exif = nil // unreadable EXIF is dropped rather than copied blindly
+		}
+		meta.Exif = exif
+	}
+	return embedMetadata(out, meta)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func longField(order tiffOrder, tag uint16, v uint32) tiffField {
+	return tiffField{tag, tiffLong, 1, order.AppendUint32(nil, v)}
+}
+
+func TestUpdatedExif(t *testing.T) {
+	le, be := binary.LittleEndian, binary.BigEndian
+	thumbIFD := func(order tiffOrder) []tiffField {
+		return []tiffField{longField(order, tagThumbOffset, 8), longField(order, tagThumbLength, 4)}
+	}
+
+	tests := []struct {
+		name            string
+		tiff            []byte
+		size            [2]int // output width, height
+		orientation     int
+		wantIFD0        [2]uint32 // ImageWidth, ImageLength (0 = tag absent)
+		wantExif        [2]uint32 // PixelXDimension, PixelYDimension
+		wantOrientation int       // 0 = tag absent
+	}{
+		{
+			name:     "short dimensions",
+			tiff:     buildTIFF(le, []tiffField{shortField(le, tagImageWidth, 4000), shortField(le, tagImageLength, 3000)}, nil, nil, nil),
+			size:     [2]int{800, 600},
+			wantIFD0: [2]uint32{800, 600},
+		},
+		{
+			name:     "long dimensions big endian",
+			tiff:     buildTIFF(be, []tiffField{longField(be, tagImageWidth, 4000), longField(be, tagImageLength, 3000)}, []tiffField{longField(be, tagPixelXDimension, 4000), longField(be, tagPixelYDimension, 3000)}, nil, nil),
+			size:     [2]int{70000, 600},
+			wantIFD0: [2]uint32{70000, 600},
+			wantExif: [2]uint32{70000, 600},
+		},
+		{
+			name:     "short too small for width keeps old value",
+			tiff:     buildTIFF(le, nil, []tiffField{shortField(le, tagPixelXDimension, 4000), shortField(le, tagPixelYDimension, 3000)}, nil, nil),
+			size:     [2]int{70000, 600},
+			wantExif: [2]uint32{4000, 600},
+		},
+		{
+			name:            "orientation patched",
+			tiff:            buildTIFF(le, []tiffField{shortField(le, tagOrientation, 6)}, nil, nil, thumbIFD(le)),
+			size:            [2]int{10, 20},
+			orientation:     1,
+			wantOrientation: 1,
+		},
+		{
+			name:            "orientation added",
+			tiff:            buildTIFF(be, []tiffField{asciiField(tagMake, "Acme")}, nil, nil, thumbIFD(be)),
+			size:            [2]int{10, 20},
+			orientation:     8,
+			wantOrientation: 8,
+		},
+		{
+			name:        "orientation 1 is not added",
+			tiff:        buildTIFF(le, []tiffField{asciiField(tagMake, "Acme")}, nil, nil, nil),
+			size:        [2]int{10, 20},
+			orientation: 1,
+		},
+		{
+			name:            "orientation kept when not given",
+			tiff:            buildTIFF(le, []tiffField{shortField(le, tagOrientation, 3)}, nil, nil, nil),
+			size:            [2]int{10, 20},
+			wantOrientation: 3,
+		},
+	}
+	for _, tt := range tests {
+		original := append([]byte(nil), tt.tiff...)
+		out, err := updatedExif(tt.tiff, tt.size[0], tt.size[1], tt.orientation)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(tt.tiff, original) {
+			t.Errorf("%s: input modified", tt.name)
+		}
+
+		e, err := parseExif(out)
+		if err != nil {
+			t.Errorf("%s: output does not parse: %v", tt.name, err)
+			continue
+		}
+		value := func(ifd map[uint16]tiffEntry, tag uint16) uint32 {
+			if entry, ok := ifd[tag]; ok {
+				return e.uint(entry, 0)
+			}
+			return 0
+		}
+		if got := [2]uint32{value(e.IFD0, tagImageWidth), value(e.IFD0, tagImageLength)}; got != tt.wantIFD0 {
+			t.Errorf("%s: IFD0 size %v, want %v", tt.name, got, tt.wantIFD0)
+		}
+		if got := [2]uint32{value(e.Exif, tagPixelXDimension), value(e.Exif, tagPixelYDimension)}; got != tt.wantExif {
+			t.Errorf("%s: Exif size %v, want %v", tt.name, got, tt.wantExif)
+		}
+		if got := int(value(e.IFD0, tagOrientation)); got != tt.wantOrientation {
+			t.Errorf("%s: orientation %d, want %d", tt.name, got, tt.wantOrientation)
+		}
+		if e.IFD1 != nil {
+			t.Errorf("%s: IFD1 thumbnail still linked", tt.name)
+		}
+		if bytes.Contains(original, []byte("Acme")) && e.string(e.IFD0[tagMake]) != "Acme" {
+			t.Errorf("%s: other tags lost", tt.name)
+		}
+	}
+
+	if _, err := updatedExif([]byte("not exif"), 1, 1, 0); err == nil {
+		t.Error("updatedExif accepted an invalid payload")
+	}
+}
+
+func TestCarryMetadata(t *testing.T) {
+	le := binary.LittleEndian
+	exif := buildTIFF(le, []tiffField{shortField(le, tagOrientation, 6), asciiField(tagMake, "Acme")},
+		[]tiffField{shortField(le, tagPixelXDimension, 1600), shortField(le, tagPixelYDimension, 1200)}, nil, nil)
+	source := func(exif []byte) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, testPattern(), nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := embedMetadata(buf.Bytes(), imageMetadata{Exif: exif, ICC: testMetadata.ICC, XMP: testMetadata.XMP})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	var jpegOut, pngOut bytes.Buffer
+	if err := jpeg.Encode(&jpegOut, testPattern(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngOut, testPattern()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		out, src        []byte
+		orientation     int
+		wantExif        bool
+		wantOrientation int
+	}{
+		{name: "jpeg", out: jpegOut.Bytes(), src: source(exif), wantExif: true, wantOrientation: 6},
+		{name: "png reset orientation", out: pngOut.Bytes(), src: source(exif), orientation: 1, wantExif: true, wantOrientation: 1},
+		{name: "unreadable exif dropped", out: jpegOut.Bytes(), src: source([]byte("II*\x00\xff\xff\x00\x00")), orientation: 1},
+		{name: "source without metadata", out: pngOut.Bytes(), src: pngOut.Bytes()},
+	}
+	for _, tt := range tests {
+		out, err := carryMetadata(tt.out, tt.src, 400, 300, tt.orientation)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		meta := extractMetadata(out)
+		wantBlocks := !bytes.Equal(tt.src, pngOut.Bytes())
+		if bytes.Equal(meta.ICC, testMetadata.ICC) != wantBlocks || bytes.Equal(meta.XMP, testMetadata.XMP) != wantBlocks {
+			t.Errorf("%s: ICC/XMP carried = %v/%v, want %v", tt.name, meta.ICC != nil, meta.XMP != nil, wantBlocks)
+		}
+		if (meta.Exif != nil) != tt.wantExif {
+			t.Errorf("%s: EXIF carried = %v, want %v", tt.name, meta.Exif != nil, tt.wantExif)
+			continue
+		}
+		if !tt.wantExif {
+			continue
+		}
+		e, err := parseExif(meta.Exif)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if w, h := e.uint(e.Exif[tagPixelXDimension], 0), e.uint(e.Exif[tagPixelYDimension], 0); w != 400 || h != 300 {
+			t.Errorf("%s: EXIF size %dx%d, want 400x300", tt.name, w, h)
+		}
+		if got := e.orientation(); got != tt.wantOrientation {
+			t.Errorf("%s: orientation %d, want %d", tt.name, got, tt.wantOrientation)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============ PIPELINE MODE ============
+
+// PipelineOp is one pipeline step; the fields used depend on Op:
+//
+//	crop      x, y, width, height (unit "%" or px), or aspect with anchor
+//	resize    width, height, percent, maxMegapixels, fit, kernel
+//	rotate    angle (degrees clockwise), background
+//	flip      direction ("horizontal" or "vertical")
+//	filter    steps (see FilterStep)
+//	annotate  annotations, scale
+//	watermark logo or text, color, position, margin, opacity, scale, tile
+//	strip     keepICC
+//	encode    format, quality, minQuality, targetSize, colors, dither, flatten,
+//	          keepMetadata
+type PipelineOp struct {
+	Op string `json:"op"`
+
+	X      float64 `json:"x,omitempty"`
+	Y      float64 `json:"y,omitempty"`
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+	Unit   string  `json:"unit,omitempty"`
+	Aspect string  `json:"aspect,omitempty"`
+	Anchor string  `json:"anchor,omitempty"`
+
+	Percent       float64 `json:"percent,omitempty"`
+	MaxMegapixels float64 `json:"maxMegapixels,omitempty"`
+	Fit           string  `json:"fit,omitempty"`
+	Kernel        string  `json:"kernel,omitempty"`
+
+	Angle      float64 `json:"angle,omitempty"`
+	Background string  `json:"background,omitempty"`
+	Direction  string  `json:"direction,omitempty"`
+
+	Steps       []FilterStep `json:"steps,omitempty"`
+	Annotations []Annotation `json:"annotations,omitempty"`
+	Scale       *float64     `json:"scale,omitempty"`
+
+	Logo     string   `json:"logo,omitempty"`
+	Text     string   `json:"text,omitempty"`
+	Color    string   `json:"color,omitempty"`
+	Position string   `json:"position,omitempty"`
+	Margin   *float64 `json:"margin,omitempty"`
+	Opacity  *float64 `json:"opacity,omitempty"`
+	Tile     bool     `json:"tile,omitempty"`
+
+	KeepICC bool `json:"keepICC,omitempty"`
+
+	Format     string `json:"format,omitempty"`
+	Quality    int    `json:"quality,omitempty"`
+	MinQuality int    `json:"minQuality,omitempty"`
+	TargetSize string `json:"targetSize,omitempty"`
+	Colors     int    `json:"colors,omitempty"`
+	Dither     bool   `json:"dither,omitempty"`
+	Flatten    string `json:"flatten,omitempty"`
+
+	KeepMetadata bool `json:"keepMetadata,omitempty"`
+}
+
+// floatOr returns *v, or def when the field was not given
+func floatOr(v *float64, def float64) float64 {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+// pipelineStep transforms the working image
+type pipelineStep func(img image.Image) (image.Image, error)
+
+// pipeline is a parsed, validated list of ops, reusable across a batch.
+// Metadata is dropped unless encode sets keepMetadata; a strip op overrides
+// it, carrying at most the ICC profile.
+type pipeline struct {
+	steps        []pipelineStep
+	stepOps      []int // index into the ops array of each step
+	names        []string
+	encode       CompressOptions
+	strip        bool
+	keepICC      bool
+	keepMetadata bool
+}
+
+// parsePipeline reads a JSON array of ops inline or from a file and prepares
+// each step (watermark logos and fonts are loaded once here)
+func parsePipeline(s string) (*pipeline, error) {
+	data, err := jsonArgBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline: %v", err)
+	}
+	var ops []PipelineOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("pipeline: %v", err)
+	}
+
+	p := &pipeline{encode: CompressOptions{Quality: 85, MinQuality: 40}}
+	encodeSeen := false
+	for i, op := range ops {
+		if encodeSeen {
+			return nil, fmt.Errorf("op %d: encode must be the last op", i)
+		}
+		step, err := p.prepare(op, &encodeSeen)
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s): %v", i, op.Op, err)
+		}
+		if step != nil {
+			p.steps = append(p.steps, step)
+			p.stepOps = append(p.stepOps, i)
+		}
+		p.names = append(p.names, op.Op)
+	}
+	return p, nil
+}
+
+// prepare validates one op and returns its step; strip and encode only
+// configure the output and return nil
+func (p *pipeline) prepare(op PipelineOp, encodeSeen *bool) (pipelineStep, error) {
+	switch op.Op {
+	case "crop":
+		return cropStep(op)
+
+	case "resize":
+		opts := ResizeOptions{
+			Width:         int(math.Round(op.Width)),
+			Height:        int(math.Round(op.Height)),
+			Percent:       op.Percent,
+			MaxMegapixels: op.MaxMegapixels,
+			Fit:           op.Fit,
+			Kernel:        op.Kernel,
+		}
+		if opts.Fit == "" {
+			opts.Fit = "contain"
+		}
+		if err := opts.validate(); err != nil {
+			return nil, err
+		}
+		return func(img image.Image) (image.Image, error) {
+			return resizeImage(img, opts), nil
+		}, nil
+
+	case "rotate":
+		bg := op.Background
+		if bg == "" {
+			bg = "#ffffff"
+		}
+		background, err := parseHexColor(bg)
+		if err != nil {
+			return nil, err
+		}
+		opts := TransformOptions{Op: "rotate", Angle: op.Angle, Background: background}
+		return func(img image.Image) (image.Image, error) {
+			return transformImage(img, 1, opts)
+		}, nil
+
+	case "flip":
+		transform := map[string]string{"horizontal": "flip-h", "vertical": "flip-v"}[op.Direction]
+		if transform == "" {
+			return nil, fmt.Errorf("direction must be horizontal or vertical")
+		}
+		return func(img image.Image) (image.Image, error) {
+			return transformImage(img, 1, TransformOptions{Op: transform})
+		}, nil
+
+	case "filter":
+		for i, step := range op.Steps {
+			if err := step.validate(); err != nil {
+				return nil, fmt.Errorf("filter %d: %v", i, err)
+			}
+		}
+		return func(img image.Image) (image.Image, error) {
+			return applyFilters(img, op.Steps), nil
+		}, nil
+
+	case "annotate":
+		// Round-trip through the annotate parser for its validation
+		raw, _ := json.Marshal(op.Annotations)
+		anns, err := parseAnnotations(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		return func(img image.Image) (image.Image, error) {
+			return annotateImage(img, anns, floatOr(op.Scale, 1))
+		}, nil
+
+	case "watermark":
+		opts, err := op.watermarkOptions()
+		if err != nil {
+			return nil, err
+		}
+		wm, err := newWatermark(opts)
+		if err != nil {
+			return nil, err
+		}
+		return func(img image.Image) (image.Image, error) {
+			return wm.apply(img)
+		}, nil
+
+	case "strip":
+		p.strip = true
+		p.keepICC = op.KeepICC
+		return nil, nil
+
+	case "encode":
+		*encodeSeen = true
+		enc := &p.encode
+		if op.Format != "" {
+			if _, err := compressFormat("", op.Format); err != nil {
+				return nil, err
+			}
+		}
+		enc.Format = op.Format
+		p.keepMetadata = op.KeepMetadata
+		if op.Quality != 0 {
+			enc.Quality = op.Quality
+		}
+		if op.MinQuality != 0 {
+			enc.MinQuality = op.MinQuality
+		}
+		if op.TargetSize != "" {
+			target, err := parseByteSize(op.TargetSize)
+			if err != nil {
+				return nil, err
+			}
+			enc.TargetSize = target
+		}
+		if op.Colors != 0 && (op.Colors < 2 || op.Colors > 256) {
+			return nil, fmt.Errorf("colors must be between 2 and 256")
+		}
+		enc.Colors = op.Colors
+		enc.Dither = op.Dither
+		if op.Flatten != "" {
+			bg, err := parseHexColor(op.Flatten)
+			if err != nil {
+				return nil, err
+			}
+			enc.Flatten = bg
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown op")
+}
+
+// watermarkOptions fills unset watermark fields with the --wm-* flag
+// defaults; explicit zeros (margin: 0) are kept
+func (op PipelineOp) watermarkOptions() (WatermarkOptions, error) {
+	opts := WatermarkOptions{
+		Logo: op.Logo, Text: op.Text, Position: op.Position, Margin: floatOr(op.Margin, 0.02),
+		Opacity: floatOr(op.Opacity, 0.5), Scale: floatOr(op.Scale, 0.2), Tile: op.Tile,
+	}
+	if opts.Position == "" {
+		opts.Position = "bottom-right"
+	}
+	textColor := op.Color
+	if textColor == "" {
+		textColor = "#ffffff"
+	}
+	var err error
+	opts.Color, err = parseHexColor(textColor)
+	return opts, err
+}
+
+// cropStep builds a crop by box (pixels or percent of the current image) or
+// by aspect ratio with an anchor
+func cropStep(op PipelineOp) (pipelineStep, error) {
+	if op.Aspect != "" {
+		ratio, err := parseAspect(op.Aspect)
+		if err != nil {
+			return nil, err
+		}
+		anchor := op.Anchor
+		if anchor == "" {
+			anchor = "center"
+		}
+		if !validGravities[anchor] {
+			return nil, fmt.Errorf("unknown anchor: %s", anchor)
+		}
+		return func(img image.Image) (image.Image, error) {
+			return cropToRect(img, aspectRect(img, ratio, anchor)), nil
+		}, nil
+	}
+
+	box := cropBox{X: op.X, Y: op.Y, W: op.Width, H: op.Height, Percent: op.Unit == "%"}
+	if box.W <= 0 || box.H <= 0 {
+		return nil, fmt.Errorf("width and height required")
+	}
+	return func(img image.Image) (image.Image, error) {
+		r, err := box.rect(img.Bounds())
+		if err != nil {
+			return nil, err
+		}
+		return cropToRect(img, r), nil
+	}, nil
+}
+
+// runPipelineFile decodes the input once, runs the steps on the displayed
+// (auto-oriented) image and encodes once via compressDecoded
+func runPipelineFile(inputPath, outputPath string, p *pipeline) map[string]interface{} {
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+
+	for i, step := range p.steps {
+		if img, err = step(img); err != nil {
+			op := p.stepOps[i]
+			return map[string]interface{}{"success": false, "error": fmt.Sprintf("op %d (%s): %v", op, p.names[op], err)}
+		}
+	}
+
+	opts := p.encode
+	var source []byte
+	switch {
+	case p.strip && p.keepICC:
+		if source, _, err = stripMetadata(data, StripOptions{KeepICC: true}); err != nil {
+			return map[string]interface{}{"success": false, "error": err.Error()}
+		}
+	case p.keepMetadata && !p.strip:
+		source = data
+	}
+	opts.KeepMetadata = source != nil
+
+	result := compressDecoded(img, format, source, 1, outputPath, opts)
+	result["ops"] = p.names
+	return result
+}
+
+// batchPipelineStreaming runs the pipeline over files into outputDir
+func batchPipelineStreaming(files []string, outputDir string, p *pipeline, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+formatExt(source, p.encode.Format))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return processItemFromResult(source, outputPath, runPipelineFile(source, outputPath, p))
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatermarkOpDefaults(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	tests := []struct {
+		name string
+		op   string
+		want WatermarkOptions
+	}{
+		{
+			name: "defaults",
+			op:   `{"op":"watermark","text":"x"}`,
+			want: WatermarkOptions{Text: "x", Color: white, Position: "bottom-right", Margin: 0.02, Opacity: 0.5, Scale: 0.2},
+		},
+		{
+			name: "explicit zero margin",
+			op:   `{"op":"watermark","text":"x","margin":0,"opacity":1,"scale":0.5,"position":"top","color":"#000000"}`,
+			want: WatermarkOptions{Text: "x", Color: color.RGBA{0, 0, 0, 255}, Position: "top", Margin: 0, Opacity: 1, Scale: 0.5},
+		},
+	}
+	for _, tt := range tests {
+		var op PipelineOp
+		if err := json.Unmarshal([]byte(tt.op), &op); err != nil {
+			t.Fatal(err)
+		}
+		got, err := op.watermarkOptions()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	tests := []struct {
+		name      string
+		ops       string
+		wantSteps int
+		wantErr   bool
+	}{
+		{name: "crop resize encode", ops: `[{"op":"crop","aspect":"1:1"},{"op":"resize","width":100},{"op":"encode","format":"png"}]`, wantSteps: 2},
+		{name: "strip adds no step", ops: `[{"op":"flip","direction":"vertical"},{"op":"strip"}]`, wantSteps: 1},
+		{name: "watermark zero margin", ops: `[{"op":"watermark","text":"x","margin":0}]`, wantSteps: 1},
+		{name: "watermark zero opacity", ops: `[{"op":"watermark","text":"x","opacity":0}]`, wantErr: true},
+		{name: "watermark zero scale", ops: `[{"op":"watermark","text":"x","scale":0}]`, wantErr: true},
+		{name: "encode not last", ops: `[{"op":"encode"},{"op":"flip","direction":"vertical"}]`, wantErr: true},
+		{name: "unknown op", ops: `[{"op":"explode"}]`, wantErr: true},
+		{name: "bad flip", ops: `[{"op":"flip","direction":"sideways"}]`, wantErr: true},
+		{name: "bad encode format", ops: `[{"op":"encode","format":"heic"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := parsePipeline(tt.ops)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && len(p.steps) != tt.wantSteps {
+			t.Errorf("%s: %d steps, want %d", tt.name, len(p.steps), tt.wantSteps)
+		}
+	}
+}
+
+func TestRunPipelineFileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	if err := os.WriteFile(input, taggedJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                       string
+		ops                        string
+		wantExif, wantICC, wantXMP bool
+	}{
+		{name: "dropped by default", ops: `[{"op":"flip","direction":"vertical"}]`},
+		{name: "keepMetadata", ops: `[{"op":"encode","keepMetadata":true}]`, wantExif: true, wantICC: true, wantXMP: true},
+		{name: "strip overrides keepMetadata", ops: `[{"op":"strip"},{"op":"encode","keepMetadata":true}]`},
+		{name: "strip keeping ICC", ops: `[{"op":"strip","keepICC":true}]`, wantICC: true},
+	}
+	for i, tt := range tests {
+		p, err := parsePipeline(tt.ops)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		output := filepath.Join(dir, fmt.Sprintf("out%d.jpg", i))
+		if result := runPipelineFile(input, output, p); result["success"] != true {
+			t.Fatalf("%s: %v", tt.name, result["error"])
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := extractMetadata(data)
+		if (meta.Exif != nil) != tt.wantExif || (meta.ICC != nil) != tt.wantICC {
+			t.Errorf("%s: EXIF %v ICC %v, want %v %v", tt.name, meta.Exif != nil, meta.ICC != nil, tt.wantExif, tt.wantICC)
+		}
+		if (meta.XMP != nil) != tt.wantXMP {
+			t.Errorf("%s: XMP %v, want %v", tt.name, meta.XMP != nil, tt.wantXMP)
+		}
+	}
+}
+
+func TestRunPipelineFileErrorIndex(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.jpg")
+	if err := os.WriteFile(input, taggedJPEG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The strip op adds no step but still counts in the reported index
+	p, err := parsePipeline(`[{"op":"strip"},{"op":"flip","direction":"vertical"},{"op":"crop","x":0,"y":0,"width":500,"height":5}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runPipelineFile(input, filepath.Join(dir, "out.jpg"), p)
+	if result["success"] != false {
+		t.Fatal("oversized crop succeeded")
+	}
+	if msg, _ := result["error"].(string); !strings.HasPrefix(msg, "op 2 (crop): ") {
+		t.Errorf("error %q, want it to name op 2 (crop)", msg)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ PALETTE QUANTISATION ============
+
+// medianCut is a draw.Quantizer that builds a palette by recursively
+// splitting the colour box with the widest channel range at its median
+type medianCut struct{}
+
+// quantSampleLimit caps the pixels sampled when building the histogram
+const quantSampleLimit = 1 << 20
+
+// colorCount is a histogram entry: a 5-bit-per-channel RGBA colour
+type colorCount struct {
+	c     [4]uint8 // non-premultiplied r, g, b, a (5 bits each)
+	count int
+}
+
+type colorBox struct {
+	entries []colorCount
+	total   int
+}
+
+// channelRange returns the channel with the widest spread and its width
+func (b *colorBox) channelRange() (int, int) {
+	bestCh, bestRange := 0, -1
+	for ch := 0; ch < 4; ch++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, e := range b.entries {
+			lo = min(lo, e.c[ch])
+			hi = max(hi, e.c[ch])
+		}
+		if r := int(hi) - int(lo); r > bestRange {
+			bestCh, bestRange = ch, r
+		}
+	}
+	return bestCh, bestRange
+}
+
+// mean returns the count-weighted average colour of the box
+func (b *colorBox) mean() color.NRGBA {
+	var sum [4]int
+	for _, e := range b.entries {
+		for ch := 0; ch < 4; ch++ {
+			sum[ch] += int(e.c[ch]) * e.count
+		}
+	}
+	var out [4]uint8
+	for ch := 0; ch < 4; ch++ {
+		v := sum[ch] / max(1, b.total) // 0..31
+		out[ch] = uint8(v<<3 | v>>2)
+	}
+	return color.NRGBA{R: out[0], G: out[1], B: out[2], A: out[3]}
+}
+
+// colorHistogram samples m into 5-bit-per-channel buckets
+func colorHistogram(m image.Image) []colorCount {
+	bounds := m.Bounds()
+	step := 1
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > quantSampleLimit {
+		step++
+	}
+
+	counts := make(map[uint32]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			key := uint32(c.R>>3)<<15 | uint32(c.G>>3)<<10 | uint32(c.B>>3)<<5 | uint32(c.A>>3)
+			counts[key]++
+		}
+	}
+
+	hist := make([]colorCount, 0, len(counts))
+	for key, n := range counts {
+		hist = append(hist, colorCount{
+			c:     [4]uint8{uint8(key >> 15 & 31), uint8(key >> 10 & 31), uint8(key >> 5 & 31), uint8(key & 31)},
+			count: n,
+		})
+	}
+	return hist
+}
+
+// Quantize implements draw.Quantizer, appending up to cap(p)-len(p) colours
+func (medianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	n := cap(p) - len(p)
+	if n <= 0 {
+		n = 256 - len(p)
+	}
+
+	hist := colorHistogram(m)
+	total := 0
+	for _, e := range hist {
+		total += e.count
+	}
+	boxes := []*colorBox{{entries: hist, total: total}}
+
+	for len(boxes) < n {
+		// Split the box with the widest channel, weighted by population
+		split, splitCh, bestScore := -1, 0, 0
+		for i, b := range boxes {
+			if len(b.entries) < 2 {
+				continue
+			}
+			ch, r := b.channelRange()
+			if score := r * b.total; r > 0 && score > bestScore {
+				split, splitCh, bestScore = i, ch, score
+			}
+		}
+		if split < 0 {
+			break
+		}
+
+		b := boxes[split]
+		sort.Slice(b.entries, func(i, j int) bool { return b.entries[i].c[splitCh] < b.entries[j].c[splitCh] })
+
+		// Median by pixel count, keeping both halves non-empty
+		half, acc, cut := b.total/2, 0, 1
+		for i, e := range b.entries[:len(b.entries)-1] {
+			acc += e.count
+			cut = i + 1
+			if acc >= half {
+				break
+			}
+		}
+
+		lo := &colorBox{entries: b.entries[:cut]}
+		hi := &colorBox{entries: b.entries[cut:]}
+		for _, e := range lo.entries {
+			lo.total += e.count
+		}
+		hi.total = b.total - lo.total
+		boxes[split] = lo
+		boxes = append(boxes, hi)
+	}
+
+	for _, b := range boxes {
+		p = append(p, b.mean())
+	}
+	return p
+}
+
+// remapPaletted maps img onto palette, optionally with Floyd-Steinberg error
+// diffusion. Nearest entries are cached per 5-bit RGBA bucket, which keeps
+// large images fast at the cost of exact matching.
+func remapPaletted(img image.Image, palette color.Palette, dither bool) *image.Paletted {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	src := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	dst := image.NewPaletted(src.Bounds(), palette)
+
+	pal := make([][4]int32, len(palette))
+	for i, c := range palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		pal[i] = [4]int32{int32(n.R), int32(n.G), int32(n.B), int32(n.A)}
+	}
+
+	cache := make([]int16, 1<<20)
+	for i := range cache {
+		cache[i] = -1
+	}
+	nearest := func(c [4]int32) uint8 {
+		key := c[0]>>3<<15 | c[1]>>3<<10 | c[2]>>3<<5 | c[3]>>3
+		if idx := cache[key]; idx >= 0 {
+			return uint8(idx)
+		}
+		// Match against the bucket centre so results don't depend on pixel order
+		centre := [4]int32{c[0]>>3<<3 | 4, c[1]>>3<<3 | 4, c[2]>>3<<3 | 4, c[3]>>3<<3 | 4}
+		best, bestDist := 0, int32(math.MaxInt32)
+		for i, p := range pal {
+			var d int32
+			for ch := 0; ch < 4; ch++ {
+				diff := centre[ch] - p[ch]
+				d += diff * diff
+			}
+			if d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		cache[key] = int16(best)
+		return uint8(best)
+	}
+
+	// Error rows for diffusion, padded by one pixel on each side (in 1/16ths)
+	cur := make([][4]int32, w+2)
+	next := make([][4]int32, w+2)
+
+	for y := 0; y < h; y++ {
+		row := src.Pix[y*src.Stride:]
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var c [4]int32
+			for ch := 0; ch < 4; ch++ {
+				v := int32(row[x*4+ch])
+				if dither {
+					v += cur[x+1][ch] / 16
+				}
+				c[ch] = max(0, min(255, v))
+			}
+
+			idx := nearest(c)
+			out[x] = idx
+			if !dither {
+				continue
+			}
+
+			for ch := 0; ch < 4; ch++ {
+				e := c[ch] - pal[idx][ch]
+				cur[x+2][ch] += e * 7
+				next[x][ch] += e * 3
+				next[x+1][ch] += e * 5
+				next[x+2][ch] += e
+			}
+		}
+		if dither {
+			cur, next = next, cur
+			clear(next)
+		}
+	}
+	return dst
+}
+
+// hasAlpha reports whether img has any non-opaque pixel
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// blocksImage fills equal vertical stripes with the given colours
+func blocksImage(colors ...color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 16*len(colors), 16))
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		for y := 0; y < 16; y++ {
+			img.SetNRGBA(x, y, colors[x/16])
+		}
+	}
+	return img
+}
+
+// gradientImage has a few thousand distinct 5-bit colours
+func gradientImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(255 - x), 255})
+		}
+	}
+	return img
+}
+
+func TestMedianCut(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	transparent := color.NRGBA{0, 0, 0, 0}
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		palette color.Palette
+		wantLen int
+		want    []color.NRGBA // colours that must appear exactly
+	}{
+		{"fewer colours than slots", blocksImage(red, green, blue), make(color.Palette, 0, 16), 3, []color.NRGBA{red, green, blue}},
+		{"transparency kept", blocksImage(red, transparent), make(color.Palette, 0, 8), 2, []color.NRGBA{red, transparent}},
+		{"fills every slot", gradientImage(), make(color.Palette, 0, 8), 8, nil},
+		{"256 slots", gradientImage(), make(color.Palette, 0, 256), 256, nil},
+		{"appends after existing entries", gradientImage(), append(make(color.Palette, 0, 4), color.Black), 4, nil},
+		{"zero capacity means 256", blocksImage(red, blue), nil, 2, []color.NRGBA{red, blue}},
+	}
+	for _, tt := range tests {
+		got := medianCut{}.Quantize(tt.palette, tt.img)
+		if len(got) != tt.wantLen {
+			t.Errorf("%s: %d colours, want %d", tt.name, len(got), tt.wantLen)
+		}
+		for _, want := range tt.want {
+			found := false
+			for _, c := range got {
+				if color.NRGBAModel.Convert(c) == want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("%s: palette %v lacks %v", tt.name, got, want)
+			}
+		}
+	}
+}
+
+func TestRemapPalettedExact(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	img := blocksImage(red, blue)
+	palette := medianCut{}.Quantize(make(color.Palette, 0, 4), img)
+
+	for _, dither := range []bool{false, true} {
+		out := remapPaletted(img, palette, dither)
+		if c := color.NRGBAModel.Convert(out.At(0, 0)); c != red {
+			t.Errorf("dither=%v: left stripe is %v, want %v", dither, c, red)
+		}
+		if c := color.NRGBAModel.Convert(out.At(31, 15)); c != blue {
+			t.Errorf("dither=%v: right stripe is %v, want %v", dither, c, blue)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ REDACT MODE ============
+
+// RedactRegion is a rectangle (x, y, width, height) or a polygon (points) to
+// hide, in image coordinates; unit "%" is relative to the image size
+type RedactRegion struct {
+	X      float64      `json:"x"`
+	Y      float64      `json:"y"`
+	Width  float64      `json:"width"`
+	Height float64      `json:"height"`
+	Unit   string       `json:"unit,omitempty"`
+	Points [][2]float64 `json:"points,omitempty"`
+	Mode   string       `json:"mode,omitempty"`   // overrides RedactOptions.Mode
+	Color  string       `json:"color,omitempty"`  // fill colour
+	Amount float64      `json:"amount,omitempty"` // block size or blur sigma
+}
+
+// RedactOptions controls redaction
+type RedactOptions struct {
+	Regions []RedactRegion
+	Mode    string     // pixelate, fill, blur
+	Fill    color.RGBA // default fill colour
+	Amount  float64    // block size or blur sigma, 0 = scaled to the region
+}
+
+var validRedactModes = map[string]bool{"pixelate": true, "fill": true, "blur": true}
+
+// Redaction strength floors: blocks and blur sigmas never go below these,
+// and scale up with the region so large text cannot be read through
+const (
+	redactMinBlock   = 16
+	redactMinSigma   = 16
+	redactChangedMin = 8 // per-channel difference that counts as altered
+	redactFlatRange  = 8 // luma range below which a region has nothing to hide
+)
+
+// Verification thresholds for regions with detail: pixelate and fill must
+// reach at least redactCoveredMin of the pixels they alter, blur must move
+// the region by at least redactBlurMeanMin per channel on average
+const (
+	redactCoveredMin  = 0.9
+	redactBlurMeanMin = 4.0
+)
+
+// parseRedactRegions reads a JSON array of regions inline or from a file
+func parseRedactRegions(s string) ([]RedactRegion, error) {
+	data, err := jsonArgBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("redact: %v", err)
+	}
+
+	var regions []RedactRegion
+	if err := json.Unmarshal(data, &regions); err != nil {
+		return nil, fmt.Errorf("redact: %v", err)
+	}
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("redact: no regions")
+	}
+	for i, r := range regions {
+		if r.Mode != "" && !validRedactModes[r.Mode] {
+			return nil, fmt.Errorf("region %d: unknown mode %q", i, r.Mode)
+		}
+		if r.Points != nil && len(r.Points) < 3 {
+			return nil, fmt.Errorf("region %d: polygon needs at least 3 points", i)
+		}
+		if r.Points == nil && (r.Width == 0 || r.Height == 0) {
+			return nil, fmt.Errorf("region %d: empty rectangle", i)
+		}
+		if r.Color != "" {
+			if _, err := parseHexColor(r.Color); err != nil {
+				return nil, fmt.Errorf("region %d: %v", i, err)
+			}
+		}
+	}
+	return regions, nil
+}
+
+// mode is the region's own mode, or the default from opts
+func (r RedactRegion) mode(opts RedactOptions) string {
+	if r.Mode != "" {
+		return r.Mode
+	}
+	return opts.Mode
+}
+
+// regionMask returns the region's pixel bounds and a mask over them. Polygon
+// masks include every pixel the outline touches, so edges are never left
+// partially readable.
+func (r RedactRegion) regionMask(bounds image.Rectangle) (image.Rectangle, []bool) {
+	w, h := bounds.Dx(), bounds.Dy()
+	sx, sy := 1.0, 1.0
+	if r.Unit == "%" {
+		sx, sy = float64(w)/100, float64(h)/100
+	}
+
+	if r.Points == nil {
+		rect := Annotation{X: r.X * sx, Y: r.Y * sy, Width: r.Width * sx, Height: r.Height * sy}.normalizedRect(bounds)
+		mask := make([]bool, rect.Dx()*rect.Dy())
+		for i := range mask {
+			mask[i] = true
+		}
+		return rect, mask
+	}
+
+	points := make([][2]float64, len(r.Points))
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i, p := range r.Points {
+		points[i] = [2]float64{p[0] * sx, p[1] * sy}
+		minX, maxX = math.Min(minX, points[i][0]), math.Max(maxX, points[i][0])
+		minY, maxY = math.Min(minY, points[i][1]), math.Max(maxY, points[i][1])
+	}
+	rect := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY))).Intersect(bounds)
+
+	mask := make([]bool, rect.Dx()*rect.Dy())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			cx := float64(x) + 0.5
+			inside := false
+			touched := false
+			for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+				a, b := points[j], points[i]
+				// Even-odd crossing test on the pixel centre
+				if (a[1] > cy) != (b[1] > cy) && cx < a[0]+(cy-a[1])*(b[0]-a[0])/(b[1]-a[1]) {
+					inside = !inside
+				}
+				// Edge within half a pixel diagonal of the centre
+				if segmentDistance(cx, cy, a, b) <= math.Sqrt2/2 {
+					touched = true
+				}
+			}
+			mask[(y-rect.Min.Y)*rect.Dx()+x-rect.Min.X] = inside || touched
+		}
+	}
+	return rect, mask
+}
+
+// segmentDistance returns the distance from (x, y) to the segment a-b
+func segmentDistance(x, y float64, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if lenSq := dx*dx + dy*dy; lenSq > 0 {
+		t = math.Max(0, math.Min(1, ((x-a[0])*dx+(y-a[1])*dy)/lenSq))
+	}
+	ex, ey := x-a[0]-t*dx, y-a[1]-t*dy
+	return math.Sqrt(ex*ex + ey*ey)
+}
+
+// redactImage hides each region of a copy of img. Effects are computed on the
+// region's bounding box and copied back through its mask.
+func redactImage(img image.Image, opts RedactOptions) (*image.RGBA, []image.Rectangle, [][]bool, error) {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	rects := make([]image.Rectangle, len(opts.Regions))
+	masks := make([][]bool, len(opts.Regions))
+	for i, region := range opts.Regions {
+		rect, mask := region.regionMask(dst.Bounds())
+		if rect.Empty() {
+			return nil, nil, nil, fmt.Errorf("region %d is outside the image", i)
+		}
+		rects[i], masks[i] = rect, mask
+
+		mode := region.mode(opts)
+		amount := region.Amount
+		if amount <= 0 {
+			amount = opts.Amount
+		}
+		short := float64(min(rect.Dx(), rect.Dy()))
+		pad := 0
+		switch mode {
+		case "blur":
+			if amount <= 0 {
+				amount = math.Max(redactMinSigma, short/4)
+			}
+			pad = int(math.Ceil(amount * 3))
+		case "pixelate", "":
+			if amount <= 0 {
+				amount = math.Max(redactMinBlock, short/8)
+			}
+		}
+
+		// The effect is rendered on a scratch copy so polygon masks can pick from it
+		area := rect.Inset(-pad).Intersect(dst.Bounds())
+		scratch := image.NewRGBA(area)
+		draw.Draw(scratch, area, dst, area.Min, draw.Src)
+
+		switch mode {
+		case "fill":
+			fill := opts.Fill
+			if region.Color != "" {
+				fill, _ = parseHexColor(region.Color)
+			}
+			// Always opaque: a translucent fill would leave the content readable
+			opaque := color.NRGBAModel.Convert(fill).(color.NRGBA)
+			opaque.A = 255
+			draw.Draw(scratch, rect, image.NewUniform(opaque), image.Point{}, draw.Src)
+		case "blur":
+			blurRegion(scratch, rect, amount)
+		default:
+			pixelateRegion(scratch, rect, int(math.Round(amount)))
+		}
+
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if mask[(y-rect.Min.Y)*rect.Dx()+x-rect.Min.X] {
+					i, j := dst.PixOffset(x, y), scratch.PixOffset(x, y)
+					copy(dst.Pix[i:i+4], scratch.Pix[j:j+4])
+				}
+			}
+		}
+	}
+	return dst, rects, masks, nil
+}
+
+// redactCheck measures how much of a redaction reached the written output
+type redactCheck struct {
+	Changed  float64 // share of masked pixels altered beyond redactChangedMin
+	Covered  float64 // share of the pixels the redaction alters that the output alters too
+	MeanDiff float64 // mean per-channel difference from the original (0-255)
+	Detail   bool    // the original region had something to hide
+}
+
+// checkRedaction compares the original, the redacted image and the decoded
+// output under a mask. Covered ignores pixels the redaction itself leaves
+// as they were (text background under a matching fill, for instance).
+func checkRedaction(orig, redacted, out image.Image, rect image.Rectangle, mask []bool) redactCheck {
+	var check redactCheck
+	changed, expected, covered, total := 0, 0, 0, 0
+	sum := 0.0
+	lumaMin, lumaMax := uint32(math.MaxUint32), uint32(0)
+	diff := func(a, b image.Image, x, y int) float64 {
+		r0, g0, b0, _ := a.At(x, y).RGBA()
+		r1, g1, b1, _ := b.At(x, y).RGBA()
+		return max(abs(float64(r0)-float64(r1)), abs(float64(g0)-float64(g1)), abs(float64(b0)-float64(b1))) / 257
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if !mask[(y-rect.Min.Y)*rect.Dx()+x-rect.Min.X] {
+				continue
+			}
+			total++
+			r, g, b, _ := orig.At(x, y).RGBA()
+			luma := (19595*r + 38470*g + 7471*b + 1<<15) >> 24
+			lumaMin, lumaMax = min(lumaMin, luma), max(lumaMax, luma)
+
+			d := diff(orig, out, x, y)
+			sum += d
+			if d > redactChangedMin {
+				changed++
+			}
+			if diff(orig, redacted, x, y) > redactChangedMin {
+				expected++
+				if d > redactChangedMin {
+					covered++
+				}
+			}
+		}
+	}
+	if total == 0 {
+		return check
+	}
+	check.Changed = float64(changed) / float64(total)
+	if expected > 0 {
+		check.Covered = float64(covered) / float64(expected)
+	}
+	check.MeanDiff = sum / float64(total)
+	check.Detail = lumaMax-lumaMin > redactFlatRange
+	return check
+}
+
+// verify reports why a region with detail does not count as redacted
+func (c redactCheck) verify(mode string) error {
+	if !c.Detail {
+		return nil
+	}
+	if mode == "blur" {
+		if c.MeanDiff < redactBlurMeanMin {
+			return fmt.Errorf("blur changed the region by %.1f on average, below %.0f", c.MeanDiff, redactBlurMeanMin)
+		}
+		return nil
+	}
+	if c.Covered < redactCoveredMin {
+		return fmt.Errorf("only %.0f%% of the region was altered", c.Covered*100)
+	}
+	return nil
+}
+
+// redactImageFile hides regions of a local file, URL or .repic input and
+// writes the result without any metadata. The encoded output is decoded and
+// checked before it is written: every region with detail must pass
+// redactCheck.verify.
+func redactImageFile(inputPath, outputPath string, opts RedactOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+
+	out, rects, masks, err := redactImage(img, opts)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	// Encoders here write no metadata, so the output is already stripped
+	encoded, err := encodeImageFile(outputPath, out)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	check, _, err := image.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		result["success"] = false
+		result["error"] = fmt.Sprintf("verify: %v", err)
+		return result
+	}
+
+	regions := make([]map[string]interface{}, len(rects))
+	for i, rect := range rects {
+		c := checkRedaction(img, out, check, rect, masks[i])
+		if err := c.verify(opts.Regions[i].mode(opts)); err != nil {
+			result["success"] = false
+			result["error"] = fmt.Sprintf("verify: region %d: %v", i, err)
+			return result
+		}
+		regions[i] = map[string]interface{}{
+			"x": rect.Min.X, "y": rect.Min.Y, "width": rect.Dx(), "height": rect.Dy(),
+			"changed": math.Round(c.Changed*1000) / 1000,
+		}
+	}
+
+	if err := writeFileAtomic(outputPath, encoded); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	var removed removedKinds
+	meta := extractMetadata(data)
+	if meta.Exif != nil {
+		removed.add("exif")
+	}
+	if meta.ICC != nil {
+		removed.add("icc")
+	}
+	if meta.XMP != nil {
+		removed.add("xmp")
+	}
+	if removed == nil {
+		removed = removedKinds{}
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["format"] = format
+	result["regions"] = regions
+	result["removed"] = removed
+	return result
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+// stripedImage is black text-like stripes on white
+func stripedImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := 0; y < 64; y += 4 {
+		draw.Draw(img, image.Rect(0, y, 64, y+1), image.Black, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestRedactionVerify(t *testing.T) {
+	orig := stripedImage()
+	rect := orig.Bounds()
+	mask := make([]bool, rect.Dx()*rect.Dy())
+	for i := range mask {
+		mask[i] = true
+	}
+
+	black := image.NewRGBA(rect)
+	draw.Draw(black, rect, image.Black, image.Point{}, draw.Src)
+	halfBlack := image.NewRGBA(rect)
+	draw.Draw(halfBlack, rect, orig, image.Point{}, draw.Src)
+	draw.Draw(halfBlack, image.Rect(0, 0, 64, 32), image.Black, image.Point{}, draw.Src)
+	white := image.NewRGBA(rect)
+	draw.Draw(white, rect, image.White, image.Point{}, draw.Src)
+	faint := image.NewRGBA(rect)
+	draw.Draw(faint, rect, orig, image.Point{}, draw.Src)
+	faint.SetRGBA(0, 0, color.RGBA{40, 40, 40, 255})
+	flat := image.NewRGBA(rect)
+	draw.Draw(flat, rect, image.White, image.Point{}, draw.Src)
+
+	pixelated, _, _, err := redactImage(orig, RedactOptions{Regions: []RedactRegion{{Width: 64, Height: 64}}, Mode: "pixelate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blurred, _, _, err := redactImage(orig, RedactOptions{Regions: []RedactRegion{{Width: 64, Height: 64}}, Mode: "blur"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		orig      image.Image
+		redacted  image.Image
+		out       image.Image
+		mode      string
+		wantError string
+	}{
+		{"fill written", orig, black, black, "fill", ""},
+		{"white fill keeps background", orig, white, white, "fill", ""},
+		{"fill lost by the encoder", orig, black, orig, "fill", "only 0%"},
+		{"fill half written", orig, black, halfBlack, "fill", "only 50%"},
+		{"pixelate written", orig, pixelated, pixelated, "pixelate", ""},
+		{"pixelate with one pixel written", orig, pixelated, faint, "pixelate", "only 0%"},
+		{"blur written", orig, blurred, blurred, "blur", ""},
+		{"blur lost by the encoder", orig, blurred, faint, "blur", "below 4"},
+		{"flat region has nothing to hide", flat, flat, flat, "pixelate", ""},
+	}
+	for _, tt := range tests {
+		err := checkRedaction(tt.orig, tt.redacted, tt.out, rect, mask).verify(tt.mode)
+		switch {
+		case tt.wantError == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)):
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantError)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ REMOVE BACKGROUND MODE ============
+
+// RemoveBgOptions controls background removal
+type RemoveBgOptions struct {
+	Tolerance float64 // max RGB distance from the background colour (0-441)
+	Softness  float64 // edge feather radius in pixels, 0 = hard edge
+}
+
+// borderBackground returns the most common border colour, bucketed to 4 bits
+// per channel and averaged within the winning bucket
+func borderBackground(img *image.NRGBA) [3]float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	sample := func(x, y int) {
+		i := img.PixOffset(x, y)
+		r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+		key := r>>4<<8 | g>>4<<4 | b>>4
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+	}
+	for x := 0; x < w; x++ {
+		sample(x, 0)
+		sample(x, h-1)
+	}
+	for y := 1; y < h-1; y++ {
+		sample(0, y)
+		sample(w-1, y)
+	}
+
+	var best *bucket
+	for _, bk := range buckets {
+		if best == nil || bk.count > best.count {
+			best = bk
+		}
+	}
+	n := float64(best.count)
+	return [3]float64{float64(best.r) / n, float64(best.g) / n, float64(best.b) / n}
+}
+
+// backgroundMask flood-fills from every border pixel within tolerance of bg,
+// marking only background connected to the edge (4-connectivity)
+func backgroundMask(img *image.NRGBA, bg [3]float64, tolerance float64) []bool {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	mask := make([]bool, w*h)
+	tol2 := tolerance * tolerance
+
+	matches := func(idx int) bool {
+		i := (idx/w)*img.Stride + (idx%w)*4
+		if img.Pix[i+3] == 0 {
+			return true // already transparent
+		}
+		dr := float64(img.Pix[i]) - bg[0]
+		dg := float64(img.Pix[i+1]) - bg[1]
+		db := float64(img.Pix[i+2]) - bg[2]
+		return dr*dr+dg*dg+db*db <= tol2
+	}
+
+	queue := make([]int, 0, 2*(w+h))
+	push := func(idx int) {
+		if !mask[idx] && matches(idx) {
+			mask[idx] = true
+			queue = append(queue, idx)
+		}
+	}
+
+	for x := 0; x < w; x++ {
+		push(x)
+		push((h-1)*w + x)
+	}
+	for y := 0; y < h; y++ {
+		push(y * w)
+		push(y*w + w - 1)
+	}
+
+	for len(queue) > 0 {
+		idx := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		x, y := idx%w, idx/w
+		if x > 0 {
+			push(idx - 1)
+		}
+		if x < w-1 {
+			push(idx + 1)
+		}
+		if y > 0 {
+			push(idx - w)
+		}
+		if y < h-1 {
+			push(idx + w)
+		}
+	}
+	return mask
+}
+
+// boxBlurMask blurs a 0/1 coverage map with a separable box filter of the
+// given radius, run twice for a smoother (tent) falloff
+func boxBlurMask(cov []float32, w, h, radius int) {
+	tmp := make([]float32, len(cov))
+	pass := func(src, dst []float32, n, stride, lines, lineStride int) {
+		for l := 0; l < lines; l++ {
+			base := l * lineStride
+			var sum float32
+			for i := -radius; i <= radius; i++ {
+				sum += src[base+max(0, min(n-1, i))*stride]
+			}
+			for i := 0; i < n; i++ {
+				dst[base+i*stride] = sum / float32(2*radius+1)
+				out := max(0, min(n-1, i-radius))
+				in := max(0, min(n-1, i+radius+1))
+				sum += src[base+in*stride] - src[base+out*stride]
+			}
+		}
+	}
+	for iter := 0; iter < 2; iter++ {
+		pass(cov, tmp, w, 1, h, w)
+		pass(tmp, cov, h, w, w, 1)
+	}
+}
+
+// removeBackground makes edge-connected background transparent and feathers
+// the subject's edge. It returns the image, the background colour and the
+// fraction of pixels removed.
+func removeBackground(src image.Image, opts RemoveBgOptions) (*image.NRGBA, [3]float64, float64) {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), src, bounds.Min, draw.Src)
+
+	bg := borderBackground(img)
+	mask := backgroundMask(img, bg, opts.Tolerance)
+
+	// Foreground coverage, softened when feathering
+	cov := make([]float32, w*h)
+	removed := 0
+	for i, isBg := range mask {
+		if isBg {
+			removed++
+		} else {
+			cov[i] = 1
+		}
+	}
+	if radius := int(math.Round(opts.Softness)); radius > 0 {
+		boxBlurMask(cov, w, h, radius)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			idx := y*w + x
+			i := y*img.Stride + x*4
+			if mask[idx] {
+				img.Pix[i+3] = 0
+				continue
+			}
+			// Feathering only fades the subject side of the edge
+			a := float32(img.Pix[i+3]) * cov[idx]
+			img.Pix[i+3] = uint8(a + 0.5)
+		}
+	}
+
+	return img, bg, float64(removed) / float64(w*h)
+}
+
+// removeBackgroundFile removes the background of a file, URL, data URL or
+// stdin ("-") input. Without an output path the PNG is returned as a data URL.
+func removeBackgroundFile(inputPath, outputPath string, opts RemoveBgOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, _, _, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	out, bg, removed := removeBackground(img, opts)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		result["success"] = false
+		result["error"] = fmt.Sprintf("encode: %v", err)
+		return result
+	}
+
+	if outputPath != "" {
+		if err := writeFileAtomic(outputPath, buf.Bytes()); err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+		result["output"] = outputPath
+	} else {
+		result["data"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	result["success"] = true
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["background"] = fmt.Sprintf("#%02x%02x%02x", uint8(bg[0]+0.5), uint8(bg[1]+0.5), uint8(bg[2]+0.5))
+	result["removed"] = math.Round(removed*1000) / 1000
+	return result
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+// artImage builds an image from rows of characters:
+// '.' white, '#' black, 'g' light grey (235), 'r' red, ' ' transparent
+func artImage(rows ...string) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	colors := map[byte]color.NRGBA{
+		'.': {255, 255, 255, 255},
+		'#': {0, 0, 0, 255},
+		'g': {235, 235, 235, 255},
+		'r': {255, 0, 0, 255},
+		' ': {},
+	}
+	for y, row := range rows {
+		for x := 0; x < len(row); x++ {
+			img.SetNRGBA(x, y, colors[row[x]])
+		}
+	}
+	return img
+}
+
+// maskArt renders a mask as rows of 'x' (background) and '-' (kept)
+func maskArt(mask []bool, w int) string {
+	var b strings.Builder
+	for i, bg := range mask {
+		if i > 0 && i%w == 0 {
+			b.WriteByte('\n')
+		}
+		if bg {
+			b.WriteByte('x')
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+func TestBorderBackground(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+		want [3]float64
+	}{
+		{"uniform", artImage("....", ".##.", "...."), [3]float64{255, 255, 255}},
+		{"majority wins", artImage("rr.r", "r##r", "rrrr"), [3]float64{255, 0, 0}},
+		// 235 and 255 share no 4-bit bucket; the larger bucket wins
+		{"bucket average", artImage("g.gg", "g##g", "gggg"), [3]float64{235, 235, 235}},
+	}
+	for _, tt := range tests {
+		if got := borderBackground(tt.img); got != tt.want {
+			t.Errorf("%s: background %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackgroundMask(t *testing.T) {
+	white := [3]float64{255, 255, 255}
+	tests := []struct {
+		name      string
+		rows      []string
+		tolerance float64
+		want      []string
+	}{
+		{
+			name:      "subject kept",
+			rows:      []string{".....", ".###.", ".###.", "....."},
+			tolerance: 10,
+			want:      []string{"xxxxx", "x---x", "x---x", "xxxxx"},
+		},
+		{
+			name:      "enclosed background kept",
+			rows:      []string{".......", ".#####.", ".#...#.", ".#####.", "......."},
+			tolerance: 10,
+			want:      []string{"xxxxxxx", "x-----x", "x-----x", "x-----x", "xxxxxxx"},
+		},
+		{
+			name:      "diagonal gap does not leak",
+			rows:      []string{"......", ".####.", ".#..#.", ".#..#.", ".###..", "......"},
+			tolerance: 10,
+			want:      []string{"xxxxxx", "x----x", "x----x", "x----x", "x---xx", "xxxxxx"},
+		},
+		{
+			name:      "tolerance includes near colours",
+			rows:      []string{"..g..", ".g#g.", "..g.."},
+			tolerance: 40,
+			want:      []string{"xxxxx", "xx-xx", "xxxxx"},
+		},
+		{
+			name:      "tight tolerance stops at near colours",
+			rows:      []string{"..g..", ".g#g.", "..g.."},
+			tolerance: 10,
+			want:      []string{"xx-xx", "x---x", "xx-xx"},
+		},
+		{
+			name:      "transparent pixels count as background",
+			rows:      []string{". ...", "  ###", "....."},
+			tolerance: 10,
+			want:      []string{"xxxxx", "xx---", "xxxxx"},
+		},
+	}
+	for _, tt := range tests {
+		img := artImage(tt.rows...)
+		got := maskArt(backgroundMask(img, white, tt.tolerance), img.Bounds().Dx())
+		if want := strings.Join(tt.want, "\n"); got != want {
+			t.Errorf("%s: mask\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
+
+func TestBoxBlurMask(t *testing.T) {
+	const w, h = 9, 7
+
+	// A constant field stays constant, including at the clamped edges
+	flat := make([]float32, w*h)
+	for i := range flat {
+		flat[i] = 1
+	}
+	boxBlurMask(flat, w, h, 2)
+	for i, v := range flat {
+		if math.Abs(float64(v)-1) > 1e-5 {
+			t.Fatalf("flat field changed at %d: %v", i, v)
+		}
+	}
+
+	// A single point spreads symmetrically and keeps its peak in place
+	point := make([]float32, w*h)
+	point[3*w+4] = 1
+	boxBlurMask(point, w, h, 1)
+	peak := point[3*w+4]
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := point[y*w+x]
+			if v > peak {
+				t.Errorf("(%d,%d) = %v exceeds the peak %v", x, y, v, peak)
+			}
+			if mirror := point[y*w+(8-x)]; math.Abs(float64(v-mirror)) > 1e-6 {
+				t.Errorf("(%d,%d) = %v, mirror %v", x, y, v, mirror)
+			}
+		}
+	}
+	// Two passes of radius 1 reach two pixels out, no further
+	if point[3*w+6] <= 1e-3 || point[3*w+7] > 1e-6 {
+		t.Errorf("spread: 2px %v, 3px %v", point[3*w+6], point[3*w+7])
+	}
+}
+
+func TestRemoveBackground(t *testing.T) {
+	src := artImage(
+		"........",
+		"..####..",
+		"..####..",
+		"..####..",
+		"..####..",
+		"........",
+	)
+
+	tests := []struct {
+		name     string
+		opts     RemoveBgOptions
+		wantEdge func(a uint8) bool // alpha of a subject pixel on the edge
+	}{
+		{"hard edge", RemoveBgOptions{Tolerance: 30}, func(a uint8) bool { return a == 255 }},
+		{"feathered", RemoveBgOptions{Tolerance: 30, Softness: 1}, func(a uint8) bool { return a > 0 && a < 255 }},
+	}
+	for _, tt := range tests {
+		out, bg, removed := removeBackground(src, tt.opts)
+		if bg != [3]float64{255, 255, 255} {
+			t.Errorf("%s: background %v", tt.name, bg)
+		}
+		if want := 32.0 / 48.0; math.Abs(removed-want) > 1e-9 {
+			t.Errorf("%s: removed %v, want %v", tt.name, removed, want)
+		}
+		if a := out.NRGBAAt(0, 0).A; a != 0 {
+			t.Errorf("%s: background alpha %d", tt.name, a)
+		}
+		if a := out.NRGBAAt(2, 1).A; !tt.wantEdge(a) {
+			t.Errorf("%s: edge alpha %d", tt.name, a)
+		}
+		// Colour is untouched; only alpha changes
+		if c := out.NRGBAAt(3, 2); c.R != 0 || c.G != 0 || c.B != 0 {
+			t.Errorf("%s: subject colour %v", tt.name, c)
+		}
+	}
+}
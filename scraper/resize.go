@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ RESIZE MODE ============
+
+// resizeKernels are the x/image/draw interpolators selectable with --kernel
+var resizeKernels = map[string]draw.Interpolator{
+	"nearest":         draw.NearestNeighbor,
+	"approx-bilinear": draw.ApproxBiLinear,
+	"bilinear":        draw.BiLinear,
+	"catmullrom":      draw.CatmullRom,
+}
+
+// ResizeOptions describes the resize target. Precedence: Percent, then
+// MaxMegapixels, then Width/Height. With both Width and Height, Fit
+// "contain" keeps the aspect ratio inside the box and "exact" stretches.
+type ResizeOptions struct {
+	Width         int
+	Height        int
+	Percent       float64
+	MaxMegapixels float64
+	Fit           string // contain, exact
+	Kernel        string
+	KeepMetadata  bool // carry EXIF (orientation reset to 1), ICC and XMP
+}
+
+func (o *ResizeOptions) validate() error {
+	if o.Kernel == "" {
+		o.Kernel = "catmullrom"
+	}
+	if _, ok := resizeKernels[o.Kernel]; !ok {
+		return fmt.Errorf("unknown kernel: %s", o.Kernel)
+	}
+	if o.Fit != "contain" && o.Fit != "exact" {
+		return fmt.Errorf("resize supports fit contain or exact, got: %s", o.Fit)
+	}
+	if o.Percent <= 0 && o.MaxMegapixels <= 0 && o.Width <= 0 && o.Height <= 0 {
+		return fmt.Errorf("width, height, percent or max-mp required")
+	}
+	return nil
+}
+
+// resizeTarget computes the output size for a w x h source
+func resizeTarget(w, h int, opts ResizeOptions) (int, int) {
+	scaleTo := func(scale float64) (int, int) {
+		return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+	}
+
+	switch {
+	case opts.Percent > 0:
+		return scaleTo(opts.Percent / 100)
+
+	case opts.MaxMegapixels > 0:
+		limit := opts.MaxMegapixels * 1e6
+		if float64(w)*float64(h) <= limit {
+			return w, h
+		}
+		return scaleTo(math.Sqrt(limit / (float64(w) * float64(h))))
+
+	case opts.Width > 0 && opts.Height > 0:
+		if opts.Fit == "exact" {
+			return opts.Width, opts.Height
+		}
+		return scaleTo(math.Min(float64(opts.Width)/float64(w), float64(opts.Height)/float64(h)))
+
+	case opts.Width > 0:
+		return scaleTo(float64(opts.Width) / float64(w))
+
+	default:
+		return scaleTo(float64(opts.Height) / float64(h))
+	}
+}
+
+// resizeImage scales img to the target size with the selected kernel
+func resizeImage(img image.Image, opts ResizeOptions) image.Image {
+	bounds := img.Bounds()
+	newW, newH := resizeTarget(bounds.Dx(), bounds.Dy(), opts)
+	if newW == bounds.Dx() && newH == bounds.Dy() {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	resizeKernels[opts.Kernel].Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// resizeImageFile resizes a file, URL or .repic input. EXIF orientation is
+// applied first, so carried metadata is written with orientation 1.
+func resizeImageFile(inputPath, outputPath string, opts ResizeOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+
+	origW, origH := img.Bounds().Dx(), img.Bounds().Dy()
+	out := resizeImage(img, opts)
+
+	if !opts.KeepMetadata {
+		data = nil
+	}
+	if err := writeImageFileMeta(outputPath, out, data, 1); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["original_width"] = origW
+	result["original_height"] = origH
+	result["kernel"] = opts.Kernel
+	result["format"] = format
+	return result
+}
+
+// encodableExt keeps the source extension when we can write that format,
+// otherwise falls back to PNG (e.g. CUR, which is decode-only, and WebP,
+// which we only write losslessly)
+func encodableExt(source string) string {
+	ext := strings.ToLower(filepath.Ext(source))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".ico":
+		return ext
+	}
+	return ".png"
+}
+
+// batchResizeStreaming resizes files into outputDir, keeping each file's format
+func batchResizeStreaming(files []string, outputDir string, opts ResizeOptions, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+encodableExt(source))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return processItemFromResult(source, outputPath, resizeImageFile(source, outputPath, opts))
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ============ SCRAPE DEDUPE ============
+
+// resizeQueryParams are query keys resizing CDNs use for on-the-fly resizing
+// and re-encoding; dropping them asks for the original
+var resizeQueryParams = map[string]bool{
+	"w": true, "h": true, "width": true, "height": true, "resize": true, "fit": true,
+	"crop": true, "quality": true, "q": true, "auto": true, "dpr": true, "fm": true,
+	"strip": true, "ssl": true, "size": true, "zoom": true,
+}
+
+// resizeCDNHost reports whether host serves resized copies through
+// resizeQueryParams: imgix, WordPress Photon, Shopify, Contentful and
+// Sanity. Elsewhere the same keys can select the image itself (Next.js
+// /_next/image needs w and q), so they are left alone.
+func resizeCDNHost(host string) bool {
+	switch {
+	case strings.HasSuffix(host, ".imgix.net"),
+		photonHost.MatchString(host),
+		host == "cdn.shopify.com",
+		host == "images.ctfassets.net",
+		host == "cdn.sanity.io":
+		return true
+	}
+	return false
+}
+
+var (
+	// photonHost matches the WordPress Photon CDN hosts i0.wp.com to i3.wp.com
+	photonHost = regexp.MustCompile(`^i\d\.wp\.com$`)
+	// wordpressSizeSuffix matches the -300x200 WordPress adds to resized uploads
+	wordpressSizeSuffix = regexp.MustCompile(`-\d+x\d+(\.[A-Za-z0-9]+)$`)
+	// imgurSizedID matches a 7-character imgur ID with a size letter appended
+	imgurSizedID = regexp.MustCompile(`^([A-Za-z0-9]{7})[sbtmlh](\.[A-Za-z0-9]+)$`)
+	// thumbSuffix matches thumbnail markers before the extension
+	thumbSuffix = regexp.MustCompile(`(?i)[_-]thumb(?:nail)?(\.[A-Za-z0-9]+)$`)
+)
+
+// canonicalImageURL rewrites size variants towards the original image:
+// resize query parameters are dropped on resizing CDNs, WordPress -WxH and
+// imgur s/b/t/m/l/h suffixes removed. The result is only used to compare
+// URLs, as the rewritten URL need not exist.
+func canonicalImageURL(imgURL string) string {
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return imgURL
+	}
+	u.Fragment = ""
+	host := strings.ToLower(u.Hostname())
+
+	if u.RawQuery != "" && resizeCDNHost(host) {
+		// Only re-encode when something was dropped, so signed queries stay intact
+		query := u.Query()
+		dropped := false
+		for key := range query {
+			if resizeQueryParams[strings.ToLower(key)] {
+				query.Del(key)
+				dropped = true
+			}
+		}
+		if dropped {
+			u.RawQuery = query.Encode()
+		}
+	}
+
+	dir, file := path.Split(u.Path)
+	if host == "imgur.com" || strings.HasSuffix(host, ".imgur.com") {
+		file = imgurSizedID.ReplaceAllString(file, "$1$2")
+	}
+	if strings.Contains(dir, "/wp-content/uploads/") {
+		file = wordpressSizeSuffix.ReplaceAllString(file, "$1")
+	}
+	u.Path = dir + file
+
+	return u.String()
+}
+
+// imageURLKey is the identity used to spot variants of one image: the
+// canonical URL with thumbnail markers folded as well
+func imageURLKey(imgURL string) string {
+	u, err := url.Parse(canonicalImageURL(imgURL))
+	if err != nil {
+		return imgURL
+	}
+	u.Scheme = "https"
+	u.Host = strings.ToLower(u.Host)
+	u.Path = thumbSuffix.ReplaceAllString(u.Path, "$1")
+	return u.String()
+}
+
+// isSizedVariant reports whether imgURL names a resized copy: it carries a
+// thumbnail marker or is changed by canonicalImageURL
+func isSizedVariant(imgURL string) bool {
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return false
+	}
+	u.Fragment = ""
+	return thumbSuffix.MatchString(u.Path) || canonicalImageURL(imgURL) != u.String()
+}
+
+// imageList collects scraped image URLs once each, in page order, keeping
+// the URLs as scraped. Variants of one image share a key; an unsized
+// variant replaces a sized one found earlier.
+type imageList struct {
+	mu   sync.Mutex
+	keys map[string]int
+	urls []string
+}
+
+func newImageList() *imageList {
+	return &imageList{keys: make(map[string]int)}
+}
+
+// add records imgURL unless a variant of it is already listed
+func (l *imageList) add(imgURL string) {
+	key := imageURLKey(imgURL)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i, ok := l.keys[key]; ok {
+		if isSizedVariant(l.urls[i]) && !isSizedVariant(imgURL) {
+			l.urls[i] = imgURL
+		}
+		return
+	}
+	l.keys[key] = len(l.urls)
+	l.urls = append(l.urls, imgURL)
+}
+
+// dedupeImagesByContent fetches every image and keeps the first URL of each
+// distinct content. Images that cannot be fetched are kept, since the
+// viewer reports their errors itself.
+func dedupeImagesByContent(images []string, concurrency int) []string {
+	sums := make([][sha256.Size]byte, len(images))
+	fetched := make([]bool, len(images))
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, imageURL := range images {
+		wg.Add(1)
+		go func(idx int, u string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if data, err := fetchImageBytes(u); err == nil {
+				sums[idx] = sha256.Sum256(data)
+				fetched[idx] = true
+			}
+		}(i, imageURL)
+	}
+	wg.Wait()
+
+	seen := make(map[[sha256.Size]byte]bool)
+	kept := make([]string, 0, len(images))
+	for i, imageURL := range images {
+		if fetched[i] {
+			if seen[sums[i]] {
+				continue
+			}
+			seen[sums[i]] = true
+		}
+		kept = append(kept, imageURL)
+	}
+	return kept
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalImageURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://example.com/a.jpg", "https://example.com/a.jpg"},
+		{"https://example.com/a.jpg#top", "https://example.com/a.jpg"},
+		// Resize parameters only mean resizing on resizing CDNs
+		{"https://acme.imgix.net/a.jpg?w=300&h=200&fit=crop&auto=format", "https://acme.imgix.net/a.jpg"},
+		{"https://i2.wp.com/blog.example/a.jpg?resize=300%2C200&ssl=1", "https://i2.wp.com/blog.example/a.jpg"},
+		{"https://cdn.shopify.com/s/files/a.jpg?v=123&width=400", "https://cdn.shopify.com/s/files/a.jpg?v=123"},
+		{"https://images.ctfassets.net/x/a.jpg?fm=webp&q=80", "https://images.ctfassets.net/x/a.jpg"},
+		{"https://example.com/_next/image?url=%2Fa.jpg&w=640&q=75", "https://example.com/_next/image?url=%2Fa.jpg&w=640&q=75"},
+		{"https://example.com/img.php?id=7&size=large", "https://example.com/img.php?id=7&size=large"},
+		{"https://w.wp.com/a.jpg?w=300", "https://w.wp.com/a.jpg?w=300"},
+		// Signed queries are untouched when nothing is dropped
+		{"https://acme.imgix.net/a.jpg?s=abc%3D&x=1", "https://acme.imgix.net/a.jpg?s=abc%3D&x=1"},
+		// Size suffixes
+		{"https://blog.example/wp-content/uploads/2024/05/photo-300x200.jpg", "https://blog.example/wp-content/uploads/2024/05/photo.jpg"},
+		{"https://blog.example/images/photo-300x200.jpg", "https://blog.example/images/photo-300x200.jpg"},
+		{"https://i.imgur.com/AbCdEfGm.jpg", "https://i.imgur.com/AbCdEfG.jpg"},
+		{"https://i.imgur.com/AbCdEfG.jpg", "https://i.imgur.com/AbCdEfG.jpg"},
+		{"https://example.com/AbCdEfGm.jpg", "https://example.com/AbCdEfGm.jpg"},
+		// Thumbnail markers are only folded into the key
+		{"https://example.com/a_thumb.jpg", "https://example.com/a_thumb.jpg"},
+	}
+	for _, tt := range tests {
+		if got := canonicalImageURL(tt.in); got != tt.want {
+			t.Errorf("canonicalImageURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestImageList(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []string
+		want []string
+	}{
+		{
+			name: "exact duplicates",
+			add:  []string{"https://example.com/a.jpg", "https://example.com/b.jpg", "https://example.com/a.jpg"},
+			want: []string{"https://example.com/a.jpg", "https://example.com/b.jpg"},
+		},
+		{
+			name: "sized variant is kept as scraped",
+			add:  []string{"https://blog.example/wp-content/uploads/photo-300x200.jpg"},
+			want: []string{"https://blog.example/wp-content/uploads/photo-300x200.jpg"},
+		},
+		{
+			name: "original replaces an earlier variant in place",
+			add: []string{
+				"https://example.com/a_thumb.jpg",
+				"https://example.com/b.jpg",
+				"https://example.com/a.jpg",
+			},
+			want: []string{"https://example.com/a.jpg", "https://example.com/b.jpg"},
+		},
+		{
+			name: "variants after the original are dropped",
+			add: []string{
+				"https://i.imgur.com/AbCdEfG.png",
+				"https://i.imgur.com/AbCdEfGs.png",
+				"http://I.IMGUR.COM/AbCdEfGl.png",
+			},
+			want: []string{"https://i.imgur.com/AbCdEfG.png"},
+		},
+		{
+			name: "first of several variants without an original",
+			add: []string{
+				"https://acme.imgix.net/a.jpg?w=300",
+				"https://acme.imgix.net/a.jpg?w=1200",
+			},
+			want: []string{"https://acme.imgix.net/a.jpg?w=300"},
+		},
+		{
+			name: "query keys outside resizing CDNs select different images",
+			add: []string{
+				"https://example.com/_next/image?url=%2Fa.jpg&w=640&q=75",
+				"https://example.com/_next/image?url=%2Fb.jpg&w=640&q=75",
+				"https://example.com/_next/image?url=%2Fa.jpg&w=1080&q=75",
+			},
+			want: []string{
+				"https://example.com/_next/image?url=%2Fa.jpg&w=640&q=75",
+				"https://example.com/_next/image?url=%2Fb.jpg&w=640&q=75",
+				"https://example.com/_next/image?url=%2Fa.jpg&w=1080&q=75",
+			},
+		},
+	}
+	for _, tt := range tests {
+		l := newImageList()
+		for _, u := range tt.add {
+			l.add(u)
+		}
+		if !reflect.DeepEqual(l.urls, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, l.urls, tt.want)
+		}
+	}
+}
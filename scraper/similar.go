@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ============ SIMILAR-IMAGE INDEX ============
+
+// The index is one JSON file listing a perceptual hash, a colour histogram
+// and the dimensions of every image under a folder. Entries are keyed by
+// absolute path and reused while the file's mtime and size are unchanged.
+// Unless --index is given it lives in the user cache dir, not the folder.
+
+// similarIndexVersion changes whenever stored features are computed differently
+const similarIndexVersion = 1
+
+// defaultIndexPath is the index file for root when --index is not given:
+// <user cache dir>/repic/index/<hash of the absolute root>.json
+func defaultIndexPath(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cacheDir, "repic", "index", hex.EncodeToString(sum[:16])+".json"), nil
+}
+
+// Histogram bins per channel (4 x 4 x 4 = 64 RGB bins)
+const histogramLevels = 4
+
+// Score weights: structure dominates, colour and shape break ties
+const (
+	similarHashWeight   = 0.6
+	similarColorWeight  = 0.3
+	similarAspectWeight = 0.1
+)
+
+// IndexEntry holds the features of one indexed file
+type IndexEntry struct {
+	Path      string    `json:"path"`
+	ModTime   int64     `json:"mtime"`
+	FileSize  int64     `json:"file_size"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	PHash     string    `json:"phash"` // 16 hex digits
+	Histogram []float64 `json:"histogram"`
+}
+
+// SimilarIndex is the on-disk index
+type SimilarIndex struct {
+	Version int          `json:"version"`
+	Root    string       `json:"root"`
+	Entries []IndexEntry `json:"entries"`
+}
+
+// SimilarMatch is one query result
+type SimilarMatch struct {
+	Path     string  `json:"path"`
+	Score    float64 `json:"score"`    // 0-1, higher is more similar
+	Distance int     `json:"distance"` // pHash Hamming distance
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Stale    bool    `json:"stale,omitempty"` // changed or missing since indexing
+}
+
+// rgbHistogram returns a normalised 64-bin RGB histogram, weighting each
+// pixel by its alpha so transparent areas do not count
+func rgbHistogram(img image.Image) []float64 {
+	bounds := img.Bounds()
+	if factor := min(bounds.Dx(), bounds.Dy()) / 64; factor >= 2 {
+		img = boxShrink(img, factor)
+		bounds = img.Bounds()
+	}
+
+	hist := make([]float64, histogramLevels*histogramLevels*histogramLevels)
+	total := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+			// Un-premultiply before binning
+			r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+			bin := (r>>14)*histogramLevels*histogramLevels + (g>>14)*histogramLevels + b>>14
+			weight := float64(a) / 0xffff
+			hist[bin] += weight
+			total += weight
+		}
+	}
+	for i := range hist {
+		if total > 0 {
+			hist[i] /= total
+		}
+		hist[i] = math.Round(hist[i]*1e4) / 1e4
+	}
+	return hist
+}
+
+// imageFeatures computes the index features of a decoded (auto-oriented) image
+func imageFeatures(img image.Image) (uint64, []float64) {
+	return perceptualHash(img), rgbHistogram(img)
+}
+
+// loadFeatureImage loads a local file or URL for indexing, auto-oriented
+func loadFeatureImage(source string) (image.Image, error) {
+	img, _, data, err := loadImage(source)
+	if err != nil {
+		return nil, err
+	}
+	if isJPEG(data) {
+		img, _ = transformImage(img, jpegOrientation(data), TransformOptions{Op: "auto-orient"})
+	}
+	return img, nil
+}
+
+// readSimilarIndex loads an index; a missing or outdated file is an empty index
+func readSimilarIndex(path string) (*SimilarIndex, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &SimilarIndex{Version: similarIndexVersion}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index SimilarIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("index: %v", err)
+	}
+	if index.Version != similarIndexVersion {
+		return &SimilarIndex{Version: similarIndexVersion}, nil
+	}
+	return &index, nil
+}
+
+// buildSimilarIndex indexes files (absolute paths under root), reusing
+// entries of indexPath whose mtime and size still match, and dropping
+// entries for files that are gone
+func buildSimilarIndex(indexPath, root string, files []string, concurrency int) map[string]interface{} {
+	old, err := readSimilarIndex(indexPath)
+	if err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	previous := make(map[string]IndexEntry, len(old.Entries))
+	for _, e := range old.Entries {
+		previous[e.Path] = e
+	}
+
+	entries := make([]IndexEntry, len(files))
+	errs := make([]string, len(files))
+	var added, updated, unchanged int
+	var mu sync.Mutex
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func(idx int, path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			info, err := os.Stat(path)
+			if err != nil {
+				errs[idx] = err.Error()
+				return
+			}
+			prev, known := previous[path]
+			if known && prev.ModTime == info.ModTime().UnixNano() && prev.FileSize == info.Size() {
+				entries[idx] = prev
+				mu.Lock()
+				unchanged++
+				mu.Unlock()
+				return
+			}
+
+			img, err := loadFeatureImage(path)
+			if err != nil {
+				errs[idx] = err.Error()
+				return
+			}
+			hash, hist := imageFeatures(img)
+			entries[idx] = IndexEntry{
+				Path:      path,
+				ModTime:   info.ModTime().UnixNano(),
+				FileSize:  info.Size(),
+				Width:     img.Bounds().Dx(),
+				Height:    img.Bounds().Dy(),
+				PHash:     fmt.Sprintf("%016x", hash),
+				Histogram: hist,
+			}
+			mu.Lock()
+			if known {
+				updated++
+			} else {
+				added++
+			}
+			mu.Unlock()
+		}(i, file)
+	}
+	wg.Wait()
+
+	index := SimilarIndex{Version: similarIndexVersion, Root: root, Entries: []IndexEntry{}}
+	failed := []map[string]string{}
+	present := make(map[string]bool, len(files))
+	for i, e := range entries {
+		present[files[i]] = true
+		if errs[i] != "" {
+			failed = append(failed, map[string]string{"source": files[i], "error": errs[i]})
+			continue
+		}
+		index.Entries = append(index.Entries, e)
+	}
+	removed := 0
+	for path := range previous {
+		if !present[path] {
+			removed++
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	if err := writeFileAtomic(indexPath, data); err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+
+	return map[string]interface{}{
+		"success":   true,
+		"index":     indexPath,
+		"count":     len(index.Entries),
+		"added":     added,
+		"updated":   updated,
+		"unchanged": unchanged,
+		"removed":   removed,
+		"failed":    failed,
+	}
+}
+
+// histogramIntersection is the overlap of two normalised histograms (0-1)
+func histogramIntersection(a, b []float64) float64 {
+	sum := 0.0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		sum += math.Min(a[i], b[i])
+	}
+	return sum
+}
+
+// aspectSimilarity compares two aspect ratios (1 = identical)
+func aspectSimilarity(w1, h1, w2, h2 int) float64 {
+	if w1 <= 0 || h1 <= 0 || w2 <= 0 || h2 <= 0 {
+		return 0
+	}
+	a1, a2 := float64(w1)/float64(h1), float64(w2)/float64(h2)
+	return math.Min(a1, a2) / math.Max(a1, a2)
+}
+
+// querySimilarIndex ranks indexed images by similarity to source (a local
+// file or URL) and returns the top n. The query file itself is skipped.
+func querySimilarIndex(indexPath, source string, n int) map[string]interface{} {
+	index, err := readSimilarIndex(indexPath)
+	if err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	if len(index.Entries) == 0 {
+		return map[string]interface{}{"success": false, "error": "index is empty or missing; build it first"}
+	}
+
+	img, err := loadFeatureImage(source)
+	if err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}
+	}
+	hash, hist := imageFeatures(img)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	self := ""
+	if !isRemoteSource(source) {
+		self, _ = filepath.Abs(source)
+	}
+
+	matches := make([]SimilarMatch, 0, len(index.Entries))
+	for _, e := range index.Entries {
+		if e.Path == self {
+			continue
+		}
+		entryHash, err := strconv.ParseUint(e.PHash, 16, 64)
+		if err != nil {
+			continue
+		}
+		distance := hammingDistance(hash, entryHash)
+		score := similarHashWeight*(1-float64(distance)/64) +
+			similarColorWeight*histogramIntersection(hist, e.Histogram) +
+			similarAspectWeight*aspectSimilarity(width, height, e.Width, e.Height)
+		matches = append(matches, SimilarMatch{
+			Path:     e.Path,
+			Score:    math.Round(score*1e4) / 1e4,
+			Distance: distance,
+			Width:    e.Width,
+			Height:   e.Height,
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if n > 0 && len(matches) > n {
+		matches = matches[:n]
+	}
+
+	// Flag results whose files changed since indexing
+	byPath := make(map[string]IndexEntry, len(index.Entries))
+	for _, e := range index.Entries {
+		byPath[e.Path] = e
+	}
+	for i := range matches {
+		e := byPath[matches[i].Path]
+		info, err := os.Stat(e.Path)
+		matches[i].Stale = err != nil || info.ModTime().UnixNano() != e.ModTime || info.Size() != e.FileSize
+	}
+
+	return map[string]interface{}{
+		"success": true,
+		"query":   source,
+		"phash":   fmt.Sprintf("%016x", hash),
+		"matches": matches,
+	}
+}
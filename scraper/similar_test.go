@@ -0,0 +1,207 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeImagePNG encodes img to path
+func writeImagePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRGBHistogram(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	redBin := 3 * histogramLevels * histogramLevels
+	blueBin := 3
+
+	halfRed := blocksImage(red, color.NRGBA{})
+	translucent := blocksImage(red, color.NRGBA{0, 0, 255, 64})
+	tests := []struct {
+		name string
+		img  image.Image
+		want map[int]float64 // non-zero bins
+	}{
+		{"solid", blocksImage(red), map[int]float64{redBin: 1}},
+		{"two colours", blocksImage(red, blue, blue, blue), map[int]float64{redBin: 0.25, blueBin: 0.75}},
+		{"transparent pixels ignored", halfRed, map[int]float64{redBin: 1}},
+		// 64/255 of a pixel's weight for the translucent half
+		{"alpha weighted", translucent, map[int]float64{redBin: 0.7994, blueBin: 0.2006}},
+		{"fully transparent", blocksImage(color.NRGBA{}), map[int]float64{}},
+		{"large image shrunk first", solidImage(400, 300, color.RGBA{0, 0, 255, 255}), map[int]float64{blueBin: 1}},
+	}
+	for _, tt := range tests {
+		hist := rgbHistogram(tt.img)
+		if len(hist) != 64 {
+			t.Fatalf("%s: %d bins", tt.name, len(hist))
+		}
+		for i, v := range hist {
+			if math.Abs(v-tt.want[i]) > 1e-4 {
+				t.Errorf("%s: bin %d = %v, want %v", tt.name, i, v, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestBuildSimilarIndexIncremental(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	paths := make([]string, 3)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, string(rune('a'+i))+".png")
+		writeImagePNG(t, paths[i], sceneImage(64, 48, i*40))
+	}
+	later := time.Now().Add(time.Hour)
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		files  []string
+		want   map[string]int
+	}{
+		{
+			name:  "first build",
+			files: paths,
+			want:  map[string]int{"count": 3, "added": 3},
+		},
+		{
+			name:  "rebuild reuses entries",
+			files: paths,
+			want:  map[string]int{"count": 3, "unchanged": 3},
+		},
+		{
+			name:   "touched file is re-read",
+			change: func() { must(os.Chtimes(paths[0], later, later)) },
+			files:  paths,
+			want:   map[string]int{"count": 3, "updated": 1, "unchanged": 2},
+		},
+		{
+			name:   "resized file is re-read",
+			change: func() { writeImagePNG(t, paths[1], sceneImage(32, 32, 0)) },
+			files:  paths,
+			want:   map[string]int{"count": 3, "updated": 1, "unchanged": 2},
+		},
+		{
+			name:   "missing file is removed",
+			change: func() { must(os.Remove(paths[2])) },
+			files:  paths[:2],
+			want:   map[string]int{"count": 2, "unchanged": 2, "removed": 1},
+		},
+	}
+	for _, tt := range tests {
+		if tt.change != nil {
+			tt.change()
+		}
+		result := buildSimilarIndex(indexPath, dir, tt.files, 2)
+		if result["success"] != true {
+			t.Fatalf("%s: %v", tt.name, result["error"])
+		}
+		for _, key := range []string{"count", "added", "updated", "unchanged", "removed"} {
+			if result[key] != tt.want[key] {
+				t.Errorf("%s: %s = %v, want %d", tt.name, key, result[key], tt.want[key])
+			}
+		}
+	}
+
+	index, err := readSimilarIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := index.Entries[1]; e.Width != 32 || e.Height != 32 {
+		t.Errorf("updated entry is %dx%d, want 32x32", e.Width, e.Height)
+	}
+}
+
+func TestQuerySimilarIndex(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "index.json")
+	files := map[string]image.Image{
+		"query.png":  sceneImage(64, 48, 0),
+		"near.png":   sceneImage(64, 48, 12),
+		"square.png": sceneImage(48, 48, 0),
+		"other.png":  gradientImage(),
+	}
+	var paths []string
+	for name, img := range files {
+		path := filepath.Join(dir, name)
+		writeImagePNG(t, path, img)
+		paths = append(paths, path)
+	}
+	if result := buildSimilarIndex(indexPath, dir, paths, 2); result["success"] != true {
+		t.Fatal(result["error"])
+	}
+
+	result := querySimilarIndex(indexPath, filepath.Join(dir, "query.png"), 0)
+	if result["success"] != true {
+		t.Fatal(result["error"])
+	}
+	matches := result["matches"].([]SimilarMatch)
+	var got []string
+	for _, m := range matches {
+		got = append(got, filepath.Base(m.Path))
+		if m.Stale {
+			t.Errorf("%s marked stale", m.Path)
+		}
+	}
+	if want := "near.png square.png other.png"; strings.Join(got, " ") != want {
+		t.Errorf("ranking %v, want %s", got, want)
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Score > matches[i-1].Score {
+			t.Errorf("scores not descending: %v", matches)
+		}
+	}
+
+	if top := querySimilarIndex(indexPath, filepath.Join(dir, "query.png"), 1)["matches"].([]SimilarMatch); len(top) != 1 {
+		t.Errorf("top 1 returned %d matches", len(top))
+	}
+	if result := querySimilarIndex(filepath.Join(dir, "none.json"), filepath.Join(dir, "query.png"), 0); result["success"] != false {
+		t.Error("query of a missing index succeeded")
+	}
+}
+
+func TestDefaultIndexPath(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("HOME", cache)
+	dir := t.TempDir()
+
+	path, err := defaultIndexPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir, _ := os.UserCacheDir()
+	if !strings.HasPrefix(path, filepath.Join(cacheDir, "repic")) {
+		t.Errorf("index %s is not under the cache dir %s", path, cacheDir)
+	}
+	if strings.HasPrefix(path, dir) {
+		t.Errorf("index %s is inside the indexed folder", path)
+	}
+	if same, _ := defaultIndexPath(dir + string(filepath.Separator) + "."); same != path {
+		t.Errorf("equivalent root gave %s, want %s", same, path)
+	}
+	if other, _ := defaultIndexPath(t.TempDir()); other == path {
+		t.Error("different roots share an index")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ============ IMAGE SOURCES ============
+
+// isRemoteSource reports whether source is an http(s) URL
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// isRepicSource reports whether source is a .repic virtual image file
+func isRepicSource(source string) bool {
+	return strings.HasSuffix(strings.ToLower(source), ".repic")
+}
+
+// fetchImageBytes downloads an image through the shared client
+func fetchImageBytes(imageURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept", "image/webp,image/apng,image/*,*/*;q=0.8")
+
+	resp, err := sharedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// readSourceBytes returns the raw bytes of a local file or http(s) URL
+func readSourceBytes(source string) ([]byte, error) {
+	if isRemoteSource(source) {
+		return fetchImageBytes(source)
+	}
+	return os.ReadFile(source)
+}
+
+// ============ .REPIC FILES ============
+
+// RepicFile is the subset of the .repic virtual image format used here
+// (see docs/specs/virtual-image-spec.md and src/utils/repicFile.js)
+type RepicFile struct {
+	Type     string          `json:"type"`
+	URL      string          `json:"url"`
+	Name     string          `json:"name"`
+	Crop     *RepicCrop      `json:"crop,omitempty"`
+	Original *RepicDimension `json:"original,omitempty"`
+}
+
+// RepicCrop is a stored crop; unit is "%" (default) or "px"
+type RepicCrop struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Unit   string  `json:"unit,omitempty"`
+}
+
+type RepicDimension struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// readRepicFile loads and validates a .repic file
+func readRepicFile(path string) (*RepicFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var repic RepicFile
+	if err := json.Unmarshal(data, &repic); err != nil {
+		return nil, fmt.Errorf("repic: %v", err)
+	}
+	if !isRemoteSource(repic.URL) {
+		return nil, fmt.Errorf("repic: missing or invalid url")
+	}
+	return &repic, nil
+}
+
+// cropBox converts the stored crop into a box for an image of the given size.
+// Pixel crops are rescaled when the stored original size differs.
+func (r *RepicFile) cropBox(width, height int) (cropBox, bool) {
+	if r.Crop == nil || r.Crop.Width <= 0 || r.Crop.Height <= 0 {
+		return cropBox{}, false
+	}
+
+	c := r.Crop
+	if c.Unit == "px" {
+		box := cropBox{X: c.X, Y: c.Y, W: c.Width, H: c.Height}
+		if r.Original != nil && r.Original.Width > 0 && r.Original.Height > 0 &&
+			(r.Original.Width != width || r.Original.Height != height) {
+			sx := float64(width) / float64(r.Original.Width)
+			sy := float64(height) / float64(r.Original.Height)
+			box = cropBox{X: c.X * sx, Y: c.Y * sy, W: c.Width * sx, H: c.Height * sy}
+		}
+		return box, true
+	}
+
+	return cropBox{X: c.X, Y: c.Y, W: c.Width, H: c.Height, Percent: true}, true
+}
+
+// ============ CROP BOXES ============
+
+// cropBox is a crop rectangle in pixels or percent of the image size
+type cropBox struct {
+	X, Y, W, H float64
+	Percent    bool
+}
+
+// parsePercentBox parses "x,y,w,h" percentages
+func parsePercentBox(s string) (cropBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return cropBox{}, fmt.Errorf("percent box must be x,y,w,h: %s", s)
+	}
+
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return cropBox{}, fmt.Errorf("invalid percent box: %s", s)
+		}
+		v[i] = f
+	}
+	return cropBox{X: v[0], Y: v[1], W: v[2], H: v[3], Percent: true}, nil
+}
+
+// isZero reports whether no crop box was given
+func (b cropBox) isZero() bool {
+	return b.X == 0 && b.Y == 0 && b.W == 0 && b.H == 0
+}
+
+// rect resolves the box against bounds and rejects boxes that are empty or
+// reach outside the image. Percent edges are rounded to the nearest pixel.
+func (b cropBox) rect(bounds image.Rectangle) (image.Rectangle, error) {
+	x0, y0 := int(math.Round(b.X)), int(math.Round(b.Y))
+	x1, y1 := x0+int(math.Round(b.W)), y0+int(math.Round(b.H))
+	if b.Percent {
+		origW := float64(bounds.Dx())
+		origH := float64(bounds.Dy())
+		x0, x1 = int(math.Round(b.X/100*origW)), int(math.Round((b.X+b.W)/100*origW))
+		y0, y1 = int(math.Round(b.Y/100*origH)), int(math.Round((b.Y+b.H)/100*origH))
+	}
+
+	r := image.Rect(x0, y0, x1, y1).Add(bounds.Min)
+	if x1 <= x0 || y1 <= y0 || !r.In(bounds) {
+		return image.Rectangle{}, fmt.Errorf("invalid crop bounds: x=%d y=%d w=%d h=%d (image: %dx%d)",
+			x0, y0, x1-x0, y1-y0, bounds.Dx(), bounds.Dy())
+	}
+	return r, nil
+}
+
+// decodeDataURL returns the payload of a base64 data: URL
+func decodeDataURL(source string) ([]byte, error) {
+	header, payload, ok := strings.Cut(source, ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, fmt.Errorf("unsupported data URL")
+	}
+	return base64.StdEncoding.DecodeString(payload)
+}
+
+// filePathFromURL converts a file:// URL to a local path, dropping any query
+// and the leading slash before a Windows drive letter
+func filePathFromURL(source string) string {
+	p := strings.TrimPrefix(source, "file://")
+	p, _, _ = strings.Cut(p, "?")
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return p
+}
+
+// loadSourceBytes returns the image bytes behind a local file, URL, .repic
+// file, base64 data URL, file:// URL or stdin ("-")
+func loadSourceBytes(source string) ([]byte, error) {
+	switch {
+	case source == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil || !bytes.HasPrefix(data, []byte("data:")) {
+			return data, err
+		}
+		return decodeDataURL(strings.TrimSpace(string(data)))
+	case strings.HasPrefix(source, "data:"):
+		return decodeDataURL(strings.TrimSpace(source))
+	case strings.HasPrefix(source, "file://"):
+		source = filePathFromURL(source)
+	}
+
+	if isRepicSource(source) && !isRemoteSource(source) {
+		repic, err := readRepicFile(source)
+		if err != nil {
+			return nil, err
+		}
+		return fetchImageBytes(repic.URL)
+	}
+	return readSourceBytes(source)
+}
+
+// loadImage decodes a local file, URL or .repic file, returning the raw bytes too
+func loadImage(source string) (image.Image, string, []byte, error) {
+	data, err := loadSourceBytes(source)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("decode: %v", err)
+	}
+	return img, format, data, nil
+}
+
+// loadCropSource decodes a crop input (local file, URL or .repic) and returns
+// the raw bytes and the effective crop box; a .repic stored crop applies when
+// box is empty
+func loadCropSource(source string, box cropBox) (image.Image, string, []byte, cropBox, error) {
+	img, format, data, err := loadImage(source)
+	if err != nil {
+		return nil, "", nil, box, err
+	}
+
+	if box.isZero() && isRepicSource(source) && !isRemoteSource(source) {
+		repic, err := readRepicFile(source)
+		if err != nil {
+			return nil, "", nil, box, err
+		}
+		bounds := img.Bounds()
+		if stored, ok := repic.cropBox(bounds.Dx(), bounds.Dy()); ok {
+			box = stored
+		} else {
+			// No stored crop: the whole image
+			box = cropBox{W: float64(bounds.Dx()), H: float64(bounds.Dy())}
+		}
+	}
+	return img, format, data, box, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestParsePercentBox(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    cropBox
+		wantErr bool
+	}{
+		{in: "10,20,30,40", want: cropBox{X: 10, Y: 20, W: 30, H: 40, Percent: true}},
+		{in: " 0 , 0 , 100 , 100 ", want: cropBox{W: 100, H: 100, Percent: true}},
+		{in: "12.5,0,50.25,33.3", want: cropBox{X: 12.5, W: 50.25, H: 33.3, Percent: true}},
+		{in: "10,20,30", wantErr: true},
+		{in: "10,20,30,40,50", wantErr: true},
+		{in: "10,20,abc,40", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePercentBox(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePercentBox(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parsePercentBox(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCropBoxRect(t *testing.T) {
+	bounds := image.Rect(0, 0, 1000, 600)
+	tests := []struct {
+		name    string
+		box     cropBox
+		bounds  image.Rectangle
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{name: "pixels", box: cropBox{X: 10, Y: 20, W: 300, H: 200}, want: image.Rect(10, 20, 310, 220)},
+		{name: "pixels full", box: cropBox{W: 1000, H: 600}, want: bounds},
+		{name: "pixels offset bounds", box: cropBox{X: 10, Y: 20, W: 30, H: 40}, bounds: image.Rect(5, 5, 1005, 605), want: image.Rect(15, 25, 45, 65)},
+		{name: "percent", box: cropBox{X: 10, Y: 50, W: 50, H: 25, Percent: true}, want: image.Rect(100, 300, 600, 450)},
+		{name: "percent full", box: cropBox{W: 100, H: 100, Percent: true}, want: bounds},
+		{name: "percent rounding", box: cropBox{X: 50.04, Y: 0, W: 49.99, H: 100, Percent: true}, want: image.Rect(500, 0, 1000, 600)},
+		{name: "pixels past edge", box: cropBox{X: 900, Y: 0, W: 200, H: 100}, wantErr: true},
+		{name: "pixels negative origin", box: cropBox{X: -1, Y: 0, W: 10, H: 10}, wantErr: true},
+		{name: "pixels empty", box: cropBox{X: 10, Y: 10, W: 0, H: 10}, wantErr: true},
+		{name: "percent past edge", box: cropBox{X: 0, Y: 0, W: 50, H: 100.1, Percent: true}, wantErr: true},
+		{name: "percent origin outside", box: cropBox{X: 120, Y: -5, W: 10, H: 10, Percent: true}, wantErr: true},
+		{name: "percent rounds to empty", box: cropBox{X: 10, Y: 10, W: 0.01, H: 10, Percent: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		b := bounds
+		if !tt.bounds.Empty() {
+			b = tt.bounds
+		}
+		got, err := tt.box.rect(b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: rect error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: rect = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+	"path/filepath"
+)
+
+// ============ STRIP MODE ============
+
+// StripOptions selects what survives a metadata scrub
+type StripOptions struct {
+	KeepOrientation bool // re-insert a minimal EXIF block holding only the orientation
+	KeepICC         bool // keep the embedded colour profile
+}
+
+var adobeHeader = []byte("Adobe")
+
+// stripMetadata removes EXIF/GPS, XMP, comments and text chunks from JPEG,
+// PNG, WebP and GIF without re-encoding pixels (GIF is re-encoded, which is
+// lossless for palette data). It returns the cleaned bytes and the kinds of
+// metadata removed.
+func stripMetadata(data []byte, opts StripOptions) ([]byte, []string, error) {
+	switch {
+	case isJPEG(data):
+		return stripJPEG(data, opts)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data, opts)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return stripWebP(data, opts)
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return stripGIF(data)
+	case bytes.HasPrefix(data, []byte("BM")), bytes.HasPrefix(data, []byte("\x00\x00\x01\x00")), bytes.HasPrefix(data, []byte("\x00\x00\x02\x00")):
+		// BMP, ICO and CUR have nowhere to keep EXIF or XMP
+		return data, nil, nil
+	}
+	// Other containers (TIFF, AVIF, HEIC...) can carry metadata this scrubber
+	// does not rewrite, so passing them through would leak it
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return nil, nil, fmt.Errorf("cannot strip metadata from %s", format)
+	}
+	return nil, nil, fmt.Errorf("cannot strip metadata from an unrecognised format")
+}
+
+// removedKinds collects removal labels once each, in first-seen order
+type removedKinds []string
+
+func (r *removedKinds) add(kind string) {
+	for _, k := range *r {
+		if k == kind {
+			return
+		}
+	}
+	*r = append(*r, kind)
+}
+
+// keptOrientation returns the orientation to re-insert, or 0 for none
+func keptOrientation(tiff []byte, opts StripOptions) int {
+	if !opts.KeepOrientation || tiff == nil {
+		return 0
+	}
+	exif, err := parseExif(tiff)
+	if err != nil || exif.orientation() == 1 {
+		return 0
+	}
+	return exif.orientation()
+}
+
+// stripJPEG keeps JFIF (APP0), Adobe (APP14, needed for colour decoding) and
+// optionally ICC; every other APPn and COM segment and any data after EOI is dropped
+func stripJPEG(data []byte, opts StripOptions) ([]byte, []string, error) {
+	segments, scan, err := readJPEGSegments(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var removed removedKinds
+	orientation := keptOrientation(findExifSegment(segments), opts)
+
+	kept := make([]jpegSegment, 0, len(segments))
+	for _, seg := range segments {
+		switch {
+		case seg.Marker == markerAPP0 && bytes.HasPrefix(seg.Data, []byte("JFIF\x00")):
+			kept = append(kept, seg)
+		case seg.Marker == 0xEE && bytes.HasPrefix(seg.Data, adobeHeader):
+			kept = append(kept, seg)
+		case seg.Marker == markerAPP2 && bytes.HasPrefix(seg.Data, iccHeader):
+			if opts.KeepICC {
+				kept = append(kept, seg)
+			} else {
+				removed.add("icc")
+			}
+		case seg.Marker == markerAPP1 && bytes.HasPrefix(seg.Data, exifHeader):
+			removed.add("exif")
+		case seg.Marker == markerAPP1 && bytes.HasPrefix(seg.Data, xmpHeader):
+			removed.add("xmp")
+		case seg.Marker == markerCOM:
+			removed.add("comment")
+		case seg.Marker >= markerAPP0 && seg.Marker <= 0xEF:
+			removed.add(fmt.Sprintf("app%d", seg.Marker-markerAPP0))
+		default:
+			kept = append(kept, seg)
+		}
+	}
+
+	// Trailing data after EOI (e.g. multi-picture or vendor trailers)
+	if end := bytes.Index(scan, []byte{0xFF, markerEOI}); end >= 0 && end+2 < len(scan) {
+		scan = scan[:end+2]
+		removed.add("trailer")
+	}
+
+	out := writeJPEGSegments(kept, scan)
+	if orientation > 0 {
+		if out, err = setJPEGOrientation(out, orientation); err != nil {
+			return nil, nil, err
+		}
+	}
+	return out, removed, nil
+}
+
+// stripPNG drops eXIf, text, time and (optionally) iCCP chunks
+func stripPNG(data []byte, opts StripOptions) ([]byte, []string, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var removed removedKinds
+	orientation := keptOrientation(pngMetadata(chunks).Exif, opts)
+
+	kept := make([]pngChunk, 0, len(chunks)+1)
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			removed.add("exif")
+			continue
+		case "iTXt":
+			if bytes.HasPrefix(c.Data, []byte("XML:com.adobe.xmp\x00")) {
+				removed.add("xmp")
+			} else {
+				removed.add("text")
+			}
+			continue
+		case "tEXt", "zTXt":
+			removed.add("text")
+			continue
+		case "tIME":
+			removed.add("time")
+			continue
+		case "iCCP":
+			if !opts.KeepICC {
+				removed.add("icc")
+				continue
+			}
+		case "IDAT":
+			// eXIf must precede the image data
+			if orientation > 0 {
+				kept = append(kept, pngChunk{Type: "eXIf", Data: minimalOrientationTIFF(orientation)})
+				orientation = 0
+			}
+		}
+		kept = append(kept, c)
+	}
+	return writePNGChunks(kept), removed, nil
+}
+
+// WebP VP8X feature flags
+const (
+	webpFlagICC  = 0x20
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP drops EXIF, XMP and (optionally) ICCP chunks and updates the
+// VP8X feature flags to match
+func stripWebP(data []byte, opts StripOptions) ([]byte, []string, error) {
+	chunks, err := readWebPChunks(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var removed removedKinds
+	orientation := keptOrientation(webpMetadata(chunks).Exif, opts)
+
+	kept := make([]webpChunk, 0, len(chunks)+1)
+	for _, c := range chunks {
+		switch c.FourCC {
+		case "EXIF":
+			removed.add("exif")
+			continue
+		case "XMP ":
+			removed.add("xmp")
+			continue
+		case "ICCP":
+			if !opts.KeepICC {
+				removed.add("icc")
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	if orientation > 0 {
+		kept = append(kept, webpChunk{FourCC: "EXIF", Data: minimalOrientationTIFF(orientation)})
+	}
+
+	// Simple (non-VP8X) files carry no metadata
+	if len(kept) > 0 && kept[0].FourCC == "VP8X" && len(kept[0].Data) > 0 {
+		flags := kept[0].Data[0] &^ (webpFlagICC | webpFlagEXIF | webpFlagXMP)
+		for _, c := range kept {
+			switch c.FourCC {
+			case "ICCP":
+				flags |= webpFlagICC
+			case "EXIF":
+				flags |= webpFlagEXIF
+			}
+		}
+		vp8x := append([]byte{flags}, kept[0].Data[1:]...)
+		kept[0] = webpChunk{FourCC: "VP8X", Data: vp8x}
+	}
+
+	return writeWebPChunks(kept), removed, nil
+}
+
+// stripGIF re-encodes all frames, which drops comment and application
+// extensions other than the looping block
+func stripGIF(data []byte) ([]byte, []string, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode: %v", err)
+	}
+
+	var removed removedKinds
+	if bytes.Contains(data, []byte{0x21, 0xFE}) {
+		removed.add("comment")
+	}
+	if bytes.Contains(data, []byte("XMP DataXMP")) {
+		removed.add("xmp")
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, nil, fmt.Errorf("encode: %v", err)
+	}
+	return buf.Bytes(), removed, nil
+}
+
+// stripImageFile scrubs metadata from a file, URL or .repic input
+func stripImageFile(inputPath, outputPath string, opts StripOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	data, err := loadSourceBytes(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	out, removed, err := stripMetadata(data, opts)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	if err := writeFileAtomic(outputPath, out); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	if removed == nil {
+		removed = []string{}
+	}
+	result["success"] = true
+	result["output"] = outputPath
+	result["size"] = int64(len(out))
+	result["original_size"] = int64(len(data))
+	result["removed"] = removed
+	return result
+}
+
+// batchStripStreaming scrubs files into outputDir, keeping their names
+func batchStripStreaming(files []string, outputDir string, opts StripOptions, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		return filepath.Join(outputDir, filepath.Base(source))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		result := stripImageFile(source, outputPath, opts)
+		if ok, _ := result["success"].(bool); !ok {
+			errMsg, _ := result["error"].(string)
+			return ProcessItem{Error: errMsg}
+		}
+		return ProcessItem{Success: true, Output: outputPath}
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// testPattern is a small opaque image with distinct pixels
+func testPattern() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+var testMetadata = imageMetadata{
+	Exif: minimalOrientationTIFF(6),
+	XMP:  []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`),
+	ICC:  []byte("not a real profile, only bytes to carry"),
+}
+
+func taggedJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPattern(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := embedMetadata(buf.Bytes(), testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, scan, err := readJPEGSegments(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments = append(segments, jpegSegment{Marker: markerCOM, Data: []byte("shot on a phone")})
+	return append(writeJPEGSegments(segments, scan), "vendor trailer"...)
+}
+
+func taggedPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testPattern()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := embedMetadata(buf.Bytes(), testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks = append(chunks[:1], append([]pngChunk{
+		{Type: "tEXt", Data: []byte("Author\x00someone")},
+		{Type: "tIME", Data: []byte{0x07, 0xEA, 10, 18, 12, 0, 0}},
+	}, chunks[1:]...)...)
+	return writePNGChunks(chunks)
+}
+
+// taggedWebP builds a VP8X container around an opaque VP8L payload; strip
+// only rewrites chunks, so the bitstream need not decode
+func taggedWebP() []byte {
+	chunks := []webpChunk{
+		{FourCC: "VP8X", Data: []byte{webpFlagICC | webpFlagEXIF | webpFlagXMP, 0, 0, 0, 15, 0, 0, 7, 0, 0}},
+		{FourCC: "ICCP", Data: testMetadata.ICC},
+		{FourCC: "VP8L", Data: []byte("bitstream")},
+		{FourCC: "EXIF", Data: testMetadata.Exif},
+		{FourCC: "XMP ", Data: testMetadata.XMP},
+	}
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	for _, c := range chunks {
+		body.WriteString(c.FourCC)
+		binary.Write(&body, binary.LittleEndian, uint32(len(c.Data)))
+		body.Write(c.Data)
+		if len(c.Data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(body.Len()))
+	return append(out, body.Bytes()...)
+}
+
+func taggedGIF(t *testing.T) []byte {
+	pal := color.Palette{color.Black, color.White}
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), pal)
+	img.SetColorIndex(1, 1, 1)
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	comment := []byte{0x21, 0xFE, 5, 'h', 'e', 'l', 'l', 'o', 0}
+	return append(append(data[:len(data)-1:len(data)-1], comment...), 0x3B)
+}
+
+// decodedPixels decodes data to RGBA for comparison
+func decodedPixels(t *testing.T, data []byte) []uint8 {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	return rgba.Pix
+}
+
+func TestStripMetadata(t *testing.T) {
+	jpegData, pngData, gifData := taggedJPEG(t), taggedPNG(t), taggedGIF(t)
+	tiffData := []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	curData := []byte("\x00\x00\x02\x00\x01\x00\x10\x10\x00\x00\x01\x00\x20\x00")
+	bmpData := []byte("BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00")
+	avifData := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1miaf")
+
+	tests := []struct {
+		name            string
+		data            []byte
+		opts            StripOptions
+		wantRemoved     []string
+		wantICC         bool
+		wantOrientation int  // JPEG only
+		samePixels      bool // decoded output matches the input
+		wantErr         bool
+	}{
+		{name: "jpeg", data: jpegData, wantRemoved: []string{"exif", "xmp", "icc", "comment", "trailer"}, wantOrientation: 1, samePixels: true},
+		{name: "jpeg keep icc and orientation", data: jpegData, opts: StripOptions{KeepICC: true, KeepOrientation: true},
+			wantRemoved: []string{"exif", "xmp", "comment", "trailer"}, wantICC: true, wantOrientation: 6, samePixels: true},
+		{name: "png", data: pngData, wantRemoved: []string{"text", "time", "icc", "exif", "xmp"}, samePixels: true},
+		{name: "png keep icc", data: pngData, opts: StripOptions{KeepICC: true}, wantRemoved: []string{"text", "time", "exif", "xmp"}, wantICC: true, samePixels: true},
+		{name: "webp", data: taggedWebP(), wantRemoved: []string{"icc", "exif", "xmp"}},
+		{name: "webp keep icc", data: taggedWebP(), opts: StripOptions{KeepICC: true}, wantRemoved: []string{"exif", "xmp"}, wantICC: true},
+		{name: "gif", data: gifData, wantRemoved: []string{"comment"}, samePixels: true},
+		{name: "tiff is rejected", data: tiffData, wantErr: true},
+		{name: "avif is rejected", data: avifData, wantErr: true},
+		{name: "cur passes through", data: curData},
+		{name: "bmp passes through", data: bmpData},
+	}
+	for _, tt := range tests {
+		out, removed, err := stripMetadata(tt.data, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(removed) != 0 || len(tt.wantRemoved) != 0 {
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("%s: removed %v, want %v", tt.name, removed, tt.wantRemoved)
+			}
+		}
+		if tt.wantRemoved == nil {
+			if !bytes.Equal(out, tt.data) {
+				t.Errorf("%s: bytes changed", tt.name)
+			}
+			continue
+		}
+
+		meta := extractMetadata(out)
+		if gotICC := bytes.Equal(meta.ICC, testMetadata.ICC); gotICC != tt.wantICC {
+			t.Errorf("%s: ICC kept = %v, want %v", tt.name, gotICC, tt.wantICC)
+		}
+		if meta.XMP != nil {
+			t.Errorf("%s: XMP survived", tt.name)
+		}
+		if tt.wantOrientation > 0 {
+			if got := jpegOrientation(out); got != tt.wantOrientation {
+				t.Errorf("%s: orientation %d, want %d", tt.name, got, tt.wantOrientation)
+			}
+		} else if meta.Exif != nil {
+			t.Errorf("%s: EXIF survived", tt.name)
+		}
+		if tt.samePixels && !bytes.Equal(decodedPixels(t, out), decodedPixels(t, tt.data)) {
+			t.Errorf("%s: pixels changed", tt.name)
+		}
+
+		// Stripping again finds nothing more (a kept orientation is re-inserted
+		// each time, so it is reported again)
+		if tt.opts.KeepOrientation {
+			continue
+		}
+		again, removed, err := stripMetadata(out, tt.opts)
+		if err != nil || len(removed) != 0 || (!tt.samePixels && !bytes.Equal(again, out)) {
+			t.Errorf("%s: second strip removed %v (err %v)", tt.name, removed, err)
+		}
+	}
+}
+
+func TestStripWebPFlags(t *testing.T) {
+	out, _, err := stripMetadata(taggedWebP(), StripOptions{KeepICC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readWebPChunks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fourCCs []string
+	for _, c := range chunks {
+		fourCCs = append(fourCCs, c.FourCC)
+	}
+	if want := []string{"VP8X", "ICCP", "VP8L"}; !reflect.DeepEqual(fourCCs, want) {
+		t.Fatalf("chunks %v, want %v", fourCCs, want)
+	}
+	if flags := chunks[0].Data[0]; flags != webpFlagICC {
+		t.Errorf("VP8X flags %#x, want %#x", flags, webpFlagICC)
+	}
+	if string(chunks[2].Data) != "bitstream" {
+		t.Errorf("image data changed")
+	}
+}
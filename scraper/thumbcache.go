@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============ THUMBNAIL CACHE ============
+
+// Cached thumbnails are stored as <key>.jpg with a <key>.json sidecar.
+// The key covers the source path, mtime, file size and fit parameters,
+// so a changed source never matches a stale entry.
+
+// thumbCacheQuality is used for every cached thumbnail regardless of output mode
+const thumbCacheQuality = 85
+
+// ThumbCacheMeta is the sidecar stored next to each cached thumbnail
+type ThumbCacheMeta struct {
+	Source    string `json:"source"`
+	ModTime   int64  `json:"mtime"`
+	FileSize  int64  `json:"file_size"`
+	Fit       string `json:"fit"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// ThumbCacheEntry describes one cached thumbnail for list/prune output
+type ThumbCacheEntry struct {
+	Key   string         `json:"key"`
+	Path  string         `json:"path"`
+	Bytes int64          `json:"bytes"` // thumbnail plus sidecar
+	Meta  ThumbCacheMeta `json:"meta"`
+}
+
+type ThumbCacheResult struct {
+	Success    bool              `json:"success"`
+	Entries    []ThumbCacheEntry `json:"entries,omitempty"`
+	Count      int               `json:"count"`
+	TotalBytes int64             `json:"total_bytes"`
+	Removed    int               `json:"removed"`
+	FreedBytes int64             `json:"freed_bytes"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// thumbCacheLookup is the resolved cache location for a source file
+type thumbCacheLookup struct {
+	key  string
+	path string
+	meta ThumbCacheMeta
+}
+
+// thumbCacheDescriptor renders the options that affect thumbnail pixels
+func thumbCacheDescriptor(opts ThumbnailOptions) string {
+	fit := opts.Fit
+	r, g, b, a := fit.Background.RGBA()
+	return fmt.Sprintf("%s:%dx%d:%s:%02x%02x%02x%02x:%s", fit.Mode, fit.Width, fit.Height, fit.Gravity, r>>8, g>>8, b>>8, a>>8, opts.Mode)
+}
+
+// resolveThumbCache computes the cache key for a local source file
+func resolveThumbCache(cacheDir, source string, opts ThumbnailOptions) (*thumbCacheLookup, error) {
+	absPath, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := ThumbCacheMeta{
+		Source:   absPath,
+		ModTime:  info.ModTime().UnixNano(),
+		FileSize: info.Size(),
+		Fit:      thumbCacheDescriptor(opts),
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%s", meta.Source, meta.ModTime, meta.FileSize, meta.Fit)))
+	key := hex.EncodeToString(sum[:16])
+
+	return &thumbCacheLookup{
+		key:  key,
+		path: filepath.Join(cacheDir, key+".jpg"),
+		meta: meta,
+	}, nil
+}
+
+// loadThumbCache returns the sidecar for a valid cache entry, or false on miss
+func loadThumbCache(lookup *thumbCacheLookup) (ThumbCacheMeta, bool) {
+	if _, err := os.Stat(lookup.path); err != nil {
+		return ThumbCacheMeta{}, false
+	}
+
+	var meta ThumbCacheMeta
+	data, err := os.ReadFile(strings.TrimSuffix(lookup.path, ".jpg") + ".json")
+	if err != nil || json.Unmarshal(data, &meta) != nil {
+		return ThumbCacheMeta{}, false
+	}
+	if meta.ModTime != lookup.meta.ModTime || meta.FileSize != lookup.meta.FileSize {
+		return ThumbCacheMeta{}, false
+	}
+
+	// Touch so size capping evicts least recently used entries first
+	now := time.Now()
+	os.Chtimes(lookup.path, now, now)
+
+	return meta, true
+}
+
+// storeThumbCache encodes thumb into the cache, writing via temp files so
+// concurrent readers never see a partial entry
+func storeThumbCache(lookup *thumbCacheLookup, thumb image.Image) (ThumbCacheMeta, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbCacheQuality}); err != nil {
+		return ThumbCacheMeta{}, fmt.Errorf("encode: %v", err)
+	}
+
+	meta := lookup.meta
+	meta.Width = thumb.Bounds().Dx()
+	meta.Height = thumb.Bounds().Dy()
+	meta.CreatedAt = time.Now().UnixMilli()
+
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return ThumbCacheMeta{}, err
+	}
+
+	metaPath := strings.TrimSuffix(lookup.path, ".jpg") + ".json"
+	if err := writeFileAtomic(metaPath, metaData); err != nil {
+		return ThumbCacheMeta{}, err
+	}
+	if err := writeFileAtomic(lookup.path, buf.Bytes()); err != nil {
+		return ThumbCacheMeta{}, err
+	}
+	return meta, nil
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and renames it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// CreateTemp uses 0600; match the permissions os.Create would give
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// serveCachedThumbnail fills item from a cache entry according to the output mode.
+// Without an output dir the cache file itself is returned as the output path.
+func serveCachedThumbnail(item ThumbnailItem, cachePath string, meta ThumbCacheMeta, outputDir string, opts ThumbnailOptions) ThumbnailItem {
+	item.Width = meta.Width
+	item.Height = meta.Height
+
+	if opts.Base64 {
+		data, err := os.ReadFile(cachePath)
+		if err != nil {
+			item.Error = err.Error()
+			return item
+		}
+		item.Base64 = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data)
+		item.Success = true
+		return item
+	}
+
+	if outputDir == "" {
+		item.Output = cachePath
+		item.Success = true
+		return item
+	}
+
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	outputPath := filepath.Join(outputDir, thumbnailFilename(item.Source))
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		item.Error = err.Error()
+		return item
+	}
+	item.Output = outputPath
+	item.Success = true
+	return item
+}
+
+// ============ CACHE MAINTENANCE ============
+
+// thumbCacheOperation runs a maintenance op (list, prune) on the cache dir.
+// prune removes orphaned and stale entries, then evicts least recently used
+// entries until the cache fits in maxBytes (0 = no cap).
+func thumbCacheOperation(cacheDir, op string, maxBytes int64) ThumbCacheResult {
+	if cacheDir == "" {
+		return ThumbCacheResult{Success: false, Error: "cache-dir required"}
+	}
+	if op != "list" && op != "prune" {
+		return ThumbCacheResult{Success: false, Error: fmt.Sprintf("unknown cache op: %s", op)}
+	}
+
+	dirEntries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ThumbCacheResult{Success: true}
+		}
+		return ThumbCacheResult{Success: false, Error: err.Error()}
+	}
+
+	result := ThumbCacheResult{Success: true}
+	var entries []ThumbCacheEntry
+	modTimes := make(map[string]time.Time)
+
+	remove := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			if os.Remove(path) == nil {
+				result.FreedBytes += info.Size()
+			}
+		}
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		path := filepath.Join(cacheDir, name)
+
+		// Leftovers from interrupted writes
+		if strings.HasPrefix(name, ".tmp-") {
+			if op == "prune" {
+				remove(path)
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, ".jpg") {
+			continue
+		}
+
+		key := strings.TrimSuffix(name, ".jpg")
+		metaPath := filepath.Join(cacheDir, key+".json")
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+
+		var meta ThumbCacheMeta
+		data, err := os.ReadFile(metaPath)
+		valid := err == nil && json.Unmarshal(data, &meta) == nil
+
+		// An entry is orphaned when its source is gone or has changed since caching
+		if valid {
+			src, err := os.Stat(meta.Source)
+			valid = err == nil && src.ModTime().UnixNano() == meta.ModTime && src.Size() == meta.FileSize
+		}
+
+		if !valid && op == "prune" {
+			remove(path)
+			remove(metaPath)
+			result.Removed++
+			continue
+		}
+
+		// The sidecar counts towards the cap as well
+		size := info.Size()
+		if side, err := os.Stat(metaPath); err == nil {
+			size += side.Size()
+		}
+		entries = append(entries, ThumbCacheEntry{Key: key, Path: path, Bytes: size, Meta: meta})
+		modTimes[key] = info.ModTime()
+	}
+
+	// Sidecars whose thumbnail is missing
+	if op == "prune" {
+		for _, de := range dirEntries {
+			name := de.Name()
+			if !strings.HasSuffix(name, ".json") {
+				continue
+			}
+			key := strings.TrimSuffix(name, ".json")
+			if _, err := os.Stat(filepath.Join(cacheDir, key+".jpg")); os.IsNotExist(err) {
+				remove(filepath.Join(cacheDir, name))
+			}
+		}
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Bytes
+	}
+
+	if op == "prune" && maxBytes > 0 && total > maxBytes {
+		// Oldest access first
+		sort.Slice(entries, func(i, j int) bool {
+			return modTimes[entries[i].Key].Before(modTimes[entries[j].Key])
+		})
+
+		kept := entries[:0]
+		for _, e := range entries {
+			if total > maxBytes {
+				remove(e.Path)
+				remove(filepath.Join(cacheDir, e.Key+".json"))
+				total -= e.Bytes
+				result.Removed++
+				continue
+			}
+			kept = append(kept, e)
+		}
+		entries = kept
+	}
+
+	if op == "list" {
+		result.Entries = entries
+	}
+	result.Count = len(entries)
+	result.TotalBytes = total
+	return result
+}
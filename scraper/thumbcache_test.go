@@ -0,0 +1,107 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateThumbnailCache(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "a.png")
+	writeTestPNG(t, source, 300, 200)
+	// A file where the cache dir should be makes every store fail
+	blocked := filepath.Join(dir, "not-a-dir")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		cacheDir   string
+		outputDir  string
+		wantCached bool // second run served from the cache
+	}{
+		{name: "no cache", outputDir: t.TempDir()},
+		{name: "cache", cacheDir: t.TempDir(), outputDir: t.TempDir(), wantCached: true},
+		{name: "unwritable cache", cacheDir: blocked, outputDir: t.TempDir()},
+	}
+	for _, tt := range tests {
+		opts := ThumbnailOptions{Fit: FitOptions{Mode: "contain", Width: 64, Height: 64, Gravity: "center", Background: color.White}, Mode: "quality", CacheDir: tt.cacheDir}
+		for run := 0; run < 2; run++ {
+			item := generateThumbnail(source, tt.outputDir, opts)
+			if !item.Success || item.Error != "" {
+				t.Errorf("%s run %d: %+v", tt.name, run, item)
+				continue
+			}
+			if item.Width != 64 || item.Height != 42 {
+				t.Errorf("%s run %d: %dx%d, want 64x42", tt.name, run, item.Width, item.Height)
+			}
+			if _, err := os.Stat(item.Output); err != nil {
+				t.Errorf("%s run %d: output: %v", tt.name, run, err)
+			}
+			if want := run == 1 && tt.wantCached; item.Cached != want {
+				t.Errorf("%s run %d: cached %v, want %v", tt.name, run, item.Cached, want)
+			}
+		}
+	}
+}
+
+func TestThumbCachePruneCountsSidecars(t *testing.T) {
+	srcDir, cacheDir := t.TempDir(), t.TempDir()
+	opts := ThumbnailOptions{Fit: FitOptions{Mode: "contain", Width: 32, Height: 32, Gravity: "center", Background: color.White}, Mode: "quality", CacheDir: cacheDir}
+
+	var sizes []int64
+	for i, name := range []string{"a.png", "b.png", "c.png"} {
+		source := filepath.Join(srcDir, name)
+		writeTestPNG(t, source, 100, 100)
+		if item := generateThumbnail(source, "", opts); !item.Success {
+			t.Fatalf("%s: %s", name, item.Error)
+		}
+		lookup, err := resolveThumbCache(cacheDir, source, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jpg, _ := os.Stat(lookup.path)
+		side, _ := os.Stat(filepath.Join(cacheDir, lookup.key+".json"))
+		sizes = append(sizes, jpg.Size()+side.Size())
+		// Oldest access first: a, then b, then c
+		old := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(lookup.path, old, old)
+	}
+
+	list := thumbCacheOperation(cacheDir, "list", 0)
+	if want := sizes[0] + sizes[1] + sizes[2]; list.TotalBytes != want {
+		t.Errorf("list total %d, want %d (thumbnails plus sidecars)", list.TotalBytes, want)
+	}
+
+	// A cap that fits the two newest thumbnails' images but not their sidecars
+	limit := sizes[1] + sizes[2] - 1
+	prune := thumbCacheOperation(cacheDir, "prune", limit)
+	if prune.TotalBytes > limit {
+		t.Errorf("pruned to %d bytes, over the %d byte cap", prune.TotalBytes, limit)
+	}
+	if prune.Removed != 2 || prune.Count != 1 || prune.TotalBytes != sizes[2] {
+		t.Errorf("prune removed %d, kept %d (%d bytes); want 2 removed, c kept (%d bytes)", prune.Removed, prune.Count, prune.TotalBytes, sizes[2])
+	}
+}
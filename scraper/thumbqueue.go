@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============ THUMBNAIL QUEUE ============
+
+// StreamOptions controls scheduling and emission for streamed thumbnails
+type StreamOptions struct {
+	Ordered  bool         // emit in input order instead of completion order
+	Window   int          // how far past the next unemitted index dispatch may run when ordered
+	Priority []indexRange // ranges dispatched before everything else
+	Serve    bool         // keep running and read control commands from stdin
+}
+
+// indexRange is an inclusive range of input indices
+type indexRange struct {
+	Lo, Hi int
+}
+
+// parseIndexRanges parses "0-40,100-120" or "7" into inclusive ranges
+func parseIndexRanges(s string) ([]indexRange, error) {
+	var ranges []indexRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		loStr, hiStr, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(loStr))
+		if err != nil || lo < 0 {
+			return nil, fmt.Errorf("invalid priority range: %s", part)
+		}
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(strings.TrimSpace(hiStr))
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid priority range: %s", part)
+			}
+		}
+		ranges = append(ranges, indexRange{Lo: lo, Hi: hi})
+	}
+	return ranges, nil
+}
+
+// thumbQueue holds undispatched input indices. Items inside the priority
+// ranges are handed out first (lowest index first), then the rest in order.
+// A limit restricts dispatch to indices below it, so an ordered stream never
+// buffers more than the window allows.
+type thumbQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	files    []string
+	pending  []int // sorted undispatched indices
+	priority []indexRange
+	limit    int // only indices below limit are dispatched; -1 = no limit
+	closed   bool
+}
+
+func newThumbQueue(priority []indexRange) *thumbQueue {
+	q := &thumbQueue{priority: priority, limit: -1}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// add appends files and returns how many were queued
+func (q *thumbQueue) add(files []string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	added := 0
+	for _, file := range cleanFileList(files) {
+		q.pending = append(q.pending, len(q.files))
+		q.files = append(q.files, file)
+		added++
+	}
+	q.cond.Broadcast()
+	return added
+}
+
+// setPriority replaces the priority ranges for everything not yet dispatched
+func (q *thumbQueue) setPriority(ranges []indexRange) {
+	q.mu.Lock()
+	q.priority = ranges
+	q.mu.Unlock()
+}
+
+// setLimit moves the dispatch limit and wakes a blocked pop
+func (q *thumbQueue) setLimit(limit int) {
+	q.mu.Lock()
+	q.limit = limit
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// close marks the queue as complete; pop drains what is left and then stops
+func (q *thumbQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// total returns the number of files ever queued
+func (q *thumbQueue) total() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.files)
+}
+
+// pop blocks until an index below the limit is available, returning false
+// once closed and empty
+func (q *thumbQueue) pop() (int, string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for !q.eligible(0) && !(q.closed && len(q.pending) == 0) {
+		q.cond.Wait()
+	}
+	if len(q.pending) == 0 {
+		return 0, "", false
+	}
+
+	pos := 0
+	for _, r := range q.priority {
+		i := sort.SearchInts(q.pending, r.Lo)
+		if q.eligible(i) && q.pending[i] <= r.Hi {
+			pos = i
+			break
+		}
+	}
+
+	idx := q.pending[pos]
+	q.pending = append(q.pending[:pos], q.pending[pos+1:]...)
+	return idx, q.files[idx], true
+}
+
+// eligible reports whether pending[pos] exists and is below the limit
+func (q *thumbQueue) eligible(pos int) bool {
+	return pos < len(q.pending) && (q.limit < 0 || q.pending[pos] < q.limit)
+}
+
+// ============ SERVE MODE CONTROL ============
+
+// thumbControl is one NDJSON command read from stdin in serve mode:
+//
+//	{"op":"enqueue","files":["a.jpg","b.jpg"]}
+//	{"op":"priority","range":"120-160"}
+//	{"op":"close"}
+type thumbControl struct {
+	Op    string   `json:"op"`
+	Files []string `json:"files,omitempty"`
+	Range string   `json:"range,omitempty"`
+}
+
+// readThumbControl applies stdin commands to the queue until close or EOF.
+// Errors are reported through report so they go out on the single stdout writer.
+func readThumbControl(r io.Reader, q *thumbQueue, report func(msg string)) {
+	defer q.close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var cmd thumbControl
+		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+			report(fmt.Sprintf("control: %v", err))
+			continue
+		}
+
+		switch cmd.Op {
+		case "enqueue":
+			q.add(cmd.Files)
+		case "priority":
+			ranges, err := parseIndexRanges(cmd.Range)
+			if err != nil {
+				report(err.Error())
+				continue
+			}
+			q.setPriority(ranges)
+		case "close":
+			return
+		default:
+			report(fmt.Sprintf("control: unknown op: %s", cmd.Op))
+		}
+	}
+}
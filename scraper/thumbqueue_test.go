@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestThumbQueuePop(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority []indexRange
+		limit    int
+		want     []int // pop order until the queue stalls or drains
+	}{
+		{name: "input order", limit: -1, want: []int{0, 1, 2, 3, 4, 5}},
+		{name: "priority first", priority: []indexRange{{Lo: 3, Hi: 4}}, limit: -1, want: []int{3, 4, 0, 1, 2, 5}},
+		{name: "limit", limit: 3, want: []int{0, 1, 2}},
+		{name: "priority inside limit", priority: []indexRange{{Lo: 2, Hi: 5}}, limit: 4, want: []int{2, 3, 0, 1}},
+	}
+	for _, tt := range tests {
+		q := newThumbQueue(tt.priority)
+		q.add([]string{"a", "b", "c", "d", "e", "f"})
+		q.setLimit(tt.limit)
+		if tt.limit < 0 {
+			q.close()
+		}
+
+		var got []int
+		for range tt.want {
+			idx, _, ok := q.pop()
+			if !ok {
+				break
+			}
+			got = append(got, idx)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: popped %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBatchThumbnailsStreamingOrdered(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 12; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.png", i))
+		// Uneven sizes so completion order differs from input order
+		writeTestPNG(t, path, 40+(i%3)*200, 40+(i%4)*150)
+		files = append(files, path)
+	}
+	opts := ThumbnailOptions{Fit: FitOptions{Mode: "contain", Width: 32, Height: 32, Gravity: "center", Background: color.White}, Mode: "quality", Base64: true}
+
+	tests := []struct {
+		name   string
+		stream StreamOptions
+	}{
+		{name: "ordered", stream: StreamOptions{Ordered: true, Window: 3}},
+		{name: "ordered with priority", stream: StreamOptions{Ordered: true, Window: 3, Priority: []indexRange{{Lo: 8, Hi: 11}}}},
+		{name: "priority beyond window", stream: StreamOptions{Ordered: true, Window: 1, Priority: []indexRange{{Lo: 10, Hi: 11}}}},
+	}
+	for _, tt := range tests {
+		lines := captureStdout(t, func() {
+			batchThumbnailsStreaming(files, "", opts, 4, tt.stream)
+		})
+
+		var got []int
+		for _, line := range lines {
+			var item struct {
+				Type    string `json:"type"`
+				Index   int    `json:"index"`
+				Success bool   `json:"success"`
+			}
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if item.Type == "summary" {
+				continue
+			}
+			if !item.Success {
+				t.Errorf("%s: item %d failed: %s", tt.name, item.Index, line)
+			}
+			got = append(got, item.Index)
+		}
+		for i, idx := range got {
+			if idx != i {
+				t.Errorf("%s: emitted %v, want input order", tt.name, got)
+				break
+			}
+		}
+		if len(got) != len(files) {
+			t.Errorf("%s: emitted %d items, want %d", tt.name, len(got), len(files))
+		}
+	}
+}
+
+// captureStdout runs fn with os.Stdout redirected and returns the lines written
+func captureStdout(t *testing.T, fn func()) []string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+
+	done := make(chan []string)
+	go func() {
+		var lines []string
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		done <- lines
+	}()
+
+	fn()
+	os.Stdout = orig
+	w.Close()
+	return <-done
+}
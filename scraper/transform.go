@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// ============ TRANSFORM MODE ============
+
+// orientMatrix is a 2x2 integer matrix {a, b, c, d} from the dihedral group
+// of 90° rotations and flips, acting on (x, y) with y pointing down
+type orientMatrix [4]int
+
+// Matrices for the eight EXIF orientations (index = tag value)
+var exifOrientations = [9]orientMatrix{
+	1: {1, 0, 0, 1},   // identity
+	2: {-1, 0, 0, 1},  // flip horizontal
+	3: {-1, 0, 0, -1}, // rotate 180
+	4: {1, 0, 0, -1},  // flip vertical
+	5: {0, 1, 1, 0},   // transpose
+	6: {0, -1, 1, 0},  // rotate 90 CW
+	7: {0, -1, -1, 0}, // transverse
+	8: {0, 1, -1, 0},  // rotate 270 CW
+}
+
+// transformOps maps --transform ops to their orientation tag
+var transformOps = map[string]int{
+	"rotate90":   6,
+	"rotate180":  3,
+	"rotate270":  8,
+	"flip-h":     2,
+	"flip-v":     4,
+	"transpose":  5,
+	"transverse": 7,
+}
+
+// TransformOptions describes one geometric transform
+type TransformOptions struct {
+	Op         string      // a transformOps key, "rotate" (uses Angle) or "auto-orient"
+	Angle      float64     // degrees clockwise for "rotate"
+	Background color.Color // fill for arbitrary-angle rotation
+	Lossless   bool        // JPEG only: rewrite the EXIF orientation instead of pixels
+	Strip      bool        // lossless only: scrub other metadata, keeping orientation
+	KeepICC    bool        // with Strip: keep the ICC profile
+}
+
+func (m orientMatrix) mul(o orientMatrix) orientMatrix {
+	return orientMatrix{
+		m[0]*o[0] + m[1]*o[2], m[0]*o[1] + m[1]*o[3],
+		m[2]*o[0] + m[3]*o[2], m[2]*o[1] + m[3]*o[3],
+	}
+}
+
+// orientationOf returns the EXIF tag value for m
+func orientationOf(m orientMatrix) int {
+	for tag := 1; tag <= 8; tag++ {
+		if exifOrientations[tag] == m {
+			return tag
+		}
+	}
+	return 1
+}
+
+// applyOrientMatrix remaps pixels of img by m, swapping dimensions for
+// transposing matrices
+func applyOrientMatrix(img image.Image, m orientMatrix) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	src, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	dstW, dstH := w, h
+	if m[0] == 0 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	// Offsets bring negative coordinates back into range
+	offX, offY := 0, 0
+	if m[0] < 0 || m[1] < 0 {
+		offX = dstW - 1
+	}
+	if m[2] < 0 || m[3] < 0 {
+		offY = dstH - 1
+	}
+
+	for y := 0; y < h; y++ {
+		si := y * src.Stride
+		for x := 0; x < w; x++ {
+			dx := m[0]*x + m[1]*y + offX
+			dy := m[2]*x + m[3]*y + offY
+			di := dy*dst.Stride + dx*4
+			copy(dst.Pix[di:di+4], src.Pix[si+x*4:si+x*4+4])
+		}
+	}
+	return dst
+}
+
+// rotateArbitrary rotates img clockwise by degrees onto a canvas large enough
+// for the rotated bounds, filling uncovered pixels with bg
+func rotateArbitrary(img image.Image, degrees float64, bg color.Color) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	dstW := int(math.Ceil(math.Abs(w*cos) + math.Abs(h*sin)))
+	dstH := int(math.Ceil(math.Abs(w*sin) + math.Abs(h*cos)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	// src -> dst: translate source centre to origin, rotate, move to dst centre
+	cx := float64(bounds.Min.X) + w/2
+	cy := float64(bounds.Min.Y) + h/2
+	tx := float64(dstW)/2 - (cos*cx - sin*cy)
+	ty := float64(dstH)/2 - (sin*cx + cos*cy)
+	aff := f64.Aff3{cos, -sin, tx, sin, cos, ty}
+
+	draw.CatmullRom.Transform(dst, aff, img, bounds, draw.Over, nil)
+	return dst
+}
+
+// transformImage applies a transform to a decoded image. orientation is the
+// source EXIF orientation, which is baked into the pixels first so the op
+// works on the image as viewers display it.
+func transformImage(img image.Image, orientation int, opts TransformOptions) (image.Image, error) {
+	base := exifOrientations[1]
+	if orientation >= 2 && orientation <= 8 {
+		base = exifOrientations[orientation]
+	}
+
+	switch opts.Op {
+	case "auto-orient":
+		if base == exifOrientations[1] {
+			return img, nil
+		}
+		return applyOrientMatrix(img, base), nil
+
+	case "rotate":
+		angle := math.Mod(opts.Angle, 360)
+		if angle < 0 {
+			angle += 360
+		}
+		// Right angles stay exact
+		if tag, ok := map[float64]int{0: 1, 90: 6, 180: 3, 270: 8}[angle]; ok {
+			return applyOrientMatrix(img, exifOrientations[tag].mul(base)), nil
+		}
+		if base != exifOrientations[1] {
+			img = applyOrientMatrix(img, base)
+		}
+		return rotateArbitrary(img, angle, opts.Background), nil
+
+	default:
+		tag, ok := transformOps[opts.Op]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform: %s", opts.Op)
+		}
+		return applyOrientMatrix(img, exifOrientations[tag].mul(base)), nil
+	}
+}
+
+// transformImageFile applies a transform to a file, URL or .repic input.
+// Pixel transforms write an image without EXIF (orientation 1); lossless
+// JPEG transforms only update the orientation tag.
+func transformImageFile(inputPath, outputPath string, opts TransformOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	data, err := loadSourceBytes(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	orientation := 1
+	if isJPEG(data) {
+		orientation = jpegOrientation(data)
+	}
+
+	if opts.Lossless {
+		return losslessTransform(data, orientation, outputPath, opts)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		result["success"] = false
+		result["error"] = fmt.Sprintf("decode: %v", err)
+		return result
+	}
+
+	out, err := transformImage(img, orientation, opts)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	if err := writeImageFile(outputPath, out); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["format"] = format
+	result["orientation"] = 1
+	result["lossless"] = false
+	return result
+}
+
+// losslessTransform composes the op with the current orientation and writes
+// the JPEG with only its orientation tag changed
+func losslessTransform(data []byte, orientation int, outputPath string, opts TransformOptions) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	tag, ok := transformOps[opts.Op]
+	if opts.Op == "rotate" {
+		tag, ok = map[float64]int{0: 1, 90: 6, 180: 3, 270: 8, -90: 8, -180: 3, -270: 6}[opts.Angle]
+	}
+	if !isJPEG(data) || !ok {
+		result["success"] = false
+		result["error"] = "lossless transform requires a JPEG and a 90° rotation, flip or transpose"
+		return result
+	}
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		result["success"] = false
+		result["error"] = fmt.Sprintf("decode: %v", err)
+		return result
+	}
+
+	newOrientation := orientationOf(exifOrientations[tag].mul(exifOrientations[orientation]))
+	out, err := setJPEGOrientation(data, newOrientation)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	if opts.Strip {
+		out, _, err = stripMetadata(out, StripOptions{KeepOrientation: true, KeepICC: opts.KeepICC})
+		if err != nil {
+			result["success"] = false
+			result["error"] = err.Error()
+			return result
+		}
+	}
+	if err := writeFileAtomic(outputPath, out); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	// Report the dimensions viewers will display
+	width, height := cfg.Width, cfg.Height
+	if newOrientation >= 5 {
+		width, height = height, width
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = width
+	result["height"] = height
+	result["format"] = "jpeg"
+	result["orientation"] = newOrientation
+	result["lossless"] = true
+	return result
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// markedImage is a w x h image with a red top-left and a green second pixel,
+// which together pin down any of the eight orientations
+func markedImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{0, 255, 0, 255})
+	return img
+}
+
+func TestApplyOrientMatrix(t *testing.T) {
+	// Where the stored top-left and its right neighbour land for a 3x2 image
+	tests := []struct {
+		tag         int
+		w, h        int
+		red, green  image.Point
+		description string
+	}{
+		{1, 3, 2, image.Pt(0, 0), image.Pt(1, 0), "identity"},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(1, 0), "flip horizontal"},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(1, 1), "rotate 180"},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(1, 1), "flip vertical"},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 1), "transpose"},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 1), "rotate 90 CW"},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 1), "transverse"},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 1), "rotate 270 CW"},
+	}
+	for _, tt := range tests {
+		out := applyOrientMatrix(markedImage(3, 2), exifOrientations[tt.tag])
+		if out.Bounds().Dx() != tt.w || out.Bounds().Dy() != tt.h {
+			t.Errorf("%d (%s): size %v, want %dx%d", tt.tag, tt.description, out.Bounds().Size(), tt.w, tt.h)
+			continue
+		}
+		if got := out.RGBAAt(tt.red.X, tt.red.Y); got.R != 255 {
+			t.Errorf("%d (%s): red not at %v", tt.tag, tt.description, tt.red)
+		}
+		if got := out.RGBAAt(tt.green.X, tt.green.Y); got.G != 255 {
+			t.Errorf("%d (%s): green not at %v", tt.tag, tt.description, tt.green)
+		}
+	}
+}
+
+func TestOrientationComposition(t *testing.T) {
+	// Applying op to an image stored with orientation base
+	tests := []struct {
+		base int
+		op   string
+		want int
+	}{
+		{1, "rotate90", 6},
+		{6, "rotate90", 3},
+		{6, "rotate180", 8},
+		{6, "rotate270", 1},
+		{8, "rotate90", 1},
+		{3, "flip-h", 4},
+		{2, "flip-h", 1},
+		{2, "rotate90", 7},
+		{6, "flip-h", 5},
+		{5, "transpose", 1},
+		{7, "transverse", 1},
+		{4, "flip-v", 1},
+	}
+	for _, tt := range tests {
+		m := exifOrientations[transformOps[tt.op]].mul(exifOrientations[tt.base])
+		if got := orientationOf(m); got != tt.want {
+			t.Errorf("%s on orientation %d = %d, want %d", tt.op, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestTransformImageBakesOrientation(t *testing.T) {
+	// A rotate90 on a file tagged 6 must match rotating the displayed image
+	stored := markedImage(3, 2)
+	displayed, err := transformImage(stored, 6, TransformOptions{Op: "auto-orient"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := applyOrientMatrix(displayed, exifOrientations[6])
+
+	got, err := transformImage(stored, 6, TransformOptions{Op: "rotate90"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotRGBA := got.(*image.RGBA)
+	if gotRGBA.Bounds() != want.Bounds() || string(gotRGBA.Pix) != string(want.Pix) {
+		t.Errorf("rotate90 on orientation 6 differs from rotating the displayed image")
+	}
+
+	if _, err := transformImage(stored, 1, TransformOptions{Op: "spin"}); err == nil {
+		t.Errorf("unknown op: expected an error")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============ DIRECTORY WALK ============
+
+// defaultImageExts is the extension list used when --exts is not given
+const defaultImageExts = ".jpg,.jpeg,.png,.gif,.webp,.bmp,.tif,.tiff,.ico"
+
+// systemFiles are OS-generated files that never count as images
+var systemFiles = map[string]bool{
+	"thumbs.db":   true,
+	"desktop.ini": true,
+	".ds_store":   true,
+	"ehthumbs.db": true,
+}
+
+// WalkOptions controls which files a directory walk returns and in what order
+type WalkOptions struct {
+	Recursive bool
+	Include   []string // globs matched against the base name or relative path
+	Exclude   []string
+	Exts      map[string]bool
+	Sort      string // name, mtime, natural
+	Reverse   bool
+}
+
+// parseExtList turns ".jpg,png, .webp" into a lowercase lookup set
+func parseExtList(s string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	return exts
+}
+
+// splitGlobs splits a comma-separated glob list, dropping empty entries
+func splitGlobs(s string) []string {
+	var globs []string
+	for _, g := range strings.Split(s, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			globs = append(globs, g)
+		}
+	}
+	return globs
+}
+
+// matchAnyGlob reports whether the base name or slash-separated relative path matches a glob
+func matchAnyGlob(globs []string, rel string) bool {
+	base := filepath.Base(rel)
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listImageFiles walks root and returns matching image paths in the requested order.
+// Hidden files and directories (dot-prefixed or OS hidden/system attribute) are skipped.
+func listImageFiles(root string, opts WalkOptions) ([]string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", root)
+	}
+
+	type walkedFile struct {
+		path    string
+		rel     string
+		modTime int64
+	}
+	var found []walkedFile
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped rather than aborting the walk
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		name := d.Name()
+
+		if strings.HasPrefix(name, ".") || systemFiles[strings.ToLower(name)] || isHiddenFile(path, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if !opts.Recursive || matchAnyGlob(opts.Exclude, rel) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !opts.Exts[strings.ToLower(filepath.Ext(name))] {
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAnyGlob(opts.Include, rel) {
+			return nil
+		}
+		if matchAnyGlob(opts.Exclude, rel) {
+			return nil
+		}
+
+		file := walkedFile{path: path, rel: rel}
+		if opts.Sort == "mtime" {
+			if fi, err := d.Info(); err == nil {
+				file.modTime = fi.ModTime().UnixNano()
+			}
+		}
+		found = append(found, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	less := func(a, b walkedFile) bool {
+		switch opts.Sort {
+		case "mtime":
+			if a.modTime != b.modTime {
+				return a.modTime < b.modTime
+			}
+			return a.rel < b.rel
+		case "natural":
+			return naturalLess(strings.ToLower(a.rel), strings.ToLower(b.rel))
+		default: // name
+			return strings.ToLower(a.rel) < strings.ToLower(b.rel)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if opts.Reverse {
+			return less(found[j], found[i])
+		}
+		return less(found[i], found[j])
+	})
+
+	paths := make([]string, len(found))
+	for i, f := range found {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// naturalLess compares strings treating digit runs as numbers ("img2" < "img10")
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		ca, cb := a[0], b[0]
+		if isDigit(ca) && isDigit(cb) {
+			na, restA := splitDigits(a)
+			nb, restB := splitDigits(b)
+
+			// Compare numerically: strip leading zeros, then by length, then lexically
+			ta := strings.TrimLeft(na, "0")
+			tb := strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
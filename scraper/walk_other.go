@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "io/fs"
+
+// isHiddenFile reports OS-level hidden files; other platforms only use the dot prefix
+func isHiddenFile(path string, d fs.DirEntry) bool {
+	return false
+}
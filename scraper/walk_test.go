@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"img2.jpg", "img10.jpg", true},
+		{"img10.jpg", "img2.jpg", false},
+		{"img2.jpg", "img2.jpg", false},
+		{"a.jpg", "b.jpg", true},
+		{"img", "img1", true},
+		{"img1", "img", false},
+		{"img007.jpg", "img7.jpg", false}, // equal value: fewer leading zeros first
+		{"img7.jpg", "img007.jpg", true},
+		{"img007.jpg", "img8.jpg", true},
+		{"2024-1-9", "2024-1-10", true},
+		{"x99999999999999999999", "x100000000000000000000", true}, // beyond uint64
+		{"10", "9a", false},
+		{"", "a", true},
+		{"a", "", false},
+		{"B.jpg", "a.jpg", true}, // byte order, not case-folded
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
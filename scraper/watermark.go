@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// ============ WATERMARK ============
+
+// WatermarkOptions describes a logo or text stamp. Scale and Margin are
+// fractions of the image width so one setting suits a whole batch.
+type WatermarkOptions struct {
+	Logo     string     // PNG logo: local file or URL
+	Text     string     // text stamp, used when Logo is empty
+	Color    color.RGBA // text colour
+	Position string     // center, top-left, top, top-right, left, right, bottom-left, bottom, bottom-right
+	Margin   float64    // gap to the edges (and between tiles)
+	Opacity  float64    // 0-1
+	Scale    float64    // stamp width relative to the image width
+	Tile     bool       // repeat the stamp across the whole image
+}
+
+var validWatermarkPositions = map[string]bool{
+	"center": true, "top-left": true, "top": true, "top-right": true, "left": true,
+	"right": true, "bottom-left": true, "bottom": true, "bottom-right": true,
+}
+
+// watermark is a prepared stamp source, loaded once and shared across a batch
+type watermark struct {
+	opts WatermarkOptions
+	logo image.Image
+	font *opentype.Font
+}
+
+// newWatermark validates opts and loads the logo or font
+func newWatermark(opts WatermarkOptions) (*watermark, error) {
+	if opts.Logo == "" && opts.Text == "" {
+		return nil, fmt.Errorf("watermark: logo or text required")
+	}
+	if !validWatermarkPositions[opts.Position] {
+		return nil, fmt.Errorf("watermark: unknown position: %s", opts.Position)
+	}
+	if opts.Opacity <= 0 || opts.Opacity > 1 {
+		return nil, fmt.Errorf("watermark: opacity must be in (0, 1]")
+	}
+	if opts.Scale <= 0 || opts.Scale > 1 {
+		return nil, fmt.Errorf("watermark: scale must be in (0, 1]")
+	}
+	if opts.Margin < 0 || opts.Margin >= 0.5 {
+		return nil, fmt.Errorf("watermark: margin must be in [0, 0.5)")
+	}
+
+	wm := &watermark{opts: opts}
+	if opts.Logo != "" {
+		logo, _, _, err := loadImage(opts.Logo)
+		if err != nil {
+			return nil, fmt.Errorf("watermark: %v", err)
+		}
+		wm.logo = logo
+		return wm, nil
+	}
+
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("watermark: %v", err)
+	}
+	wm.font = f
+	return wm, nil
+}
+
+// stamp renders the logo or text at the given width
+func (wm *watermark) stamp(width int) (image.Image, error) {
+	width = max(1, width)
+
+	if wm.logo != nil {
+		b := wm.logo.Bounds()
+		height := max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+		dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), wm.logo, b, draw.Src, nil)
+		return dst, nil
+	}
+
+	// Measure at a reference size, then render at the size that fits width
+	const refSize = 100
+	face, err := opentype.NewFace(wm.font, &opentype.FaceOptions{Size: refSize, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	advance := font.MeasureString(face, wm.opts.Text)
+	face.Close()
+	if advance <= 0 {
+		return nil, fmt.Errorf("watermark: empty text")
+	}
+
+	size := refSize * float64(width) / (float64(advance) / 64)
+	face, err = opentype.NewFace(wm.font, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, max(1, height)))
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(wm.opts.Color), Face: face, Dot: fixed.Point26_6{Y: metrics.Ascent}}
+	d.DrawString(wm.opts.Text)
+	return dst, nil
+}
+
+// watermarkOrigin places a stamp of size sw x sh inside a w x h image
+func watermarkOrigin(position string, w, h, sw, sh, margin int) image.Point {
+	x := (w - sw) / 2
+	y := (h - sh) / 2
+	switch position {
+	case "top-left", "left", "bottom-left":
+		x = margin
+	case "top-right", "right", "bottom-right":
+		x = w - sw - margin
+	}
+	switch position {
+	case "top-left", "top", "top-right":
+		y = margin
+	case "bottom-left", "bottom", "bottom-right":
+		y = h - sh - margin
+	}
+	return image.Pt(x, y)
+}
+
+// apply stamps a copy of img
+func (wm *watermark) apply(img image.Image) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	s, err := wm.stamp(int(math.Round(float64(w) * wm.opts.Scale)))
+	if err != nil {
+		return nil, err
+	}
+	sw, sh := s.Bounds().Dx(), s.Bounds().Dy()
+	margin := int(math.Round(float64(w) * wm.opts.Margin))
+	opacity := image.NewUniform(color.Alpha{A: uint8(math.Round(wm.opts.Opacity * 255))})
+
+	put := func(p image.Point) {
+		r := image.Rectangle{Min: p, Max: p.Add(image.Pt(sw, sh))}
+		draw.DrawMask(dst, r, s, image.Point{}, opacity, image.Point{}, draw.Over)
+	}
+
+	if !wm.opts.Tile {
+		put(watermarkOrigin(wm.opts.Position, w, h, sw, sh, margin))
+		return dst, nil
+	}
+
+	// Tiles on a grid with every other row shifted by half a step
+	stepX := sw + max(margin, sw/2)
+	stepY := sh + max(margin, sh)
+	for row, y := 0, margin; y < h; row, y = row+1, y+stepY {
+		x := margin
+		if row%2 == 1 {
+			x -= stepX / 2
+		}
+		for ; x < w; x += stepX {
+			put(image.Pt(x, y))
+		}
+	}
+	return dst, nil
+}
+
+// applyOriented stamps img as it is displayed: JPEG pixels are auto-oriented
+// first so the stamp is upright. It also returns the orientation to record in
+// carried metadata (1 after rotating, 0 to keep the source's).
+func (wm *watermark) applyOriented(img image.Image, data []byte) (*image.RGBA, int, error) {
+	orientation := 0
+	if isJPEG(data) {
+		if o := jpegOrientation(data); o > 1 {
+			img, _ = transformImage(img, o, TransformOptions{Op: "auto-orient"})
+			orientation = 1
+		}
+	}
+	out, err := wm.apply(img)
+	return out, orientation, err
+}
+
+// watermarkImageFile stamps a local file, URL or .repic input; the output
+// format follows the extension like cropImage
+func watermarkImageFile(inputPath, outputPath string, wm *watermark, keepMetadata bool) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	img, format, data, err := loadImage(inputPath)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+	out, orientation, err := wm.applyOriented(img, data)
+	if err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	if !keepMetadata {
+		data = nil
+	}
+	if err := writeImageFileMeta(outputPath, out, data, orientation); err != nil {
+		result["success"] = false
+		result["error"] = err.Error()
+		return result
+	}
+
+	result["success"] = true
+	result["output"] = outputPath
+	result["width"] = out.Bounds().Dx()
+	result["height"] = out.Bounds().Dy()
+	result["format"] = format
+	return result
+}
+
+// batchWatermarkStreaming stamps files into outputDir, keeping their names
+// (decode-only formats are written as PNG)
+func batchWatermarkStreaming(files []string, outputDir string, wm *watermark, keepMetadata bool, concurrency int) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		outputJSON(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	outputPath := func(source string) string {
+		name := filepath.Base(source)
+		return filepath.Join(outputDir, strings.TrimSuffix(name, filepath.Ext(name))+encodableExt(source))
+	}
+	streamBatchOutputs(files, concurrency, outputPath, func(source, outputPath string) ProcessItem {
+		return processItemFromResult(source, outputPath, watermarkImageFile(source, outputPath, wm, keepMetadata))
+	})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	wmWhite = color.RGBA{255, 255, 255, 255}
+	wmRed   = color.RGBA{255, 0, 0, 255}
+)
+
+// redLogo writes a solid red PNG logo and returns its path
+func redLogo(t *testing.T, w, h int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "logo.png")
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solidImage(w, h, wmRed)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWatermarkOrigin(t *testing.T) {
+	// 200x100 image, 40x20 stamp, 10px margin
+	tests := []struct {
+		position string
+		want     image.Point
+	}{
+		{"top-left", image.Pt(10, 10)},
+		{"top", image.Pt(80, 10)},
+		{"top-right", image.Pt(150, 10)},
+		{"left", image.Pt(10, 40)},
+		{"center", image.Pt(80, 40)},
+		{"right", image.Pt(150, 40)},
+		{"bottom-left", image.Pt(10, 70)},
+		{"bottom", image.Pt(80, 70)},
+		{"bottom-right", image.Pt(150, 70)},
+	}
+	for _, tt := range tests {
+		if got := watermarkOrigin(tt.position, 200, 100, 40, 20, 10); got != tt.want {
+			t.Errorf("%s: origin %v, want %v", tt.position, got, tt.want)
+		}
+	}
+}
+
+func TestNewWatermarkValidation(t *testing.T) {
+	valid := WatermarkOptions{Text: "x", Position: "center", Opacity: 0.5, Scale: 0.2, Margin: 0.02}
+	tests := []struct {
+		name   string
+		modify func(o *WatermarkOptions)
+	}{
+		{"no logo or text", func(o *WatermarkOptions) { o.Text = "" }},
+		{"unknown position", func(o *WatermarkOptions) { o.Position = "middle" }},
+		{"zero opacity", func(o *WatermarkOptions) { o.Opacity = 0 }},
+		{"opacity above 1", func(o *WatermarkOptions) { o.Opacity = 1.5 }},
+		{"zero scale", func(o *WatermarkOptions) { o.Scale = 0 }},
+		{"margin of half the width", func(o *WatermarkOptions) { o.Margin = 0.5 }},
+		{"missing logo", func(o *WatermarkOptions) { o.Logo = filepath.Join(t.TempDir(), "none.png") }},
+	}
+	if _, err := newWatermark(valid); err != nil {
+		t.Fatalf("valid options: %v", err)
+	}
+	for _, tt := range tests {
+		opts := valid
+		tt.modify(&opts)
+		if _, err := newWatermark(opts); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestWatermarkApply(t *testing.T) {
+	logo := redLogo(t, 10, 10)
+	tests := []struct {
+		name    string
+		opts    WatermarkOptions
+		stamped []image.Point
+		clear   []image.Point
+	}{
+		{
+			// 0.25 of 200 = 50px stamp, 0.1 of 200 = 20px margin
+			name:    "top-left margin and scale",
+			opts:    WatermarkOptions{Logo: logo, Position: "top-left", Opacity: 1, Scale: 0.25, Margin: 0.1},
+			stamped: []image.Point{{20, 20}, {69, 69}},
+			clear:   []image.Point{{19, 19}, {70, 20}, {20, 70}},
+		},
+		{
+			name:    "bottom-right",
+			opts:    WatermarkOptions{Logo: logo, Position: "bottom-right", Opacity: 1, Scale: 0.25, Margin: 0.1},
+			stamped: []image.Point{{179, 179}, {130, 130}},
+			clear:   []image.Point{{180, 180}, {129, 150}},
+		},
+		{
+			// 20px stamps, 10px margin: steps of 30 across and 40 down,
+			// odd rows shifted left by 15
+			name:    "tiled",
+			opts:    WatermarkOptions{Logo: logo, Position: "center", Opacity: 1, Scale: 0.1, Margin: 0.05, Tile: true},
+			stamped: []image.Point{{10, 10}, {29, 29}, {40, 10}, {190, 10}, {0, 50}, {14, 50}, {25, 50}, {10, 90}},
+			clear:   []image.Point{{9, 10}, {30, 10}, {10, 30}, {15, 50}, {24, 50}, {10, 85}},
+		},
+	}
+	for _, tt := range tests {
+		wm, err := newWatermark(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out, err := wm.apply(solidImage(200, 200, wmWhite))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, p := range tt.stamped {
+			if c := out.RGBAAt(p.X, p.Y); c != wmRed {
+				t.Errorf("%s: (%d,%d) = %v, want stamped", tt.name, p.X, p.Y, c)
+			}
+		}
+		for _, p := range tt.clear {
+			if c := out.RGBAAt(p.X, p.Y); c != wmWhite {
+				t.Errorf("%s: (%d,%d) = %v, want untouched", tt.name, p.X, p.Y, c)
+			}
+		}
+	}
+}
+
+func TestWatermarkOpacity(t *testing.T) {
+	logo := redLogo(t, 10, 10)
+	tests := []struct {
+		opacity float64
+		want    color.RGBA
+	}{
+		{1, wmRed},
+		{0.5, color.RGBA{255, 127, 127, 255}},
+		{0.2, color.RGBA{255, 204, 204, 255}},
+	}
+	for _, tt := range tests {
+		wm, err := newWatermark(WatermarkOptions{Logo: logo, Position: "center", Opacity: tt.opacity, Scale: 0.5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := wm.apply(solidImage(100, 100, wmWhite))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := out.RGBAAt(50, 50)
+		for i, pair := range [][2]uint8{{got.R, tt.want.R}, {got.G, tt.want.G}, {got.B, tt.want.B}} {
+			if d := int(pair[0]) - int(pair[1]); d < -1 || d > 1 {
+				t.Errorf("opacity %v: channel %d = %d, want %d", tt.opacity, i, pair[0], pair[1])
+			}
+		}
+	}
+}
+
+func TestWatermarkTextStamp(t *testing.T) {
+	wm, err := newWatermark(WatermarkOptions{Text: "Sample", Color: color.RGBA{0, 0, 255, 255}, Position: "center", Opacity: 1, Scale: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := wm.stamp(120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := s.Bounds().Dx(); w != 120 {
+		t.Errorf("stamp width %d, want 120", w)
+	}
+	inked := false
+	b := s.Bounds()
+	for y := b.Min.Y; y < b.Max.Y && !inked; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, g, bl, a := s.At(x, y).RGBA(); a == 0xffff && bl == 0xffff && r == 0 && g == 0 {
+				inked = true
+				break
+			}
+		}
+	}
+	if !inked {
+		t.Error("text stamp has no solid pixels in the text colour")
+	}
+}
+
+func TestWatermarkApplyOriented(t *testing.T) {
+	logo := redLogo(t, 10, 10)
+	wm, err := newWatermark(WatermarkOptions{Logo: logo, Position: "top-left", Opacity: 1, Scale: 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 80x40 stored pixels; orientation 6 displays them as 40x80
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, solidImage(80, 40, wmWhite), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	rotated, err := setJPEGOrientation(plain, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upright, err := setJPEGOrientation(plain, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, solidImage(80, 40, wmWhite)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		data            []byte
+		wantSize        image.Point
+		wantOrientation int
+	}{
+		{"rotated jpeg", rotated, image.Pt(40, 80), 1},
+		{"upright jpeg", upright, image.Pt(80, 40), 0},
+		{"jpeg without exif", plain, image.Pt(80, 40), 0},
+		{"png", pngBuf.Bytes(), image.Pt(80, 40), 0},
+	}
+	for _, tt := range tests {
+		img, _, err := image.Decode(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out, orientation, err := wm.applyOriented(img, tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := out.Bounds().Size(); got != tt.wantSize {
+			t.Errorf("%s: size %v, want %v", tt.name, got, tt.wantSize)
+		}
+		if orientation != tt.wantOrientation {
+			t.Errorf("%s: orientation %d, want %d", tt.name, orientation, tt.wantOrientation)
+		}
+		// The stamp is a quarter of the displayed width, at the displayed top-left
+		side := tt.wantSize.X / 4
+		if c := out.RGBAAt(side-1, side-1); c != wmRed {
+			t.Errorf("%s: stamp corner %v", tt.name, c)
+		}
+		if c := out.RGBAAt(side+1, side+1); c.G < 240 {
+			t.Errorf("%s: past the stamp %v", tt.name, c)
+		}
+	}
+}
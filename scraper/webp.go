@@ -0,0 +1,594 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// ============ WEBP LOSSLESS ENCODER ============
+
+// The encoder writes a plain VP8L stream: subtract-green and per-tile
+// predictor transforms, then greedy LZ77 over the residuals with one set of
+// prefix codes for the whole image. No colour cache or meta prefix codes, so
+// files are larger than libwebp's but decode everywhere.
+
+const (
+	vp8lMaxSide       = 1 << 14
+	vp8lPredictorBits = 4 // 16x16 predictor tiles
+	vp8lMaxCodeLength = 15
+	vp8lMinMatch      = 3
+	vp8lMaxMatch      = 4096
+	vp8lMaxDistance   = 1<<20 - 120 // largest distance code, minus the 120 neighbourhood codes
+	vp8lHashBits      = 16
+	vp8lChainDepth    = 32
+)
+
+// vp8lCodeLengthOrder is the order code length code lengths are written in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lWriter packs values LSB first, as VP8L reads them
+type vp8lWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (w *vp8lWriter) write(v uint32, n int) {
+	w.acc |= uint64(v) << w.bits
+	w.bits += uint(n)
+	for w.bits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.bits -= 8
+	}
+}
+
+func (w *vp8lWriter) bytes() []byte {
+	if w.bits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.bits = 0, 0
+	}
+	return w.buf
+}
+
+// encodeWebP writes img as a lossless WebP
+func encodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSide || height > vp8lMaxSide {
+		return fmt.Errorf("webp: %dx%d is outside 1x1 to %dx%d", width, height, vp8lMaxSide, vp8lMaxSide)
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	}
+
+	argb := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < width; x++ {
+			r, g, b, a := row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]
+			alpha = alpha || a != 0xff
+			// Subtract green
+			argb[y*width+x] = uint32(a)<<24 | uint32(r-g)<<16 | uint32(g)<<8 | uint32(b-g)
+		}
+	}
+
+	bw := &vp8lWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are undone in reverse, so the predictor is inverted first
+	bw.write(1, 1)
+	bw.write(2, 2) // subtract green
+	residuals, modes := vp8lPredict(argb, width, height)
+	bw.write(1, 1)
+	bw.write(0, 2) // predictor
+	bw.write(vp8lPredictorBits-2, 3)
+	vp8lWriteImage(bw, modes, false)
+	bw.write(0, 1)
+
+	vp8lWriteImage(bw, residuals, true)
+
+	_, err := w.Write(writeWebPChunks([]webpChunk{{FourCC: "VP8L", Data: bw.bytes()}}))
+	return err
+}
+
+// ============ PREDICTOR TRANSFORM ============
+
+// argbAvg2 averages each channel of two pixels, rounding down
+func argbAvg2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// argbSub subtracts each channel modulo 256
+func argbSub(a, b uint32) uint32 {
+	ag := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	rb := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+func argbChannel(p uint32, shift uint) int {
+	return int(p >> shift & 0xff)
+}
+
+// argbClamp applies f to each channel of a, b and c and clamps to a byte
+func argbClamp(a, b, c uint32, f func(a, b, c int) int) uint32 {
+	var out uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := min(255, max(0, f(argbChannel(a, shift), argbChannel(b, shift), argbChannel(c, shift))))
+		out |= uint32(v) << shift
+	}
+	return out
+}
+
+// vp8lPredictor returns the prediction of mode for pixel i, which is not on
+// the first row or column. TR of the last column wraps to the current row's
+// first pixel, as the decoder reads it.
+func vp8lPredictor(mode int, pix []uint32, i, width int) uint32 {
+	l, t, tl, tr := pix[i-1], pix[i-width], pix[i-width-1], pix[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return argbAvg2(argbAvg2(l, tr), t)
+	case 6:
+		return argbAvg2(l, tl)
+	case 7:
+		return argbAvg2(l, t)
+	case 8:
+		return argbAvg2(tl, t)
+	case 9:
+		return argbAvg2(t, tr)
+	case 10:
+		return argbAvg2(argbAvg2(l, tl), argbAvg2(t, tr))
+	case 11:
+		// Select: whichever of L and T is nearer to L + T - TL
+		if argbDistance(tl, t) < argbDistance(tl, l) {
+			return l
+		}
+		return t
+	case 12:
+		return argbClamp(l, t, tl, func(a, b, c int) int { return a + b - c })
+	default:
+		return argbClamp(argbAvg2(l, t), tl, 0, func(a, b, _ int) int { return a + (a-b)/2 })
+	}
+}
+
+// argbDistance sums the absolute channel differences of two pixels
+func argbDistance(a, b uint32) int {
+	d := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := argbChannel(a, shift) - argbChannel(b, shift)
+		d += max(v, -v)
+	}
+	return d
+}
+
+// residualCost approximates the bits a residual needs: small signed values
+// per channel are cheap
+func residualCost(r uint32) int {
+	cost := 0
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := argbChannel(r, shift)
+		cost += min(v, 256-v)
+	}
+	return cost
+}
+
+// vp8lPredict picks the cheapest predictor mode for each tile and returns the
+// residual image and the tile modes (in the green channel)
+func vp8lPredict(pix []uint32, width, height int) (residuals, modes []uint32) {
+	size := 1 << vp8lPredictorBits
+	tilesX := (width + size - 1) >> vp8lPredictorBits
+	tilesY := (height + size - 1) >> vp8lPredictorBits
+	modes = make([]uint32, tilesX*tilesY)
+	residuals = make([]uint32, len(pix))
+
+	// Fixed predictors: black for the first pixel, L on the first row, T on
+	// the first column
+	residuals[0] = argbSub(pix[0], 0xff000000)
+	for i := 1; i < width; i++ {
+		residuals[i] = argbSub(pix[i], pix[i-1])
+	}
+	for y := 1; y < height; y++ {
+		residuals[y*width] = argbSub(pix[y*width], pix[(y-1)*width])
+	}
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := max(1, tx*size), max(1, ty*size)
+			x1, y1 := min(width, (tx+1)*size), min(height, (ty+1)*size)
+
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := y0; y < y1 && (bestCost < 0 || cost < bestCost); y++ {
+					for x := x0; x < x1; x++ {
+						i := y*width + x
+						cost += residualCost(argbSub(pix[i], vp8lPredictor(mode, pix, i, width)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = uint32(best) << 8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					i := y*width + x
+					residuals[i] = argbSub(pix[i], vp8lPredictor(best, pix, i, width))
+				}
+			}
+		}
+	}
+	return residuals, modes
+}
+
+// ============ ENTROPY CODING ============
+
+// vp8lToken is a literal pixel, or a backward reference when length > 0
+type vp8lToken struct {
+	argb   uint32
+	length int
+	dist   int
+}
+
+// vp8lMatches runs greedy LZ77 over pix with hash chains on pixel pairs
+func vp8lMatches(pix []uint32) []vp8lToken {
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(pix))
+	hash := func(i int) uint32 {
+		return (pix[i]*0x1e35a7bd ^ pix[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < len(pix) {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	tokens := make([]vp8lToken, 0, len(pix)/2)
+	for i := 0; i < len(pix); {
+		bestLen, bestDist := 0, 0
+		if i+1 < len(pix) {
+			limit := min(vp8lMaxMatch, len(pix)-i)
+			for j, depth := head[hash(i)], 0; j >= 0 && depth < vp8lChainDepth; j, depth = prev[j], depth+1 {
+				dist := i - int(j)
+				if dist > vp8lMaxDistance {
+					break
+				}
+				n := 0
+				for n < limit && pix[int(j)+n] == pix[i+n] {
+					n++
+				}
+				if n > bestLen {
+					bestLen, bestDist = n, dist
+					if n == limit {
+						break
+					}
+				}
+			}
+		}
+
+		if bestLen < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{argb: pix[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, vp8lToken{length: bestLen, dist: bestDist})
+		for end := i + bestLen; i < end; i++ {
+			insert(i)
+		}
+	}
+	return tokens
+}
+
+// vp8lPrefix splits a length or distance code into its prefix symbol and
+// extra bits
+func vp8lPrefix(v int) (symbol, extraBits, extra int) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	high := 31
+	for v>>high == 0 {
+		high--
+	}
+	second := v >> (high - 1) & 1
+	extraBits = high - 1
+	return 2*high + second, extraBits, v & (1<<extraBits - 1)
+}
+
+// vp8lDistanceCode maps a linear distance past the 120 neighbourhood codes
+func vp8lDistanceCode(dist int) int {
+	return dist + 120
+}
+
+// prefixCode is a canonical Huffman code. A code with a single symbol is
+// sent with length 1 but costs no bits per symbol.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint32 // bit-reversed, ready to write LSB first
+	single  bool
+}
+
+func (c *prefixCode) writeSymbol(w *vp8lWriter, symbol int) {
+	if !c.single {
+		w.write(c.codes[symbol], int(c.lengths[symbol]))
+	}
+}
+
+// newPrefixCode builds a length-limited Huffman code for the histogram,
+// flattening small counts until the tree fits in maxLength
+func newPrefixCode(histogram []int, maxLength int) *prefixCode {
+	c := &prefixCode{lengths: make([]uint8, len(histogram)), codes: make([]uint32, len(histogram))}
+
+	var used []int
+	for s, n := range histogram {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	switch len(used) {
+	case 0:
+		c.single = true
+		return c
+	case 1:
+		c.lengths[used[0]] = 1
+		c.single = true
+		return c
+	}
+
+	for floor := 1; ; floor *= 2 {
+		freq := make([]int, len(used))
+		for i, s := range used {
+			freq[i] = max(histogram[s], floor)
+		}
+		depths := huffmanDepths(freq)
+		deepest := 0
+		for _, d := range depths {
+			deepest = max(deepest, d)
+		}
+		if deepest <= maxLength {
+			for i, s := range used {
+				c.lengths[s] = uint8(depths[i])
+			}
+			break
+		}
+	}
+
+	// Canonical codes: shorter first, then by symbol
+	var count [vp8lMaxCodeLength + 1]uint32
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeLength + 1]uint32
+	for l, code := 1, uint32(0); l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l > 0 {
+			code := next[l]
+			next[l]++
+			var rev uint32
+			for i := 0; i < int(l); i++ {
+				rev = rev<<1 | code>>i&1
+			}
+			c.codes[s] = rev
+		}
+	}
+	return c
+}
+
+// huffmanDepths returns the Huffman tree depth of each of two or more
+// frequencies
+func huffmanDepths(freq []int) []int {
+	type node struct{ freq, left, right int }
+	nodes := make([]node, len(freq), 2*len(freq)-1)
+	order := make([]int, len(freq))
+	for i, f := range freq {
+		nodes[i] = node{freq: f, left: -1, right: -1}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return freq[order[a]] < freq[order[b]] })
+
+	// Two queues: sorted leaves and internal nodes, which are created in
+	// non-decreasing order
+	leaves, internal := order, []int{}
+	pop := func() int {
+		if len(internal) == 0 || len(leaves) > 0 && nodes[leaves[0]].freq <= nodes[internal[0]].freq {
+			n := leaves[0]
+			leaves = leaves[1:]
+			return n
+		}
+		n := internal[0]
+		internal = internal[1:]
+		return n
+	}
+	for len(leaves)+len(internal) > 1 {
+		a, b := pop(), pop()
+		nodes = append(nodes, node{freq: nodes[a].freq + nodes[b].freq, left: a, right: b})
+		internal = append(internal, len(nodes)-1)
+	}
+
+	depths := make([]int, len(freq))
+	var walk func(n, depth int)
+	walk = func(n, depth int) {
+		if nodes[n].left < 0 {
+			depths[n] = depth
+			return
+		}
+		walk(nodes[n].left, depth+1)
+		walk(nodes[n].right, depth+1)
+	}
+	walk(len(nodes)-1, 0)
+	return depths
+}
+
+// writePrefixCode sends a code's lengths: the simple form for one or two
+// 8-bit symbols, otherwise run-length coded through a code length code
+func writePrefixCode(w *vp8lWriter, c *prefixCode) {
+	var used []int
+	for s, l := range c.lengths {
+		if l > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		w.write(1, 1)
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+		}
+		return
+	}
+
+	// Run-length tokens: 16 repeats the previous length 3-6 times, 17 and
+	// 18 are runs of 3-10 and 11-138 zeros
+	type rle struct{ symbol, extraBits, extra int }
+	var tokens []rle
+	for i := 0; i < len(c.lengths); {
+		l := int(c.lengths[i])
+		run := 1
+		for i+run < len(c.lengths) && int(c.lengths[i+run]) == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, rle{18, 7, n - 11})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, rle{17, 3, run - 3})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, rle{l, 0, 0})
+			run--
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, rle{16, 2, n - 3})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, rle{l, 0, 0})
+		}
+	}
+
+	histogram := make([]int, 19)
+	for _, t := range tokens {
+		histogram[t.symbol]++
+	}
+	lengthCode := newPrefixCode(histogram, 7)
+
+	n := 4
+	for i, s := range vp8lCodeLengthOrder {
+		if lengthCode.lengths[s] > 0 {
+			n = max(n, i+1)
+		}
+	}
+	w.write(0, 1)
+	w.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		w.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	w.write(0, 1) // lengths for the whole alphabet follow
+	for _, t := range tokens {
+		lengthCode.writeSymbol(w, t.symbol)
+		w.write(uint32(t.extra), t.extraBits)
+	}
+}
+
+// vp8lWriteImage entropy codes pix, which is the main image when topLevel is
+// set and a transform sub-image otherwise
+func vp8lWriteImage(w *vp8lWriter, pix []uint32, topLevel bool) {
+	tokens := vp8lMatches(pix)
+
+	green := make([]int, 256+24)
+	red, blue, alpha := make([]int, 256), make([]int, 256), make([]int, 256)
+	dist := make([]int, 40)
+	for _, t := range tokens {
+		if t.length > 0 {
+			l, _, _ := vp8lPrefix(t.length)
+			d, _, _ := vp8lPrefix(vp8lDistanceCode(t.dist))
+			green[256+l]++
+			dist[d]++
+			continue
+		}
+		green[t.argb>>8&0xff]++
+		red[t.argb>>16&0xff]++
+		blue[t.argb&0xff]++
+		alpha[t.argb>>24]++
+	}
+
+	w.write(0, 1) // no colour cache
+	if topLevel {
+		w.write(0, 1) // one prefix code group for the whole image
+	}
+	codes := make([]*prefixCode, 5)
+	for i, h := range [][]int{green, red, blue, alpha, dist} {
+		codes[i] = newPrefixCode(h, vp8lMaxCodeLength)
+		writePrefixCode(w, codes[i])
+	}
+
+	for _, t := range tokens {
+		if t.length > 0 {
+			l, lBits, lExtra := vp8lPrefix(t.length)
+			codes[0].writeSymbol(w, 256+l)
+			w.write(uint32(lExtra), lBits)
+			d, dBits, dExtra := vp8lPrefix(vp8lDistanceCode(t.dist))
+			codes[4].writeSymbol(w, d)
+			w.write(uint32(dExtra), dBits)
+			continue
+		}
+		codes[0].writeSymbol(w, int(t.argb>>8&0xff))
+		codes[1].writeSymbol(w, int(t.argb>>16&0xff))
+		codes[2].writeSymbol(w, int(t.argb&0xff))
+		codes[3].writeSymbol(w, int(t.argb>>24))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	fill := func(w, h int, f func(x, y int) color.NRGBA) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.SetNRGBA(x, y, f(x, y))
+			}
+		}
+		return img
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name string
+		img  *image.NRGBA
+	}{
+		{"single pixel", fill(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{10, 20, 30, 255} })},
+		{"solid", fill(64, 48, func(x, y int) color.NRGBA { return color.NRGBA{200, 100, 50, 255} })},
+		{"one column", fill(1, 37, func(x, y int) color.NRGBA { return color.NRGBA{uint8(y * 7), 0, 255, 255} })},
+		{"one row", fill(37, 1, func(x, y int) color.NRGBA { return color.NRGBA{0, uint8(x * 7), 9, 255} })},
+		{"gradient", fill(83, 61, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 3), uint8(y * 4), uint8(x + y), 255} })},
+		{"alpha", fill(40, 33, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 6), 128, uint8(y * 7), uint8(x * y)} })},
+		{"noise", fill(50, 50, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))}
+		})},
+		{"repeats", fill(100, 30, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x % 5 * 50), uint8(y % 3 * 80), 0, 255} })},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := encodeWebP(&buf, tt.img); err != nil {
+			t.Errorf("%s: encode: %v", tt.name, err)
+			continue
+		}
+		got, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Errorf("%s: decode: %v", tt.name, err)
+			continue
+		}
+		if got.Bounds() != tt.img.Bounds() {
+			t.Errorf("%s: bounds %v, want %v", tt.name, got.Bounds(), tt.img.Bounds())
+			continue
+		}
+		b := tt.img.Bounds()
+	pixels:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if c := color.NRGBAModel.Convert(got.At(x, y)); c != tt.img.NRGBAAt(x, y) {
+					t.Errorf("%s: pixel (%d,%d) = %v, want %v", tt.name, x, y, c, tt.img.NRGBAAt(x, y))
+					break pixels
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebPLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, vp8lMaxSide+1, 1))); err == nil {
+		t.Error("expected an error for an image wider than VP8L allows")
+	}
+}
+
+func TestVP8LPrefix(t *testing.T) {
+	// Inverse of the decoder's lz77Param
+	decode := func(symbol, extra int) int {
+		if symbol < 4 {
+			return symbol + 1
+		}
+		bits := (symbol - 2) >> 1
+		return (2+symbol&1)<<bits + extra + 1
+	}
+	for _, v := range []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 100, 4096, 120 + 1, 120 + 1<<20 - 120} {
+		symbol, bits, extra := vp8lPrefix(v)
+		if extra >= 1<<bits || symbol >= 40 {
+			t.Errorf("%d: symbol %d with %d extra bits holding %d", v, symbol, bits, extra)
+		}
+		if got := decode(symbol, extra); got != v {
+			t.Errorf("%d: decodes as %d", v, got)
+		}
+	}
+}
+
+func TestNewPrefixCodeLengthLimit(t *testing.T) {
+	// Fibonacci counts make the deepest possible unconstrained tree
+	histogram := make([]int, 30)
+	a, b := 1, 1
+	for i := range histogram {
+		histogram[i] = a
+		a, b = b, a+b
+	}
+	for _, limit := range []int{7, 15} {
+		c := newPrefixCode(histogram, limit)
+		kraft := 0.0
+		for s, l := range c.lengths {
+			if l == 0 || int(l) > limit {
+				t.Errorf("limit %d: symbol %d has length %d", limit, s, l)
+			}
+			kraft += 1 / float64(uint(1)<<l)
+		}
+		if kraft != 1 {
+			t.Errorf("limit %d: code is not complete (Kraft sum %v)", limit, kraft)
+		}
+	}
+}
+
+func TestEmbedMetadataWebP(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 20))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := encodeWebP(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	out, err := embedMetadata(buf.Bytes(), testMetadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := extractMetadata(out)
+	if !bytes.Equal(meta.Exif, testMetadata.Exif) || !bytes.Equal(meta.ICC, testMetadata.ICC) || !bytes.Equal(meta.XMP, testMetadata.XMP) {
+		t.Error("metadata did not survive embedding")
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(out))
+	if err != nil || cfg.Width != 300 || cfg.Height != 20 {
+		t.Errorf("VP8X canvas %dx%d (%v), want 300x20", cfg.Width, cfg.Height, err)
+	}
+	chunks, _ := readWebPChunks(out)
+	if want := byte(webpFlagICC | webpFlagEXIF | webpFlagXMP); chunks[0].Data[0] != want {
+		t.Errorf("VP8X flags %#x, want %#x", chunks[0].Data[0], want)
+	}
+	got, err := webp.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if c := color.NRGBAModel.Convert(got.At(1, 0)); c != img.NRGBAAt(1, 0) {
+		t.Errorf("pixel (1,0) = %v, want %v", c, img.NRGBAAt(1, 0))
+	}
+}